@@ -3,6 +3,11 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -15,52 +20,41 @@ func initAca() {
 	setupAcaCmds()
 	setupAcaResourceDefs()
 	RegisteredParsers = append(RegisteredParsers, AcaFromARMJson)
+	ParamFields["Microsoft.App/containerApps"] = []string{
+		"properties.configuration.secrets[].value"}
 }
 
-func setupAcaCmds() {
-	/*
-		cmd := &cobra.Command{
-			Use:   "aca-env",
-			Short: "Add an Azure Container App Environment",
-			Run:   ResourceAddFunc,
-		}
-		cmd.Flags().StringP("name", "n", "", "Name of environment")
-		cmd.MarkFlagRequired("name")
-		AddCmd.AddCommand(cmd)
+// The dev-mode add-on services, each managed via "aca-SERVICE"
+var AcaServices = []string{"redis", "postgres", "kafka", "mariadb", "qdrant"}
 
-		cmd = &cobra.Command{
-			Use:   "aca-env",
-			Short: "Update an Azure Container App Environment",
-			// Run:   ResourceUpdateFunc,
+func IsAcaService(niceType string) bool {
+	service, found := strings.CutPrefix(niceType, "aca-")
+	if !found {
+		return false
+	}
+	for _, s := range AcaServices {
+		if s == service {
+			return true
 		}
-		cmd.Flags().StringP("name", "n", "", "Name of environment")
-		cmd.MarkFlagRequired("name")
-		UpdateCmd.AddCommand(cmd)
-	*/
+	}
+	return false
+}
 
-	// ---
+// "postgres" -> "Postgres"
+func AcaServiceTitle(service string) string {
+	if service == "" {
+		return ""
+	}
+	return strings.ToUpper(service[:1]) + service[1:]
+}
 
+func setupAcaCmds() {
 	cmd := &cobra.Command{
 		Use:   "aca-app",
 		Short: "Add an Azure Container App Application",
 		Run:   AddAcaAppFunc,
 	}
-	cmd.Flags().StringP("name", "n", "", "Name of app")
-	cmd.Flags().StringP("image", "i", "", "Name of container image")
-	cmd.Flags().String("environment", "", "Name of ACA environment")
-	cmd.Flags().StringP("subscription", "s", "", "Subscription ID")
-	cmd.Flags().StringP("resource-group", "g", "", "Resource Group")
-	cmd.Flags().StringP("location", "l", "", "Location")
-	cmd.Flags().StringArrayP("env", "e", nil, "Name/value of env var")
-	cmd.Flags().String("ingress", "", "'internal', or 'external'")
-	cmd.Flags().Bool("external", false, "Enable public access")
-	cmd.Flags().Bool("internal", true, "Disable public access")
-	cmd.Flags().String("port", "", "listen port #")
-	cmd.Flags().StringArray("bind", nil, "Services to connect to")
-	cmd.Flags().StringArray("unbind", nil, "Bindings/services to disconnect")
-	cmd.Flags().Bool("up", false, "Provision after update")
-	cmd.MarkFlagsMutuallyExclusive("internal", "external", "ingress")
-	cmd.MarkFlagRequired("name")
+	addAcaAppFlags(cmd)
 	AddCmd.AddCommand(cmd)
 
 	cmd = &cobra.Command{
@@ -68,22 +62,7 @@ func setupAcaCmds() {
 		Short: "Update an Azure Container App Application",
 		Run:   UpdateAcaAppFunc,
 	}
-	cmd.Flags().StringP("name", "n", "", "Name of app")
-	cmd.Flags().StringP("image", "i", "", "Name of container image")
-	cmd.Flags().String("environment", "", "Name of ACA environment")
-	cmd.Flags().StringP("subscription", "s", "", "Subscription ID")
-	cmd.Flags().StringP("resource-group", "g", "", "Resource Group")
-	cmd.Flags().StringP("location", "l", "", "Location")
-	cmd.Flags().StringArrayP("env", "e", nil, "Name/value of env var")
-	cmd.Flags().String("ingress", "", "'internal', or 'external'")
-	cmd.Flags().Bool("external", false, "Enable public access")
-	cmd.Flags().Bool("internal", true, "Disable public access")
-	cmd.Flags().String("port", "", "listen port #")
-	cmd.Flags().StringArray("bind", nil, "Services to connect to")
-	cmd.Flags().StringArray("unbind", nil, "Bindings/services to disconnect")
-	cmd.Flags().Bool("up", false, "Provision after update")
-	cmd.MarkFlagsMutuallyExclusive("internal", "external", "ingress")
-	cmd.MarkFlagRequired("name")
+	addAcaAppFlags(cmd)
 	UpdateCmd.AddCommand(cmd)
 
 	cmd = &cobra.Command{
@@ -97,33 +76,142 @@ func setupAcaCmds() {
 	cmd.MarkFlagRequired("name")
 	ShowCmd.AddCommand(cmd)
 
-	// ---
+	appCmd := &cobra.Command{
+		Use:   "aca-app",
+		Short: "Manage Azure Container App Applications",
+	}
+	RootCmd.AddCommand(appCmd)
 
 	cmd = &cobra.Command{
-		Use:   "aca-redis",
-		Short: "Add an Azure Container App Redis Service",
-		Run:   AddAcaServiceFunc,
+		Use:   "traffic",
+		Short: "Split ingress traffic between revisions and label them",
+		Run:   TrafficAcaAppFunc,
 	}
-	cmd.Flags().StringP("name", "n", "", "Name of service")
-	cmd.Flags().String("environment", "", "Name of ACA environment")
-	cmd.Flags().StringP("subscription", "s", "", "Subscription ID")
-	cmd.Flags().StringP("resource-group", "g", "", "Resource Group")
-	cmd.Flags().StringP("location", "l", "", "Location")
+	cmd.Flags().StringP("name", "n", "", "Name of app")
+	cmd.Flags().String("set", "", "REVISION=WEIGHT,... weights, REVISION can be 'latest', weights must add up to 100")
+	cmd.Flags().StringArray("label", nil, "LABEL=REVISION label, no REVISION removes the label")
 	cmd.Flags().Bool("up", false, "Provision after update")
 	cmd.MarkFlagRequired("name")
-	AddCmd.AddCommand(cmd)
+	appCmd.AddCommand(cmd)
+
+	envCmd := &cobra.Command{
+		Use:   "env",
+		Short: "Manage the environment variables of an app",
+	}
+	appCmd.AddCommand(envCmd)
 
 	cmd = &cobra.Command{
-		Use:   "aca-redis",
-		Short: "Show details about an Azure Container App Redis Service",
-		Run:   ShowFunc,
+		Use:   "export",
+		Short: "Write an app's environment variables, in dotenv syntax, to stdout",
+		Run:   ExportAcaAppEnvFunc,
 	}
-	cmd.Flags().StringP("name", "n", "", "Name of service")
-	cmd.Flags().String("from", "iac", "Show data from: iac, rest, azure")
-	cmd.Flags().StringP("output", "o", "pretty", "Format (pretty,json)")
+	cmd.Flags().StringP("name", "n", "", "Name of app")
+	cmd.Flags().String("container", "", "Container to export (default: the first one)")
 	cmd.MarkFlagRequired("name")
-	ShowCmd.AddCommand(cmd)
+	envCmd.AddCommand(cmd)
+
+	AddShowCmd("aca-app-auth",
+		"Show details about the built-in authentication of an ACA app",
+		"auth config (APP/current)")
+
+	// ---
+
+	for _, service := range AcaServices {
+		noun := "aca-" + service
+		what := "an Azure Container App " + AcaServiceTitle(service) + " Service"
 
+		cmd = &cobra.Command{
+			Use:   noun,
+			Short: "Add " + what,
+			Run:   AddAcaServiceFunc,
+		}
+		AddResourceFlags(cmd, "service")
+		cmd.Flags().String("environment", "", "Name of ACA environment")
+		AddCmd.AddCommand(cmd)
+
+		cmd = &cobra.Command{
+			Use:   noun,
+			Short: "Update " + what,
+			Run:   UpdateAcaServiceFunc,
+		}
+		AddResourceFlags(cmd, "service")
+		cmd.Flags().String("environment", "", "Name of ACA environment")
+		UpdateCmd.AddCommand(cmd)
+
+		AddShowCmd(noun, "Show details about "+what, "service")
+	}
+}
+
+func addAcaAppFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("name", "n", "", "Name of app")
+	cmd.Flags().StringP("image", "i", "", "Name of container image")
+	cmd.Flags().String("container", "", "Container that --image, --env, etc. apply to, added if needed (default: the first one)")
+	cmd.Flags().String("init-container", "", "Same as --container but for an init container")
+	cmd.Flags().StringArray("remove-container", nil, "Container, or init container, to remove")
+	cmd.Flags().StringArray("probe", nil, "TYPE=http:PATH:PORT|tcp:PORT[,OPTION=N...] probe, TYPE is liveness, readiness or startup")
+	cmd.Flags().StringArray("remove-probe", nil, "Type of probe to remove")
+	cmd.Flags().String("environment", "", "Name of ACA environment")
+	cmd.Flags().String("workload-profile", "", "Environment workload profile to run on, '' for Consumption")
+	cmd.Flags().StringP("subscription", "s", "", "Subscription ID")
+	cmd.Flags().StringP("resource-group", "g", "", "Resource Group")
+	cmd.Flags().StringP("location", "l", "", "Location")
+	cmd.Flags().StringArrayP("env", "e", nil, "Name/value of env var")
+	cmd.Flags().StringArray("env-file", nil, "dotenv file of env vars, values can be 'secretref:SECRET'")
+	cmd.Flags().Bool("clear-env", false, "Remove the env vars that aren't set by --env, --env-file or a --bind")
+	cmd.Flags().String("cpu", "", "CPU cores, e.g. 0.5, '' for the default")
+	cmd.Flags().String("memory", "", "Memory, e.g. 1Gi, '' for the default (2Gi per core)")
+	cmd.Flags().String("command", "", "Command to run, instead of the image's entrypoint, '' to remove")
+	cmd.Flags().String("args", "", "Arguments for the command, '' to remove")
+	cmd.Flags().StringArray("secret", nil, "NAME[=PARAM] secret whose value comes from the ${PARAM} parameter, case sensitive (default: NAME in upper case)")
+	cmd.Flags().StringArray("remove-secret", nil, "Secret to remove")
+	cmd.Flags().String("ingress", "", "'internal', or 'external'")
+	cmd.Flags().Bool("external", false, "Enable public access")
+	cmd.Flags().Bool("internal", true, "Disable public access")
+	cmd.Flags().String("port", "", "listen port #")
+	cmd.Flags().String("transport", "", "Ingress transport: 'auto', 'http', 'http2' or 'tcp'")
+	cmd.Flags().String("exposed-port", "", "Port TCP ingress listens on, '' to remove")
+	cmd.Flags().StringArray("additional-port", nil, "TARGET[:EXPOSED][:external] extra port to expose")
+	cmd.Flags().StringArray("remove-additional-port", nil, "Target port of an additional port to remove")
+	cmd.Flags().StringArray("allow-ip", nil, "[NAME=]CIDR range allowed to reach the app, all others are denied")
+	cmd.Flags().StringArray("deny-ip", nil, "[NAME=]CIDR range denied access to the app")
+	cmd.Flags().StringArray("remove-ip", nil, "Name, or CIDR, of an IP rule to remove")
+	cmd.Flags().Bool("sticky-sessions", false, "Send a client's requests to the same replica")
+	cmd.Flags().StringArray("cors-origin", nil, "CORS allowed origin, '' to clear them")
+	cmd.Flags().StringArray("cors-method", nil, "CORS allowed method, '' to clear them")
+	cmd.Flags().String("client-cert", "", "Client certificates: 'require', 'accept' or 'ignore'")
+	cmd.Flags().StringArray("bind", nil, "Services to connect to")
+	cmd.Flags().StringArray("unbind", nil, "Bindings/services to disconnect")
+	cmd.Flags().StringArray("registry", nil, "ACR name, or server, to pull from")
+	cmd.Flags().String("registry-identity", "", "'system', or identity ID, to pull with")
+	cmd.Flags().StringArray("remove-registry", nil, "Registry to remove")
+	cmd.Flags().StringArray("keyvault-secret", nil, "NAME=VAULT/SECRET secret from a Key Vault")
+	cmd.Flags().String("keyvault-identity", "system", "'system', or identity ID, to read Key Vault secrets with")
+	cmd.Flags().String("app-insights", "", "Application Insights component to send telemetry to")
+	cmd.Flags().StringArray("identity", nil, "'system', or user-assigned identity, to assign")
+	cmd.Flags().StringArray("remove-identity", nil, "Identity to unassign")
+	cmd.Flags().StringArray("volume", nil, "NAME=STORAGE:PATH volume mount (STORAGE: ACCOUNT/SHARE, env storage, or emptydir)")
+	cmd.Flags().String("scale-on-queue", "", "Service Bus queue (NAMESPACE/QUEUE) to scale on")
+	cmd.Flags().Int("messages", 0, "# of queue messages per replica, see '--scale-on-queue'")
+	cmd.Flags().String("scale-identity", "", "'system', or identity, the scaler reads the queue with (default: connection string)")
+	cmd.Flags().StringArray("scale-rule", nil, "name=NAME,type=TYPE,KEY=VALUE,auth=PARAM:SECRET scale rule (TYPE: http, tcp, cron, or a KEDA scaler), quote values with commas")
+	cmd.Flags().StringArray("remove-scale-rule", nil, "Scale rule to remove")
+	cmd.Flags().String("min-replicas", "", "Minimum # of replicas, '' for the default")
+	cmd.Flags().String("max-replicas", "", "Maximum # of replicas, '' for the default")
+	cmd.Flags().StringArray("domain", nil, "Custom domain, with a managed certificate")
+	cmd.Flags().StringArray("remove-domain", nil, "Custom domain to remove")
+	cmd.Flags().String("auth-provider", "", "Built-in auth provider: 'entra', or 'none' to disable it")
+	cmd.Flags().String("client-id", "", "Client ID of the auth provider's app registration")
+	cmd.Flags().String("client-secret", "", "App secret holding the auth provider's client secret")
+	cmd.Flags().String("auth-issuer", "", "OpenID issuer URL, e.g. https://login.microsoftonline.com/TENANT/v2.0")
+	cmd.Flags().Bool("require-auth", false, "Send unauthenticated requests to the login page")
+	cmd.Flags().Bool("dapr", false, "Enable the Dapr sidecar")
+	cmd.Flags().String("dapr-app-id", "", "Dapr app ID (default: app name)")
+	cmd.Flags().Int("dapr-port", 0, "Port the app listens on for Dapr")
+	cmd.Flags().String("revision-mode", "", "'single' or 'multiple' active revisions")
+	cmd.Flags().String("revision-suffix", "", "Suffix of the next revision's name, e.g. 'v2' for APP--v2")
+	cmd.Flags().Bool("up", false, "Provision after update")
+	cmd.MarkFlagsMutuallyExclusive("internal", "external", "ingress")
+	cmd.MarkFlagRequired("name")
 }
 
 func setupAcaResourceDefs() {
@@ -131,7 +219,7 @@ func setupAcaResourceDefs() {
 		Type: "Microsoft.App/managedEnvironments",
 		URL:  "https://management.azure.com/subscriptions/${SUBSCRIPTION}/resourceGroups/${RESOURCEGROUP}/providers/Microsoft.App/managedEnvironments/${NAME}?api-version=${APIVERSION}",
 		Defaults: map[string]string{
-			"APIVERSION": "2022-10-01",
+			"APIVERSION": "2023-05-01",
 			"WAIT":       "true",
 		},
 	})
@@ -140,44 +228,152 @@ func setupAcaResourceDefs() {
 		Type: "Microsoft.App/containerApps",
 		URL:  "https://management.azure.com/subscriptions/${SUBSCRIPTION}/resourceGroups/${RESOURCEGROUP}/providers/Microsoft.App/containerApps/${NAME}?api-version=${APIVERSION}",
 		Defaults: map[string]string{
-			"APIVERSION": "2023-05-02-preview",
+			"APIVERSION": "2024-02-02-preview",
 			"WAIT":       "true",
 		},
 	})
+
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.App/containerApps/authConfigs",
+		Defaults: map[string]string{
+			"APIVERSION": "2023-05-01",
+		},
+	})
+	ResourceAliases["aca-app-auth"] = "Microsoft.App/containerApps/authConfigs"
 }
 
 type AcaAppIngress struct {
-	External      *bool `json:"external,omitempty"`
-	TargetPort    *int  `json:"targetPort,omitempty"`
-	CustomDomains []*struct {
-		Name          *string `json:"name,omitempty"`
-		BindingType   *string `json:bindingType,omitempty"`
-		CertificateId *string `json:certificateId,omitempty"`
-	} `json:"customDomains,omitempty"`
-	Traffic []*AcaAppTraffic `json:"traffic,omitempty"`
-	// ipSecurityRestrictions
-	// stickySessions
-	// clientCertificateMode
-	// corePolicy
+	External      *bool                 `json:"external,omitempty"`
+	TargetPort    *int                  `json:"targetPort,omitempty"`
+	CustomDomains []*AcaAppCustomDomain `json:"customDomains,omitempty"`
+	Traffic       []*AcaAppTraffic      `json:"traffic,omitempty"`
+
+	Transport              *string                `json:"transport,omitempty"` // auto, http, http2, tcp
+	ExposedPort            *int                   `json:"exposedPort,omitempty"`
+	AdditionalPortMappings []*AcaAppPortMapping   `json:"additionalPortMappings,omitempty"`
+	IPSecurityRestrictions []*AcaAppIPRestriction `json:"ipSecurityRestrictions,omitempty"`
+	StickySessions         *AcaAppStickySessions  `json:"stickySessions,omitempty"`
+	ClientCertificateMode  *string                `json:"clientCertificateMode,omitempty"`
+	CorsPolicy             *AcaAppCorsPolicy      `json:"corsPolicy,omitempty"`
 }
 
+type AcaAppPortMapping struct {
+	External    *bool `json:"external,omitempty"`
+	TargetPort  *int  `json:"targetPort,omitempty"`
+	ExposedPort *int  `json:"exposedPort,omitempty"`
+}
+
+// ACA doesn't allow Allow and Deny rules to be mixed on one app
+type AcaAppIPRestriction struct {
+	Name           *string `json:"name,omitempty"`
+	Description    *string `json:"description,omitempty"`
+	IPAddressRange *string `json:"ipAddressRange,omitempty"`
+	Action         *string `json:"action,omitempty"`
+}
+
+type AcaAppStickySessions struct {
+	Affinity *string `json:"affinity,omitempty"` // sticky, none
+}
+
+type AcaAppCorsPolicy struct {
+	AllowedOrigins   []string `json:"allowedOrigins,omitempty"`
+	AllowedMethods   []string `json:"allowedMethods,omitempty"`
+	AllowedHeaders   []string `json:"allowedHeaders,omitempty"`
+	ExposeHeaders    []string `json:"exposeHeaders,omitempty"`
+	MaxAge           *int     `json:"maxAge,omitempty"`
+	AllowCredentials *bool    `json:"allowCredentials,omitempty"`
+}
+
+// In the stage files the CertificateId is the name of a managed certificate
+// in the app's environment, ARM wants its ID
+type AcaAppCustomDomain struct {
+	Name          *string `json:"name,omitempty"`
+	BindingType   *string `json:"bindingType,omitempty"`
+	CertificateId *string `json:"certificateId,omitempty"`
+
+	// Set while its certificate is being created, see ProvisionSteps
+	unbound bool
+}
+
+// One of RevisionName and LatestRevision is set
 type AcaAppTraffic struct {
+	RevisionName   *string `json:"revisionName,omitempty"`
+	LatestRevision *bool   `json:"latestRevision,omitempty"`
+	Weight         *int    `json:"weight,omitempty"`
+	Label          *string `json:"label,omitempty"`
+}
+
+// Returns the revision's name, or "latest" for the latest revision
+func (t *AcaAppTraffic) Revision() string {
+	if t.LatestRevision != nil && *(t.LatestRevision) {
+		return "latest"
+	}
+	return NotNil(t.RevisionName)
 }
 
 type AcaAppConfiguration struct {
-	Ingress *AcaAppIngress `json:"ingress,omitempty"`
-	// dapr
+	ActiveRevisionsMode *string           `json:"activeRevisionsMode,omitempty"`
+	Secrets             []*AcaAppSecret   `json:"secrets,omitempty"`
+	Ingress             *AcaAppIngress    `json:"ingress,omitempty"`
+	Registries          []*AcaAppRegistry `json:"registries,omitempty"`
+	Dapr                *AcaAppDapr       `json:"dapr,omitempty"`
 	// maxInactiveRevisions
 	Service *AcaAppService `json:"service,omitempty"`
 }
 
+type AcaAppDapr struct {
+	Enabled *bool   `json:"enabled,omitempty"`
+	AppId   *string `json:"appId,omitempty"`
+	AppPort *int    `json:"appPort,omitempty"`
+}
+
+type AcaAppSecret struct {
+	Name        *string `json:"name,omitempty"`
+	Value       *string `json:"value,omitempty"`
+	KeyVaultUrl *string `json:"keyVaultUrl,omitempty"`
+	Identity    *string `json:"identity,omitempty"`
+}
+
+type AcaAppRegistry struct {
+	Server            *string `json:"server,omitempty"`
+	Identity          *string `json:"identity,omitempty"`
+	Username          *string `json:"username,omitempty"`
+	PasswordSecretRef *string `json:"passwordSecretRef,omitempty"`
+}
+
+type AcaAppIdentity struct {
+	Type                   *string                        `json:"type,omitempty"`
+	PrincipalId            *string                        `json:"principalId,omitempty"`
+	TenantId               *string                        `json:"tenantId,omitempty"`
+	UserAssignedIdentities map[string]*AcaAppUserIdentity `json:"userAssignedIdentities,omitempty"`
+}
+
+type AcaAppUserIdentity struct {
+	PrincipalId *string `json:"principalId,omitempty"`
+	ClientId    *string `json:"clientId,omitempty"`
+}
+
+func (aai *AcaAppIdentity) MarshalJSON() ([]byte, error) {
+	type tmpType AcaAppIdentity // avoid recursion
+	tmpAai := tmpType(*aai)
+	if WhyMarshal == "ARM" && len(aai.UserAssignedIdentities) > 0 {
+		// The keys are names in the stage files, ARM wants IDs
+		tmpAai.UserAssignedIdentities = map[string]*AcaAppUserIdentity{}
+		for name, val := range aai.UserAssignedIdentities {
+			tmpAai.UserAssignedIdentities[IdentityARMValue(name)] = val
+		}
+	}
+	return json.Marshal(tmpAai)
+}
+
 type AcaAppService struct {
 	Type *string `json:"type,omitempty"`
 }
 
 type AcaAppEnv struct {
-	Name  *string `json:"name,omitempty"`
-	Value *string `json:"value,omitempty"`
+	Name      *string `json:"name,omitempty"`
+	Value     *string `json:"value,omitempty"`
+	SecretRef *string `json:"secretRef,omitempty"`
 }
 
 type AcaAppContainer struct {
@@ -187,7 +383,43 @@ type AcaAppContainer struct {
 	Resources *AcaAppResources `json:"resources,omitempty"`
 	Command   []string         `json:"command,omitempty"`
 	Args      []string         `json:"args,omitempty"`
-	// probes
+	Probes    []*AcaAppProbe   `json:"probes,omitempty"`
+
+	VolumeMounts []*AcaAppVolumeMount `json:"volumeMounts,omitempty"`
+}
+
+type AcaAppProbe struct {
+	Type                *string             `json:"type,omitempty"`
+	HttpGet             *AcaAppProbeHttpGet `json:"httpGet,omitempty"`
+	TcpSocket           *AcaAppProbeTcp     `json:"tcpSocket,omitempty"`
+	InitialDelaySeconds *int                `json:"initialDelaySeconds,omitempty"`
+	PeriodSeconds       *int                `json:"periodSeconds,omitempty"`
+	TimeoutSeconds      *int                `json:"timeoutSeconds,omitempty"`
+	SuccessThreshold    *int                `json:"successThreshold,omitempty"`
+	FailureThreshold    *int                `json:"failureThreshold,omitempty"`
+}
+
+type AcaAppProbeHttpGet struct {
+	Path   *string `json:"path,omitempty"`
+	Port   *int    `json:"port,omitempty"`
+	Scheme *string `json:"scheme,omitempty"`
+	Host   *string `json:"host,omitempty"`
+}
+
+type AcaAppProbeTcp struct {
+	Port *int    `json:"port,omitempty"`
+	Host *string `json:"host,omitempty"`
+}
+
+type AcaAppVolumeMount struct {
+	VolumeName *string `json:"volumeName,omitempty"`
+	MountPath  *string `json:"mountPath,omitempty"`
+}
+
+type AcaAppVolume struct {
+	Name        *string `json:"name,omitempty"`
+	StorageType *string `json:"storageType,omitempty"`
+	StorageName *string `json:"storageName,omitempty"`
 }
 
 type AcaAppResources struct {
@@ -196,9 +428,76 @@ type AcaAppResources struct {
 }
 
 type AcaAppScale struct {
-	MinReplicas *int `json:"minReplicas,omitempty"`
-	MaxReplicas *int `json:"maxReplicas,omitempty"`
-	// rules
+	MinReplicas *int               `json:"minReplicas,omitempty"`
+	MaxReplicas *int               `json:"maxReplicas,omitempty"`
+	Rules       []*AcaAppScaleRule `json:"rules,omitempty"`
+}
+
+type AcaAppScaleRule struct {
+	Name   *string                `json:"name,omitempty"`
+	Custom *AcaAppCustomScaleRule `json:"custom,omitempty"`
+	Http   *AcaAppHttpScaleRule   `json:"http,omitempty"`
+	Tcp    *AcaAppHttpScaleRule   `json:"tcp,omitempty"`
+	// azureQueue
+}
+
+// Used for both "http" and "tcp" rules since they look the same
+type AcaAppHttpScaleRule struct {
+	Metadata map[string]string      `json:"metadata,omitempty"`
+	Auth     []*AcaAppScaleRuleAuth `json:"auth,omitempty"`
+}
+
+// A KEDA scaler, e.g. "azure-servicebus"
+type AcaAppCustomScaleRule struct {
+	Type     *string                `json:"type,omitempty"`
+	Metadata map[string]string      `json:"metadata,omitempty"`
+	Auth     []*AcaAppScaleRuleAuth `json:"auth,omitempty"`
+	Identity *string                `json:"identity,omitempty"`
+}
+
+type AcaAppScaleRuleAuth struct {
+	SecretRef        *string `json:"secretRef,omitempty"`
+	TriggerParameter *string `json:"triggerParameter,omitempty"`
+}
+
+func (acsr *AcaAppCustomScaleRule) MarshalJSON() ([]byte, error) {
+	type tmpType AcaAppCustomScaleRule // avoid recursion
+	tmpAcsr := tmpType(*acsr)
+	if WhyMarshal == "ARM" && acsr.Identity != nil {
+		tmpAcsr.Identity = StringPtr(IdentityARMValue(*acsr.Identity))
+	}
+	return json.Marshal(tmpAcsr)
+}
+
+// Returns a reference to the Service Bus queue the rule scales on, or nil
+// if it's not a Service Bus rule. A bare namespace name is in the app's
+// subscription and resource group.
+func (rule *AcaAppScaleRule) ResolveQueue(sub string, rg string) *ResourceReference {
+	if rule.Custom == nil {
+		return nil
+	}
+	return ScalerQueueRef(NotNil(rule.Custom.Type), rule.Custom.Metadata,
+		sub, rg)
+}
+
+// Returns a reference to the Service Bus queue that a KEDA scaler of type
+// "ruleType" reads, or nil if it's not an "azure-servicebus" scaler
+func ScalerQueueRef(ruleType string, metadata map[string]string, sub string, rg string) *ResourceReference {
+	if ruleType != "azure-servicebus" {
+		return nil
+	}
+	ns := metadata["namespace"]
+	queue := metadata["queueName"]
+	if ns == "" || queue == "" {
+		return nil
+	}
+
+	queueRef := ServiceBusRef(ns, sub, rg)
+	queueRef.Type = "Microsoft.ServiceBus/namespaces/queues"
+	queueRef.APIVersion = GetResourceDef(queueRef.Type).Defaults["APIVERSION"]
+	queueRef.Name = ns + "/" + queue
+	queueRef.Origin = queueRef.Name
+	return queueRef
 }
 
 type AcaAppServiceBind struct {
@@ -207,10 +506,12 @@ type AcaAppServiceBind struct {
 }
 
 type AcaAppTemplate struct {
-	Containers []*AcaAppContainer `json:"containers,omitempty"`
-	// initContainers
-	Scale        *AcaAppScale         `json:"scale,omitempty"`
-	ServiceBinds []*AcaAppServiceBind `json:"serviceBinds,omitempty"`
+	RevisionSuffix *string              `json:"revisionSuffix,omitempty"`
+	Containers     []*AcaAppContainer   `json:"containers,omitempty"`
+	InitContainers []*AcaAppContainer   `json:"initContainers,omitempty"`
+	Scale          *AcaAppScale         `json:"scale,omitempty"`
+	ServiceBinds   []*AcaAppServiceBind `json:"serviceBinds,omitempty"`
+	Volumes        []*AcaAppVolume      `json:"volumes,omitempty"`
 }
 
 type AcaAppProperties struct {
@@ -229,6 +530,19 @@ func (aa *AcaApp) MarshalJSON() ([]byte, error) {
 		if tmpAa.Location == nil || *(tmpAa.Location) == "" {
 			ErrStop(`Missing "location" for "%s/%s"`, aa.NiceType, aa.Name)
 		}
+
+		if secrets := aa.GeneratedSecrets(); len(secrets) > 0 {
+			// Copy them so we don't touch the originals
+			props := *(aa.Properties)
+			config := AcaAppConfiguration{}
+			if props.Configuration != nil {
+				config = *(props.Configuration)
+			}
+			config.Secrets = append(append([]*AcaAppSecret{},
+				config.Secrets...), secrets...)
+			props.Configuration = &config
+			tmpAa.Properties = &props
+		}
 	}
 	return json.Marshal(tmpAa)
 }
@@ -238,38 +552,222 @@ func (aap *AcaAppProperties) MarshalJSON() ([]byte, error) {
 	if WhyMarshal == "ARM" {
 		envRef := aap.ResolveEnvironmentId()
 		tmpAap.EnvironmentId = StringPtr(envRef.AsID())
-		tmpAap.WorkloadProfileName = StringPtr("Consumption")
+		if tmpAap.WorkloadProfileName == nil {
+			tmpAap.WorkloadProfileName = StringPtr("Consumption")
+		}
 
 		// Temporary to get around an ACA NPE
 		if tmpAap.Template == nil {
+			image := "redis"
+			if c := tmpAap.Configuration; c != nil && c.Service != nil &&
+				NotNil(c.Service.Type) != "" {
+				image = *(c.Service.Type)
+			}
 			tmpAap.Template = &AcaAppTemplate{
 				Containers: []*AcaAppContainer{{
-					Image: StringPtr("redis"),
-					Name:  StringPtr("redis"),
+					Image: StringPtr(image),
+					Name:  StringPtr(image),
 				}},
 			}
 		}
 		// END OF Temporary
+
+		if c := tmpAap.Configuration; c != nil && c.Ingress != nil &&
+			len(c.Ingress.CustomDomains) > 0 {
+			// Copy them so we don't touch the originals
+			config := *c
+			ingress := *(c.Ingress)
+			ingress.CustomDomains = []*AcaAppCustomDomain{}
+			for _, cd := range c.Ingress.CustomDomains {
+				tmpCd := *cd
+				if cd.unbound {
+					tmpCd.BindingType = StringPtr("Disabled")
+					tmpCd.CertificateId = nil
+				} else if certRef := aap.ResolveCertificate(cd); certRef != nil {
+					tmpCd.CertificateId = StringPtr(certRef.AsID())
+				}
+				ingress.CustomDomains = append(ingress.CustomDomains, &tmpCd)
+			}
+			config.Ingress = &ingress
+			tmpAap.Configuration = &config
+		}
 	}
 	return json.Marshal(tmpAap)
 }
 
+// Returns a reference to the custom domain's managed certificate, or nil if
+// it doesn't have one or it's already a full resource ID
+func (aap *AcaAppProperties) ResolveCertificate(cd *AcaAppCustomDomain) *ResourceReference {
+	cert := NotNil(cd.CertificateId)
+	if cert == "" || strings.HasPrefix(cert, "/") {
+		return nil
+	}
+
+	envRef := aap.ResolveEnvironmentId()
+	certType := "Microsoft.App/managedEnvironments/managedCertificates"
+	return &ResourceReference{
+		Subscription:  envRef.Subscription,
+		ResourceGroup: envRef.ResourceGroup,
+		Type:          certType,
+		APIVersion:    GetResourceDef(certType).Defaults["APIVERSION"],
+		Name:          envRef.Name + "/" + cert,
+		Origin:        cert,
+	}
+}
+
+func (aap *AcaAppProperties) FindSecret(name string) *AcaAppSecret {
+	if aap.Configuration == nil {
+		return nil
+	}
+	for _, secret := range aap.Configuration.Secrets {
+		if NotNil(secret.Name) == name {
+			return secret
+		}
+	}
+	return nil
+}
+
+// Secrets that are implied by other parts of the app's config, such as
+// the admin password of an ACR. These are only added to the ARM json, and
+// their values are ${...} references that are resolved just before being
+// sent to Azure, so they never end up in the stage files.
+func (app *AcaApp) GeneratedSecrets() []*AcaAppSecret {
+	secrets := []*AcaAppSecret{}
+	aap := app.Properties
+	if aap == nil {
+		return secrets
+	}
+
+	registries := []*AcaAppRegistry{}
+	if aap.Configuration != nil {
+		registries = aap.Configuration.Registries
+	}
+	for _, reg := range registries {
+		acrName := AcrNameFromServer(NotNil(reg.Server))
+		if reg.PasswordSecretRef == nil || reg.Identity != nil ||
+			acrName == "" || aap.FindSecret(*reg.PasswordSecretRef) != nil {
+			continue
+		}
+
+		acrRef := reg.ResolveAcr()
+		secrets = append(secrets, &AcaAppSecret{
+			Name:  StringPtr(*reg.PasswordSecretRef),
+			Value: StringPtr(acrRef.AsSubstitution("credentials.passwords[0].value")),
+		})
+	}
+
+	// Connection strings of the Service Bus namespaces that scale rules use
+	if aap.Template != nil && aap.Template.Scale != nil {
+		done := map[string]bool{}
+		for _, rule := range aap.Template.Scale.Rules {
+			queueRef := rule.ResolveQueue(app.Subscription, app.ResourceGroup)
+			if queueRef == nil {
+				continue
+			}
+			for _, auth := range rule.Custom.Auth {
+				name := NotNil(auth.SecretRef)
+				if NotNil(auth.TriggerParameter) != "connection" ||
+					name == "" || done[name] || aap.FindSecret(name) != nil {
+					continue
+				}
+				done[name] = true
+
+				nsRef := ServiceBusRef(rule.Custom.Metadata["namespace"],
+					queueRef.Subscription, queueRef.ResourceGroup)
+				secrets = append(secrets, &AcaAppSecret{
+					Name:  StringPtr(name),
+					Value: StringPtr(nsRef.AsSubstitution("keys.primaryConnectionString")),
+				})
+			}
+		}
+	}
+
+	return secrets
+}
+
+func (secret *AcaAppSecret) MarshalJSON() ([]byte, error) {
+	type tmpType AcaAppSecret // avoid recursion
+	tmpSecret := tmpType(*secret)
+	if WhyMarshal == "ARM" && secret.Identity != nil {
+		tmpSecret.Identity = StringPtr(IdentityARMValue(*secret.Identity))
+	}
+	return json.Marshal(tmpSecret)
+}
+
+func (reg *AcaAppRegistry) MarshalJSON() ([]byte, error) {
+	type tmpType AcaAppRegistry // avoid recursion
+	tmpReg := tmpType(*reg)
+	if WhyMarshal == "ARM" && reg.Identity != nil {
+		tmpReg.Identity = StringPtr(IdentityARMValue(*reg.Identity))
+	}
+	return json.Marshal(tmpReg)
+}
+
+// Returns a reference to the Key Vault, or nil if it's not a vault secret
+func (secret *AcaAppSecret) ResolveKeyVault() *ResourceReference {
+	vaultName := KeyVaultNameFromURL(NotNil(secret.KeyVaultUrl))
+	if vaultName == "" {
+		return nil
+	}
+
+	// Set defaults
+	resRef := &ResourceReference{
+		Subscription:  GetConfigProperty("defaults.Subscription"),
+		ResourceGroup: GetConfigProperty("defaults.ResourceGroup"),
+		Type:          "Microsoft.KeyVault/vaults",
+		APIVersion:    GetResourceDef("Microsoft.KeyVault/vaults").Defaults["APIVERSION"],
+		Name:          vaultName,
+		Origin:        NotNil(secret.KeyVaultUrl),
+	}
+
+	// If it's in the stage then use its sub/rg
+	stage := GetConfigProperty("currentStage")
+	if res, err := ResourceFromFile(stage, ResourceFileName("keyvault", vaultName)); err == nil {
+		resRef.Subscription = res.Subscription
+		resRef.ResourceGroup = res.ResourceGroup
+	}
+
+	return resRef
+}
+
+// Returns a reference to the ACR, or nil if the server isn't an ACR
+func (reg *AcaAppRegistry) ResolveAcr() *ResourceReference {
+	acrName := AcrNameFromServer(NotNil(reg.Server))
+	if acrName == "" {
+		return nil
+	}
+
+	// Set defaults
+	resRef := &ResourceReference{
+		Subscription:  GetConfigProperty("defaults.Subscription"),
+		ResourceGroup: GetConfigProperty("defaults.ResourceGroup"),
+		Type:          "Microsoft.ContainerRegistry/registries",
+		APIVersion:    GetResourceDef("Microsoft.ContainerRegistry/registries").Defaults["APIVERSION"],
+		Name:          acrName,
+		Origin:        NotNil(reg.Server),
+	}
+
+	// If it's in the stage then use its sub/rg
+	stage := GetConfigProperty("currentStage")
+	if res, err := ResourceFromFile(stage, ResourceFileName("acr", acrName)); err == nil {
+		resRef.Subscription = res.Subscription
+		resRef.ResourceGroup = res.ResourceGroup
+	}
+
+	return resRef
+}
+
 func (aac *AcaAppContainer) MarshalJSON() ([]byte, error) {
 	tmpAac := *aac
 	if WhyMarshal == "ARM" {
 		if tmpAac.Name == nil {
 			tmpAac.Name = StringPtr("main")
 		}
-		if tmpAac.Resources == nil {
-			tmpAac.Resources = &AcaAppResources{}
-		}
-		if tmpAac.Resources.CPU == nil {
-			f := 0.5
-			tmpAac.Resources.CPU = &f
-		}
-		if tmpAac.Resources.Memory == nil {
-			m := "1Gi"
-			tmpAac.Resources.Memory = &m
+		// Copy it so the defaults don't end up in the stage files
+		cpu, mem := aac.EffectiveResources()
+		tmpAac.Resources = &AcaAppResources{
+			CPU:    &cpu,
+			Memory: StringPtr(FormatMemory(mem)),
 		}
 	}
 	return json.Marshal(tmpAac)
@@ -278,16 +776,11 @@ func (aac *AcaAppContainer) MarshalJSON() ([]byte, error) {
 func (aai *AcaAppIngress) MarshalJSON() ([]byte, error) {
 	tmpAai := *aai
 	if WhyMarshal == "ARM" {
-		if tmpAai.External != nil && tmpAai.External != nil &&
-			tmpAai.TargetPort == nil {
+		// TCP apps have no common port to guess, they must set one
+		if tmpAai.External != nil && tmpAai.TargetPort == nil &&
+			!strings.EqualFold(NotNil(tmpAai.Transport), "tcp") {
 			port := 8080
 			tmpAai.TargetPort = &port
-
-			/*
-				if tmpAai.Traffic == nil {
-					tmpAai.Traffic = []*AcaAppTraffic{{}}
-				}
-			*/
 		}
 	}
 	return json.Marshal(tmpAai)
@@ -296,9 +789,10 @@ func (aai *AcaAppIngress) MarshalJSON() ([]byte, error) {
 func (asb *AcaAppServiceBind) MarshalJSON() ([]byte, error) {
 	tmpAsb := *asb
 	if WhyMarshal == "ARM" {
-		// tmpAsb.ServiceId = StringPtr(*(tmpAsb.ServiceId))
+		svcRef := asb.ResolveServiceId()
+		tmpAsb.ServiceId = StringPtr(svcRef.AsID())
 		if tmpAsb.Name == nil {
-			tmpAsb.Name = StringPtr(*(tmpAsb.ServiceId))
+			tmpAsb.Name = StringPtr(svcRef.Name)
 		}
 	}
 	return json.Marshal(tmpAsb)
@@ -327,9 +821,12 @@ func (asb *AcaAppServiceBind) ResolveServiceId() *ResourceReference {
 func (aat *AcaAppTemplate) MarshalJSON() ([]byte, error) {
 	tmpAat := *aat
 	if WhyMarshal == "ARM" {
-		if tmpAat.Scale == nil {
-			tmpAat.Scale = &AcaAppScale{}
+		// Copy it so we don't touch the original
+		scale := AcaAppScale{}
+		if tmpAat.Scale != nil {
+			scale = *(tmpAat.Scale)
 		}
+		tmpAat.Scale = &scale
 		/*
 			if tmpAat.Scale.MinReplicas == nil {
 				m := 0
@@ -345,12 +842,17 @@ func (aat *AcaAppTemplate) MarshalJSON() ([]byte, error) {
 }
 
 func (aap *AcaAppProperties) ResolveEnvironmentId() *ResourceReference {
-	ref := aap.EnvironmentId
+	return ResolveAcaEnvironment(aap.EnvironmentId)
+}
+
+// Returns a reference to the ACA env "ref" (a name or ID). If it's not set
+// then "defaults.aca-env" is used.
+func ResolveAcaEnvironment(ref *string) *ResourceReference {
 	if ref == nil || *ref == "" {
 		ref = NilStringPtr(GetConfigProperty("defaults.aca-env"))
 	}
 	if ref == nil || *ref == "" {
-		ErrStop("AcaApp, or AcaService, is missing an \"environment\" value")
+		ErrStop("AcaApp, AcaJob or AcaService, is missing an \"environment\" value")
 	}
 
 	// Set defaults
@@ -372,16 +874,46 @@ type AcaApp struct {
 	ResourceBase
 
 	Location   *string           `json:"location,omitempty"`
+	Identity   *AcaAppIdentity   `json:"identity,omitempty"`
 	Properties *AcaAppProperties `json:"properties,omitempty"`
 }
 
 func (app *AcaApp) DependsOn() []*ResourceReference {
 	refs := []*ResourceReference{}
 
+	if app.Identity != nil {
+		for _, name := range app.IdentityNames() {
+			if name != "system" {
+				refs = append(refs, IdentityRef(name))
+			}
+		}
+	}
+
 	if props := app.Properties; props != nil {
 		resRef := props.ResolveEnvironmentId()
 		refs = append(refs, resRef)
 
+		if config := props.Configuration; config != nil {
+			for _, reg := range config.Registries {
+				if acrRef := reg.ResolveAcr(); acrRef != nil {
+					refs = append(refs, acrRef)
+				}
+			}
+			for _, secret := range config.Secrets {
+				if kvRef := secret.ResolveKeyVault(); kvRef != nil {
+					refs = append(refs, kvRef)
+				}
+			}
+			// Azure checks the asuid TXT record as soon as the hostname is
+			// added, even unbound. The managed certificates depend on us
+			// instead, see ProvisionSteps
+			if ingress := config.Ingress; ingress != nil {
+				for _, cd := range ingress.CustomDomains {
+					refs = append(refs, DnsRecordRefs(NotNil(cd.Name))...)
+				}
+			}
+		}
+
 		if template := props.Template; template != nil {
 			if sbs := template.ServiceBinds; sbs != nil {
 				for _, sb := range sbs {
@@ -391,19 +923,38 @@ func (app *AcaApp) DependsOn() []*ResourceReference {
 					}
 				}
 			}
-		}
-	}
 
-	return refs
+			// Env vars can reference other resources, e.g. ${type/name.prop}
+			refs = append(refs, ContainerSubstitutionRefs(
+				append(template.Containers, template.InitContainers...),
+				app.Subscription, app.ResourceGroup)...)
+
+			for _, vol := range template.Volumes {
+				if storageRef := props.ResolveVolumeStorage(vol); storageRef != nil {
+					refs = append(refs, storageRef)
+				}
+			}
+
+			if scale := template.Scale; scale != nil {
+				for _, rule := range scale.Rules {
+					queueRef := rule.ResolveQueue(app.Subscription,
+						app.ResourceGroup)
+					if queueRef != nil {
+						refs = append(refs, queueRef)
+					}
+				}
+			}
+		}
+	}
+
+	return refs
 }
 
 func (app *AcaApp) ToForm() *Form {
 	res := &app.ResourceBase
 
-	if res.NiceType == "aca-redis" {
-		form := NewForm()
-		form.AddProp("Service", NotNil(app.Properties.Configuration.Service.Type))
-		return form
+	if IsAcaService(res.NiceType) {
+		return app.ServiceToForm()
 	}
 
 	// Must be a normal app
@@ -432,6 +983,9 @@ func (app *AcaApp) ToForm() *Form {
 	}
 	form.AddProp("Subscription", app.Subscription)
 	form.AddProp("ResourceGroup", app.ResourceGroup)
+	if names := app.IdentityNames(); len(names) > 0 {
+		form.AddProp("Identity", strings.Join(names, ", "))
+	}
 	if app.Properties.WorkloadProfileName != nil { // to avoid name alignment
 		wpf := form.AddSection("", "")
 		wpf.Space = false
@@ -442,6 +996,104 @@ func (app *AcaApp) ToForm() *Form {
 	if port != "" {
 		nf.AddProp("Port", port)
 	}
+	if config := app.Properties.Configuration; config != nil &&
+		config.Ingress != nil {
+		config.Ingress.AddSecurityToForm(nf)
+	}
+
+	if config := app.Properties.Configuration; config != nil &&
+		config.Ingress != nil && len(config.Ingress.Traffic) > 0 {
+		nf := form.AddArray("Traffic", "")
+		for _, t := range config.Ingress.Traffic {
+			sec := nf.AddSection("*Revision:"+t.Revision(), "")
+			sec.AddProp("Revision", t.Revision())
+			if t.Weight != nil {
+				sec.AddProp("Weight", fmt.Sprintf("%d", *(t.Weight)))
+			}
+			if t.Label != nil {
+				sec.AddProp("Label", *(t.Label))
+			}
+		}
+	}
+
+	if config := app.Properties.Configuration; config != nil &&
+		config.Ingress != nil && len(config.Ingress.CustomDomains) > 0 {
+		nf := form.AddArray("Custom Domains", "")
+		for _, cd := range config.Ingress.CustomDomains {
+			sec := nf.AddSection("*Domain:"+NotNil(cd.Name), "")
+			sec.AddProp("Name", NotNil(cd.Name))
+			if cd.BindingType != nil {
+				sec.AddProp("Binding", *(cd.BindingType))
+			}
+			if cd.CertificateId != nil {
+				sec.AddProp("Certificate", *(cd.CertificateId))
+			}
+		}
+	}
+
+	mode, suffix := "", ""
+	if config := app.Properties.Configuration; config != nil {
+		mode = NotNil(config.ActiveRevisionsMode)
+	}
+	if template := app.Properties.Template; template != nil {
+		suffix = NotNil(template.RevisionSuffix)
+	}
+	if mode != "" || suffix != "" {
+		nf := form.AddSection("Revisions", mode)
+		if suffix != "" {
+			nf.AddProp("Suffix", suffix)
+		}
+	}
+
+	if config := app.Properties.Configuration; config != nil &&
+		config.Dapr != nil {
+		dapr := config.Dapr
+		state := "disabled"
+		if dapr.Enabled != nil && *(dapr.Enabled) {
+			state = "enabled"
+		}
+		nf := form.AddSection("Dapr", state)
+		if dapr.AppId != nil {
+			nf.AddProp("App ID", *(dapr.AppId))
+		}
+		if dapr.AppPort != nil {
+			nf.AddProp("Port", fmt.Sprintf("%d", *(dapr.AppPort)))
+		}
+	}
+
+	// Lives in its own resource, it's just shown here. When the app came
+	// from Azure then so does its auth config, so diffs show auth drift.
+	var auth *AcaAppAuth
+	if app.FromAzure {
+		auth = DownloadAcaAppAuth(app)
+	} else {
+		auth = FindAcaAppAuth(GetConfigProperty("currentStage"), app.Name)
+	}
+	if auth != nil && auth.Properties != nil {
+		auth.AddSettingsToForm(form.AddSection("Authentication", ""))
+	}
+
+	if config := app.Properties.Configuration; config != nil &&
+		len(config.Registries) > 0 {
+		nf := form.AddArray("Registries", "")
+		for _, reg := range config.Registries {
+			sec := nf.AddSection("*Registry:"+NotNil(reg.Server), "")
+			sec.AddProp("Server", NotNil(reg.Server))
+			if reg.Identity != nil {
+				sec.AddProp("Identity", *(reg.Identity))
+			}
+			if reg.Username != nil {
+				sec.AddProp("Username", *(reg.Username))
+			}
+			if reg.PasswordSecretRef != nil {
+				sec.AddProp("Password Secret", *(reg.PasswordSecretRef))
+			}
+		}
+	}
+
+	if config := app.Properties.Configuration; config != nil {
+		SecretsToForm(form, config.Secrets)
+	}
 
 	template := app.Properties.Template
 	if template != nil {
@@ -449,55 +1101,60 @@ func (app *AcaApp) ToForm() *Form {
 		// if cont == nil || len(cont) == 0 {
 		// form.AddArray("Containers", "none").Space = true
 		// } else {
-		nf := form.AddArray("Containers", "")
-		// nf.Space = true
-		for i, c := range template.Containers {
-			cf := nf.AddSection(fmt.Sprintf("*#%d", i+1), "")
-			cf.AddProp("Image", NotNil(c.Image))
-			if len(c.Command) > 0 {
-				cf.AddProp("Command", QuoteStrings(c.Command))
-			}
-			if len(c.Args) > 0 {
-				cf.AddProp("Args", QuoteStrings(c.Args))
-			}
-			if c.Resources != nil {
-				if c.Resources.CPU != nil {
-					cf.AddProp("CPU", fmt.Sprintf("%v", *(c.Resources.CPU)))
-				}
-				if c.Resources.Memory != nil {
-					cf.AddProp("Memory", fmt.Sprintf("%s", *(c.Resources.Memory)))
-				}
-			}
+		ContainersToForm(form, "Containers", template.Containers)
+		ContainersToForm(form, "Init Containers", template.InitContainers)
+		// }
 
-			if scale := template.Scale; scale != nil {
+		if scale := template.Scale; scale != nil {
+			if scale.MinReplicas != nil || scale.MaxReplicas != nil {
+				nf := form.AddSection("Scale", "")
 				if scale.MinReplicas != nil {
-					cf.AddProp("Min Scale", fmt.Sprintf("%d", *(scale.MinReplicas)))
+					nf.AddProp("Min Replicas",
+						fmt.Sprintf("%d", *(scale.MinReplicas)))
 				}
 				if scale.MaxReplicas != nil {
-					cf.AddProp("Max Scale", fmt.Sprintf("%d", *(scale.MaxReplicas)))
+					nf.AddProp("Max Replicas",
+						fmt.Sprintf("%d", *(scale.MaxReplicas)))
 				}
 			}
+		}
 
-			if len(c.Env) > 0 {
-				ef := cf.AddArray("Environment variables", "")
-				for _, env := range c.Env {
-					// es := ef.AddSection("*"+NotNil(env.Name), "")
-					// es.Space = false
-					ef.AddProp(NotNil(env.Name), NotNil(env.Value))
+		if len(template.Volumes) > 0 {
+			nf := form.AddArray("Volumes", "")
+			for _, vol := range template.Volumes {
+				sec := nf.AddSection("*Volume:"+NotNil(vol.Name), "")
+				sec.AddProp("Name", NotNil(vol.Name))
+				if vol.StorageType != nil {
+					sec.AddProp("Storage Type", *(vol.StorageType))
+				}
+				if vol.StorageName != nil {
+					sec.AddProp("Storage Name", *(vol.StorageName))
 				}
 			}
 		}
-		// }
 
-		/*
-			if scale := template.Scale; scale != nil {
-				if scale.MinReplicas != nil || scale.MaxReplicas != nil {
-					nf := form.AddSection("Scaling", "")
-					if scale.MinReplicas
-					nf.AddProp("Min Scale",
+		if scale := template.Scale; scale != nil && len(scale.Rules) > 0 {
+			nf := form.AddArray("Scale Rules", "")
+			for _, rule := range scale.Rules {
+				sec := nf.AddSection("*Rule:"+NotNil(rule.Name), "")
+				sec.AddProp("Name", NotNil(rule.Name))
+				switch {
+				case rule.Http != nil:
+					sec.AddProp("Type", "http")
+					ScaleRuleDetailsToForm(sec, rule.Http.Metadata, rule.Http.Auth)
+				case rule.Tcp != nil:
+					sec.AddProp("Type", "tcp")
+					ScaleRuleDetailsToForm(sec, rule.Tcp.Metadata, rule.Tcp.Auth)
+				case rule.Custom != nil:
+					custom := rule.Custom
+					sec.AddProp("Type", NotNil(custom.Type))
+					if custom.Identity != nil {
+						sec.AddProp("Identity", *(custom.Identity))
+					}
+					ScaleRuleDetailsToForm(sec, custom.Metadata, custom.Auth)
 				}
 			}
-		*/
+		}
 
 		binds := template.ServiceBinds
 		if len(binds) > 0 {
@@ -516,6 +1173,258 @@ func (app *AcaApp) ToForm() *Form {
 	return form
 }
 
+// Adds the metadata and auth of a scale rule to its section of the form
+func ScaleRuleDetailsToForm(sec *Form, metadata map[string]string, auth []*AcaAppScaleRuleAuth) {
+	if len(metadata) > 0 {
+		keys := []string{}
+		for k := range metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		mf := sec.AddArray("Metadata", "")
+		for _, k := range keys {
+			mf.AddProp(k, metadata[k])
+		}
+	}
+	if len(auth) > 0 {
+		af := sec.AddArray("Auth", "")
+		for _, a := range auth {
+			af.AddProp(NotNil(a.TriggerParameter), NotNil(a.SecretRef))
+		}
+	}
+}
+
+// The reverse of ScaleRuleDetailsToForm
+func ScaleRuleDetailsFromForm(sec *Form) (map[string]string, []*AcaAppScaleRuleAuth) {
+	var metadata map[string]string
+	var auth []*AcaAppScaleRuleAuth
+	for _, sub := range sec.Items {
+		switch sub.Title {
+		case "Metadata":
+			metadata = map[string]string{}
+			for _, md := range sub.Items {
+				metadata[md.Title] = md.Value
+			}
+		case "Auth":
+			for _, a := range sub.Items {
+				auth = append(auth, &AcaAppScaleRuleAuth{
+					TriggerParameter: StringPtr(a.Title),
+					SecretRef:        StringPtr(a.Value),
+				})
+			}
+		}
+	}
+	return metadata, auth
+}
+
+// Shown instead of the values of secrets. Azure never returns them, and
+// even the ${PARAM} templates in the stage files shouldn't be diffed.
+const SecretMask = "********"
+
+// Adds the "Secrets" array to the form of an app or job
+func SecretsToForm(form *Form, secrets []*AcaAppSecret) {
+	if len(secrets) == 0 {
+		return
+	}
+	nf := form.AddArray("Secrets", "")
+	for _, secret := range secrets {
+		sec := nf.AddSection("*Secret:"+NotNil(secret.Name), "")
+		sec.AddProp("Name", NotNil(secret.Name))
+		if secret.Value != nil {
+			sec.AddProp("Value", SecretMask)
+		}
+		if secret.KeyVaultUrl != nil {
+			sec.AddProp("Key Vault URL", *(secret.KeyVaultUrl))
+		}
+		if secret.Identity != nil {
+			sec.AddProp("Identity", *(secret.Identity))
+		}
+	}
+}
+
+// The reverse of SecretsToForm. Masked values are taken from the matching
+// secret in "old", since the form never has the real ones.
+func SecretsFromForm(item *Form, old []*AcaAppSecret) []*AcaAppSecret {
+	secrets := []*AcaAppSecret{}
+	for _, secSec := range item.Items {
+		secret := &AcaAppSecret{
+			Name:        NilStringPtr(secSec.GetProp("Name")),
+			Value:       NilStringPtr(secSec.GetProp("Value")),
+			KeyVaultUrl: NilStringPtr(secSec.GetProp("Key Vault URL")),
+			Identity:    NilStringPtr(secSec.GetProp("Identity")),
+		}
+		if NotNil(secret.Value) == SecretMask {
+			secret.Value = nil
+			for _, o := range old {
+				if NotNil(o.Name) == NotNil(secret.Name) {
+					secret.Value = o.Value
+				}
+			}
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets
+}
+
+// Azure returns the names of secrets but not their values, so make those
+// look like the masked local ones in the form. Key Vault secrets don't
+// have a value either way.
+func MaskServerSecrets(secrets []*AcaAppSecret) {
+	for _, secret := range secrets {
+		if secret.Value == nil && secret.KeyVaultUrl == nil {
+			secret.Value = StringPtr(SecretMask)
+		}
+	}
+}
+
+// Adds the "title" array of containers to the form. They're keyed by name
+// so diff/sync compare the right ones.
+func ContainersToForm(form *Form, title string, containers []*AcaAppContainer) {
+	if len(containers) == 0 {
+		return
+	}
+	nf := form.AddArray(title, "")
+	for _, c := range containers {
+		c.AddToForm(nf.AddSection("*Container:"+c.ContainerName(), ""))
+	}
+}
+
+// The reverse of ContainersToForm
+func ContainersFromForm(item *Form) []*AcaAppContainer {
+	containers := []*AcaAppContainer{}
+	for _, cSection := range item.Items {
+		c := &AcaAppContainer{}
+		for _, item := range cSection.Items {
+			if !c.SetFromForm(item) {
+				panic("Unknown c.item: " + item.Title)
+			}
+		}
+		containers = append(containers, c)
+	}
+	return containers
+}
+
+// Adds the container's props to its section of a "Containers" form array
+func (c *AcaAppContainer) AddToForm(cf *Form) {
+	if c.Name != nil {
+		cf.AddProp("Name", *(c.Name))
+	}
+	cf.AddProp("Image", NotNil(c.Image))
+	if len(c.Command) > 0 {
+		cf.AddProp("Command", QuoteStrings(c.Command))
+	}
+	if len(c.Args) > 0 {
+		cf.AddProp("Args", QuoteStrings(c.Args))
+	}
+	if c.Resources != nil {
+		if c.Resources.CPU != nil {
+			cf.AddProp("CPU", fmt.Sprintf("%v", *(c.Resources.CPU)))
+		}
+		if c.Resources.Memory != nil {
+			cf.AddProp("Memory", fmt.Sprintf("%s", *(c.Resources.Memory)))
+		}
+	}
+
+	if len(c.Probes) > 0 {
+		pf := cf.AddArray("Probes", "")
+		for _, probe := range c.Probes {
+			pf.AddProp(NotNil(probe.Type), probe.String())
+		}
+	}
+
+	if len(c.Env) > 0 {
+		ef := cf.AddArray("Environment variables", "")
+		for _, env := range c.Env {
+			if env.SecretRef != nil {
+				ef.AddProp(NotNil(env.Name), "secretref:"+*(env.SecretRef))
+			} else {
+				ef.AddProp(NotNil(env.Name), NotNil(env.Value))
+			}
+		}
+	}
+
+	if len(c.VolumeMounts) > 0 {
+		mf := cf.AddArray("Volume Mounts", "")
+		for _, vm := range c.VolumeMounts {
+			mf.AddProp(NotNil(vm.VolumeName), NotNil(vm.MountPath))
+		}
+	}
+}
+
+// The reverse of AddToForm, returns false if "item" isn't a container prop
+func (c *AcaAppContainer) SetFromForm(item *Form) bool {
+	switch item.Title {
+	case "Name":
+		c.Name = StringPtr(item.Value)
+	case "Image":
+		c.Image = StringPtr(item.Value)
+	case "Command":
+		c.Command = ParseQuotedString(item.Value)
+	case "Args":
+		c.Args = ParseQuotedString(item.Value)
+
+	case "CPU":
+		f, _ := strconv.ParseFloat(item.Value, 64)
+		c.MustResources().CPU = &f
+	case "Memory":
+		c.MustResources().Memory = StringPtr(item.Value)
+
+	case "Probes":
+		for _, probe := range item.Items {
+			c.Probes = append(c.Probes, ParseProbe(probe.Title, probe.Value))
+		}
+
+	case "Environment variables":
+		for _, env := range item.Items {
+			newEnv := &AcaAppEnv{Name: StringPtr(env.Title)}
+			if ref, found := strings.CutPrefix(env.Value, "secretref:"); found {
+				newEnv.SecretRef = StringPtr(ref)
+			} else {
+				newEnv.Value = StringPtr(env.Value)
+			}
+			c.Env = append(c.Env, newEnv)
+		}
+
+	case "Volume Mounts":
+		for _, vm := range item.Items {
+			c.VolumeMounts = append(c.VolumeMounts,
+				&AcaAppVolumeMount{
+					VolumeName: StringPtr(vm.Title),
+					MountPath:  StringPtr(vm.Value),
+				})
+		}
+
+	default:
+		return false
+	}
+	return true
+}
+
+func (app *AcaApp) ServiceToForm() *Form {
+	service, env := "", ""
+	if props := app.Properties; props != nil {
+		env = NotNil(props.EnvironmentId)
+		if c := props.Configuration; c != nil && c.Service != nil {
+			service = NotNil(c.Service.Type)
+		}
+	}
+
+	form := NewForm()
+	form.Title = "*ACA-" + AcaServiceTitle(service) + "(" + app.Name + ")"
+	form.AddProp("Name", app.Name)
+	if env != "" {
+		form.AddProp("Environment", env)
+	}
+	if NotNil(app.Location) != "" {
+		form.AddProp("Location", NotNil(app.Location))
+	}
+	form.AddProp("Subscription", app.Subscription)
+	form.AddProp("ResourceGroup", app.ResourceGroup)
+	form.AddProp("Service", service)
+
+	return form
+}
+
 func (app *AcaApp) MustProperties() *AcaAppProperties {
 	if app.Properties == nil {
 		app.Properties = &AcaAppProperties{}
@@ -537,6 +1446,17 @@ func (app *AcaApp) MustIngress() *AcaAppIngress {
 	return app.Properties.Configuration.Ingress
 }
 
+// Returns the app's ingress. Unlike MustIngress it's never created since
+// "what" makes no sense without the user choosing the ingress's visibility.
+func (app *AcaApp) NeedIngress(what string) *AcaAppIngress {
+	if app.Properties == nil || app.Properties.Configuration == nil ||
+		app.Properties.Configuration.Ingress == nil {
+		ErrStop("App %q has no ingress, use '--ingress' to add it before "+
+			"setting its %s", app.Name, what)
+	}
+	return app.Properties.Configuration.Ingress
+}
+
 func (app *AcaApp) MustTemplate() *AcaAppTemplate {
 	if props := app.MustProperties(); props.Template == nil {
 		props.Template = &AcaAppTemplate{}
@@ -565,17 +1485,30 @@ func (app *AcaApp) FromForm(r *ResourceBase, f *Form) {
 		panic("Bad type: " + f.Type)
 	}
 
-	if r.NiceType == "aca-redis" {
+	if IsAcaService(r.NiceType) {
 		newApp = &AcaApp{
 			ResourceBase: app.ResourceBase,
 		}
 
-		newApp.Properties = &AcaAppProperties{
-			Configuration: &AcaAppConfiguration{
-				Service: &AcaAppService{
-					Type: StringPtr(f.GetProp("Service")),
-				},
-			},
+		for _, item := range f.Items {
+			switch item.Title {
+			case "Name":
+				// Skip
+			case "Environment":
+				newApp.MustProperties().EnvironmentId = StringPtr(item.Value)
+			case "Location":
+				newApp.Location = StringPtr(item.Value)
+			case "Subscription":
+				newApp.Subscription = item.Value
+			case "ResourceGroup":
+				newApp.ResourceGroup = item.Value
+			case "Service":
+				newApp.MustConfiguration().Service = &AcaAppService{
+					Type: StringPtr(item.Value),
+				}
+			default:
+				panic("Unknown item: " + item.Title)
+			}
 		}
 	} else {
 		newApp = &AcaApp{
@@ -605,6 +1538,13 @@ func (app *AcaApp) FromForm(r *ResourceBase, f *Form) {
 			case "ResourceGroup":
 				newApp.ResourceGroup = item.Value
 
+			case "Identity":
+				for _, name := range strings.Split(item.Value, ",") {
+					if name = strings.TrimSpace(name); name != "" {
+						newApp.AddIdentity(name)
+					}
+				}
+
 			case "Workload Profile Name":
 				newApp.MustProperties().WorkloadProfileName =
 					StringPtr(item.Value)
@@ -615,50 +1555,118 @@ func (app *AcaApp) FromForm(r *ResourceBase, f *Form) {
 					p, _ := strconv.Atoi(val)
 					newApp.MustIngress().TargetPort = &p
 				}
+				newApp.MustIngress().SecurityFromForm(item)
+
+			case "Authentication":
+				// Skip, it's saved via its aca-app-auth resource
+
+			case "Revisions":
+				newApp.MustConfiguration().ActiveRevisionsMode =
+					NilStringPtr(item.Value)
+				newApp.MustTemplate().RevisionSuffix =
+					NilStringPtr(item.GetProp("Suffix"))
+
+			case "Traffic":
+				for _, sec := range item.Items {
+					newApp.MustIngress().Traffic =
+						append(newApp.MustIngress().Traffic,
+							NewTraffic(sec.GetProp("Revision"),
+								sec.GetProp("Weight"), sec.GetProp("Label")))
+				}
+
+			case "Dapr":
+				dapr := &AcaAppDapr{
+					Enabled: BoolPtr(item.Value == "enabled"),
+					AppId:   NilStringPtr(item.GetProp("App ID")),
+				}
+				if val := item.GetProp("Port"); val != "" {
+					p, _ := strconv.Atoi(val)
+					dapr.AppPort = &p
+				}
+				newApp.MustConfiguration().Dapr = dapr
+
+			case "Custom Domains":
+				for _, cdSec := range item.Items {
+					newApp.MustIngress().CustomDomains =
+						append(newApp.MustIngress().CustomDomains,
+							&AcaAppCustomDomain{
+								Name:        NilStringPtr(cdSec.GetProp("Name")),
+								BindingType: NilStringPtr(cdSec.GetProp("Binding")),
+								CertificateId: NilStringPtr(
+									cdSec.GetProp("Certificate")),
+							})
+				}
+
+			case "Registries":
+				for _, regSec := range item.Items {
+					newApp.MustConfiguration().Registries =
+						append(newApp.MustConfiguration().Registries,
+							&AcaAppRegistry{
+								Server:   NilStringPtr(regSec.GetProp("Server")),
+								Identity: NilStringPtr(regSec.GetProp("Identity")),
+								Username: NilStringPtr(regSec.GetProp("Username")),
+								PasswordSecretRef: NilStringPtr(
+									regSec.GetProp("Password Secret")),
+							})
+				}
+
+			case "Secrets":
+				newApp.MustConfiguration().Secrets =
+					SecretsFromForm(item, *(app.MustSecrets()))
 
 			case "Containers": // "Containers" Array
-				for _, cSection := range item.Items { // cSection = Cont Section
-					c := &AcaAppContainer{}
-					newApp.MustTemplate().Containers =
-						append(newApp.MustTemplate().Containers, c)
-
-					for _, item := range cSection.Items {
-						switch item.Title {
-						case "Image":
-							c.Image = StringPtr(item.Value)
-						case "Command":
-							c.Command = ParseQuotedString(item.Value)
-						case "Args":
-							c.Args = ParseQuotedString(item.Value)
-
-						case "CPU":
-							f, _ := strconv.ParseFloat(item.Value, 64)
-							c.MustResources().CPU = &f
-						case "Memory":
-							c.MustResources().Memory = StringPtr(item.Value)
-
-						case "Min Scale":
-							s, _ := strconv.ParseInt(item.Value, 10, 64)
-							i := int(s)
-							newApp.MustScale().MinReplicas = &i
-
-						case "Max Scale":
-							s, _ := strconv.ParseInt(item.Value, 10, 64)
-							i := int(s)
-							newApp.MustScale().MaxReplicas = &i
-
-						case "Environment variables":
-							for _, env := range item.Items {
-								c.Env = append(c.Env, &AcaAppEnv{
-									Name:  StringPtr(env.Title),
-									Value: StringPtr(env.Value),
-								})
-							}
-
-						default:
-							panic("Unknown c.item: " + item.Title)
+				newApp.MustTemplate().Containers = ContainersFromForm(item)
+
+			case "Init Containers":
+				newApp.MustTemplate().InitContainers = ContainersFromForm(item)
+
+			case "Scale":
+				if val := item.GetProp("Min Replicas"); val != "" {
+					i, _ := strconv.Atoi(val)
+					newApp.MustScale().MinReplicas = &i
+				}
+				if val := item.GetProp("Max Replicas"); val != "" {
+					i, _ := strconv.Atoi(val)
+					newApp.MustScale().MaxReplicas = &i
+				}
+
+			case "Volumes":
+				for _, volSec := range item.Items {
+					newApp.MustTemplate().Volumes =
+						append(newApp.MustTemplate().Volumes,
+							&AcaAppVolume{
+								Name: NilStringPtr(volSec.GetProp("Name")),
+								StorageType: NilStringPtr(
+									volSec.GetProp("Storage Type")),
+								StorageName: NilStringPtr(
+									volSec.GetProp("Storage Name")),
+							})
+				}
+
+			case "Scale Rules":
+				for _, ruleSec := range item.Items {
+					rule := &AcaAppScaleRule{
+						Name: NilStringPtr(ruleSec.GetProp("Name")),
+					}
+					metadata, auth := ScaleRuleDetailsFromForm(ruleSec)
+					switch t := ruleSec.GetProp("Type"); t {
+					case "":
+					case "http":
+						rule.Http = &AcaAppHttpScaleRule{
+							Metadata: metadata, Auth: auth}
+					case "tcp":
+						rule.Tcp = &AcaAppHttpScaleRule{
+							Metadata: metadata, Auth: auth}
+					default:
+						rule.Custom = &AcaAppCustomScaleRule{
+							Type:     StringPtr(t),
+							Identity: NilStringPtr(ruleSec.GetProp("Identity")),
+							Metadata: metadata,
+							Auth:     auth,
 						}
 					}
+					newApp.MustScale().Rules =
+						append(newApp.MustScale().Rules, rule)
 				}
 
 			case "Bindings":
@@ -680,6 +1688,8 @@ func (app *AcaApp) FromForm(r *ResourceBase, f *Form) {
 		}
 	}
 
+	newApp.CheckResources()
+
 	data, _ := json.MarshalIndent(newApp, "", "  ")
 
 	r.Object = newApp
@@ -700,15 +1710,72 @@ func (app *AcaApp) ToJson() string {
 }
 
 func (app *AcaApp) HideServerFields() {
+	if id := app.Identity; id != nil {
+		if strings.EqualFold(NotNil(id.Type), "None") {
+			app.Identity = nil
+		} else {
+			id.PrincipalId = nil
+			id.TenantId = nil
+			for key := range id.UserAssignedIdentities {
+				id.UserAssignedIdentities[key] = &AcaAppUserIdentity{}
+			}
+		}
+	}
 	if app.Properties != nil && app.Properties.Configuration != nil {
 		c := app.Properties.Configuration
-		if (*c == AcaAppConfiguration{}) {
+		MaskServerSecrets(c.Secrets)
+		// Single revision mode, with all traffic going to the latest
+		// revision, is what Azure uses when they're not set
+		if strings.EqualFold(NotNil(c.ActiveRevisionsMode), "Single") {
+			c.ActiveRevisionsMode = nil
+		}
+		if ing := c.Ingress; ing != nil {
+			if ing.StickySessions != nil &&
+				strings.EqualFold(NotNil(ing.StickySessions.Affinity), "none") {
+				ing.StickySessions = nil
+			}
+			if strings.EqualFold(NotNil(ing.ClientCertificateMode), "ignore") {
+				ing.ClientCertificateMode = nil
+			}
+			// Azure capitalizes it ("Tcp") and returns "Auto" when unset
+			if t := strings.ToLower(NotNil(ing.Transport)); t == "auto" {
+				ing.Transport = nil
+			} else if t != "" {
+				ing.Transport = StringPtr(t)
+			}
+		}
+		if ing := c.Ingress; ing != nil && len(ing.Traffic) == 1 &&
+			ing.Traffic[0].Revision() == "latest" && ing.Traffic[0].Label == nil &&
+			ing.Traffic[0].Weight != nil && *(ing.Traffic[0].Weight) == 100 {
+			ing.Traffic = nil
+		}
+		// Azure returns a disabled Dapr config even if it was never set
+		if c.Dapr != nil && reflect.DeepEqual(*(c.Dapr),
+			AcaAppDapr{Enabled: BoolPtr(false)}) {
+			c.Dapr = nil
+		}
+		if reflect.DeepEqual(*c, AcaAppConfiguration{}) {
 			app.Properties.Configuration = nil
 		}
 	}
 }
 
+// Azure never returns the values of secrets so just compare their names
+func (app *AcaApp) HideSecrets() {
+	if app.Properties != nil && app.Properties.Configuration != nil {
+		for _, secret := range app.Properties.Configuration.Secrets {
+			secret.Value = nil
+		}
+	}
+}
+
 func AcaFromARMJson(data []byte) *ResourceBase {
+	auth := &AcaAppAuth{}
+	if res := ParseARMResource(data, "Microsoft.App/containerApps/authConfigs",
+		"aca-app-auth", auth, &auth.ResourceBase); res != nil {
+		return res
+	}
+
 	tmp := struct{ ID string }{}
 	err := json.Unmarshal(data, &tmp)
 	NoErr(err, "Error parsing resource: %s", err)
@@ -749,32 +1816,57 @@ func AcaFromARMJson(data []byte) *ResourceBase {
 }
 
 func AddAcaServiceFunc(cmd *cobra.Command, args []string) {
-	_, service, _ := strings.Cut(cmd.CalledAs(), "-")
-	if service == "" {
-		ErrStop("Unknown resource type: %s", cmd.CalledAs())
-	}
-	if service != "redis" {
+	log.VPrintf(2, ">Enter: AddAcaServiceFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddAcaServiceFunc")
+
+	service, _ := strings.CutPrefix(cmd.CalledAs(), "aca-")
+	if !IsAcaService(cmd.CalledAs()) {
 		ErrStop("Unsupported service type: %s", service)
 	}
 
 	app := &AcaApp{}
-	app.Object = app
+	name, _ := cmd.Flags().GetString("name")
+	app.InitResource(app, "Microsoft.App/containerApps", cmd.CalledAs(), name)
+
+	app.ProcessServiceFlags(cmd)
+
+	// Now set the app to be a dev mode service
+	SetJson(app, `{"properties":{"configuration":{"service":{"type":%q}}}}`,
+		service)
+
+	app.SaveAndUp(cmd)
+}
+
+func UpdateAcaServiceFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdateAcaServiceFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdateAcaServiceFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	res := LoadStageResource(cmd.CalledAs(), name)
+	app := res.Object.(*AcaApp)
+
+	app.ProcessServiceFlags(cmd)
+	app.SaveAndUp(cmd)
+}
 
-	// ResourceBase stuff
-	app.Subscription = GetConfigProperty("defaults.Subscription")
-	app.ResourceGroup = GetConfigProperty("defaults.ResourceGroup")
-	app.Type = "Microsoft.App/containerApps"
-	app.Name, _ = cmd.Flags().GetString("name")
-	app.APIVersion = GetResourceDef(app.Type).Defaults["APIVERSION"]
-	app.NiceType = cmd.CalledAs()
+func (app *AcaApp) ProcessServiceFlags(cmd *cobra.Command) {
+	app.ProcessEnvironmentFlag(cmd)
+	app.ProcessResourceFlags(cmd, &app.Location)
+}
 
-	app.Stage = GetConfigProperty("currentStage")
-	app.Filename = fmt.Sprintf("%s-%s.json", app.NiceType, app.Name)
+// Sets the app's environment from the "--environment" flag, or from the
+// "defaults.aca-env" config property if it's not already set
+func (app *AcaApp) ProcessEnvironmentFlag(cmd *cobra.Command) {
+	ProcessAcaEnvironmentFlag(cmd, &app.MustProperties().EnvironmentId)
+}
 
+// Same as AcaApp.ProcessEnvironmentFlag but for anything with an
+// "environmentId", e.g. jobs
+func ProcessAcaEnvironmentFlag(cmd *cobra.Command, envId **string) {
 	configEnv := GetConfigProperty("defaults.aca-env")
 	if cmd.Flags().Changed("environment") {
 		env := FlagAsString(cmd, "environment")
-		app.MustProperties().EnvironmentId = NilStringPtr(env)
+		*envId = NilStringPtr(env)
 
 		if env != "" && configEnv == "" {
 			// If default isn't set, and we have a value, set it
@@ -783,52 +1875,35 @@ func AddAcaServiceFunc(cmd *cobra.Command, args []string) {
 		}
 	}
 
-	if NotNil(app.MustProperties().EnvironmentId) == "" && configEnv != "" {
-		app.MustProperties().EnvironmentId = NilStringPtr(configEnv)
+	if NotNil(*envId) == "" && configEnv != "" {
+		*envId = NilStringPtr(configEnv)
 	}
 
-	if NotNil(app.MustProperties().EnvironmentId) == "" {
+	if NotNil(*envId) == "" {
 		// Notice we allow setting it to "" but only if there's a default.
 		// Should probably be a warning instead of a hard stop.
 		ErrStop("Missing the aca-env value. Use either '--environment=' "+
 			"or '%s set defaults.aca-env='", APP)
 	}
+}
 
-	if cmd.Flags().Changed("subscription") {
-		sub, _ := cmd.Flags().GetString("subscription")
-		if sub == "" {
-			sub = GetConfigProperty("defaults.Subscription")
-		}
-		app.Subscription = sub
-		app.ID = app.AsID()
-	}
-
-	if cmd.Flags().Changed("resource-group") {
-		rg, _ := cmd.Flags().GetString("resource-group")
-		if rg == "" {
-			rg = GetConfigProperty("defaults.ResourceGroup")
-		}
-		app.ResourceGroup = rg
-		app.ID = app.AsID()
+// Converts a "--bind" value, which is either "NAME" or "aca-SERVICE/NAME",
+// into the name of the ACA service to bind to
+func AcaServiceBindName(bindName string) string {
+	niceType, name, found := strings.Cut(bindName, "/")
+	if !found {
+		return bindName
 	}
-
-	if cmd.Flags().Changed("location") {
-		loc, _ := cmd.Flags().GetString("location")
-		if loc == "" {
-			loc = GetConfigProperty("defaults.Location")
+	if !IsAcaService(niceType) {
+		others := []string{}
+		for other, _ := range BindHandlers {
+			others = append(others, other)
 		}
-		app.Location = &loc
-	}
-
-	// Now set the app to be a dev mode service
-	SetJson(app, `{"properties":{"configuration":{"service":{"type":%q}}}}`,
-		service)
-
-	app.Save()
-	p, _ := cmd.Flags().GetBool("up")
-	if p || GetConfigProperty("defaults.up") == "true" {
-		app.Provision()
+		sort.Strings(others)
+		ErrStop("Can't bind to %q, must be one of: aca-%s, %s", bindName,
+			strings.Join(AcaServices, ", aca-"), strings.Join(others, ", "))
 	}
+	return name
 }
 
 func AddAcaAppFunc(cmd *cobra.Command, args []string) {
@@ -836,213 +1911,2492 @@ func AddAcaAppFunc(cmd *cobra.Command, args []string) {
 	defer log.VPrintf(2, "<Exit: AddAcaAppFunc")
 
 	app := &AcaApp{}
-	app.Object = app
-
-	// ResourceBase stuff
-	app.Subscription = GetConfigProperty("defaults.Subscription")
-	app.ResourceGroup = GetConfigProperty("defaults.ResourceGroup")
+	name, _ := cmd.Flags().GetString("name")
+	app.InitResource(app, "Microsoft.App/containerApps", "aca-app", name)
 	app.Location = StringPtr(GetConfigProperty("defaults.Location"))
-	app.Type = "Microsoft.App/containerApps"
-	app.Name, _ = cmd.Flags().GetString("name")
-	app.APIVersion = GetResourceDef(app.Type).Defaults["APIVERSION"]
-	app.NiceType = "aca-app"
-
-	app.Stage = GetConfigProperty("currentStage")
-	app.Filename = fmt.Sprintf("%s-%s.json", app.NiceType, app.Name)
 
 	app.ProcessFlags(cmd)
-	app.Save()
-
-	p, _ := cmd.Flags().GetBool("up")
-	if p || GetConfigProperty("defaults.up") == "true" {
-		app.Provision()
-	}
+	app.SaveAndUp(cmd)
 }
 
 func UpdateAcaAppFunc(cmd *cobra.Command, args []string) {
 	log.VPrintf(2, ">Enter: UpdateAcaAppFunc (%q)", args)
 	defer log.VPrintf(2, "<Exit: UpdateAcaAppFunc")
 
-	stage := GetConfigProperty("currentStage")
 	name, _ := cmd.Flags().GetString("name")
-	name = fmt.Sprintf("%s-%s.json", "aca-app", name)
-	res, err := ResourceFromFile(stage, name)
-	NoErr(err, "Resource %s/%s not found", cmd.CalledAs(), name)
-
+	res := LoadStageResource("aca-app", name)
 	app := res.Object.(*AcaApp)
 
 	app.ProcessFlags(cmd)
-	app.Save()
-
-	p, _ := cmd.Flags().GetBool("up")
-	if p || GetConfigProperty("defaults.up") == "true" {
-		app.Provision()
-	}
+	app.SaveAndUp(cmd)
 }
 
 func (app *AcaApp) ProcessFlags(cmd *cobra.Command) {
 	log.VPrintf(2, ">Enter: ProcessFlags")
 	defer log.VPrintf(2, "<Exit: ProcessFlags")
 
-	SetStringProp(app, cmd.Flags(), "image",
-		`{"properties":{"template":{"containers":[{"image":%s}]}}}`)
+	app.ProcessEnvironmentFlag(cmd)
+	app.ProcessResourceFlags(cmd, &app.Location)
+	ProcessContainerFlags(cmd, app)
+	ProcessWorkloadProfileFlag(cmd, app.MustProperties().EnvironmentId,
+		&app.MustProperties().WorkloadProfileName)
 
-	configEnv := GetConfigProperty("defaults.aca-env")
-	if cmd.Flags().Changed("environment") {
-		env := FlagAsString(cmd, "environment")
-		app.MustProperties().EnvironmentId = NilStringPtr(env)
-
-		if env != "" && configEnv == "" {
-			// If default isn't set, and we have a value, set it
-			SetConfigProperty("defaults.aca-env", env, false)
-			configEnv = env
+	if cmd.Flags().Changed("ingress") {
+		tmp, _ := cmd.Flags().GetString("ingress")
+		val := (tmp == "external")
+		SetJson(app,
+			`{"properties":{"configuration":{"ingress":{"external":%v}}}}`, val)
+	}
+
+	if cmd.Flags().Changed("internal") {
+		SetJson(app,
+			`{"properties":{"configuration":{"ingress":{"external":false}}}}`)
+	}
+	if cmd.Flags().Changed("external") {
+		SetJson(app,
+			`{"properties":{"configuration":{"ingress":{"external":true}}}}`)
+	}
+
+	if cmd.Flags().Changed("port") {
+		port := ParsePort("--port", FlagAsString(cmd, "port"))
+		SetJson(app,
+			`{"properties":{"configuration":{"ingress":{"targetPort":%d}}}}`, port)
+	}
+
+	app.ProcessTransportFlags(cmd)
+
+	if cmd.Flags().Changed("identity") {
+		identities, _ := cmd.Flags().GetStringArray("identity")
+		for _, identity := range identities {
+			app.AddIdentity(identity)
 		}
 	}
 
-	if NotNil(app.MustProperties().EnvironmentId) == "" && configEnv != "" {
-		app.MustProperties().EnvironmentId = NilStringPtr(configEnv)
+	if cmd.Flags().Changed("remove-identity") {
+		identities, _ := cmd.Flags().GetStringArray("remove-identity")
+		for _, identity := range identities {
+			app.RemoveIdentity(identity)
+		}
 	}
 
-	if NotNil(app.MustProperties().EnvironmentId) == "" {
-		// Notice we allow setting it to "" but only if there's a default.
-		// Should probably be a warning instead of a hard stop.
-		ErrStop("Missing the aca-env value. Use either '--environment=' "+
-			"or '%s set defaults.aca-env='", APP)
+	if cmd.Flags().Changed("registry") {
+		registries, _ := cmd.Flags().GetStringArray("registry")
+		identity, _ := cmd.Flags().GetString("registry-identity")
+		for _, registry := range registries {
+			app.SetRegistry(AcrServer(registry), identity)
+		}
+	}
+
+	if cmd.Flags().Changed("keyvault-secret") {
+		secrets, _ := cmd.Flags().GetStringArray("keyvault-secret")
+		identity, _ := cmd.Flags().GetString("keyvault-identity")
+		for _, secret := range secrets {
+			app.SetKeyVaultSecret(secret, identity)
+		}
+	}
+
+	if cmd.Flags().Changed("app-insights") {
+		app.SetAppInsights(FlagAsString(cmd, "app-insights"), cmd)
+	}
+
+	if cmd.Flags().Changed("volume") {
+		volumes, _ := cmd.Flags().GetStringArray("volume")
+		for _, volume := range volumes {
+			app.SetVolume(volume, cmd)
+		}
+	}
+
+	if cmd.Flags().Changed("scale-on-queue") {
+		messages, _ := cmd.Flags().GetInt("messages")
+		identity, _ := cmd.Flags().GetString("scale-identity")
+		app.SetQueueScaleRule(FlagAsString(cmd, "scale-on-queue"), messages,
+			identity)
+	} else if cmd.Flags().Changed("messages") ||
+		cmd.Flags().Changed("scale-identity") {
+		ErrStop("'--messages' and '--scale-identity' need '--scale-on-queue'")
+	}
+
+	if cmd.Flags().Changed("min-replicas") {
+		app.MustScale().MinReplicas = nil
+		if val := FlagAsString(cmd, "min-replicas"); val != "" {
+			min, err := strconv.Atoi(val)
+			if err != nil || min < 0 {
+				ErrStop("'--min-replicas' must be a number, 0 or more")
+			}
+			app.MustScale().MinReplicas = &min
+		}
 	}
 
-	if cmd.Flags().Changed("subscription") {
-		sub, _ := cmd.Flags().GetString("subscription")
-		if sub == "" {
-			sub = GetConfigProperty("defaults.Subscription")
+	if cmd.Flags().Changed("max-replicas") {
+		app.MustScale().MaxReplicas = nil
+		if val := FlagAsString(cmd, "max-replicas"); val != "" {
+			max, err := strconv.Atoi(val)
+			if err != nil || max < 1 {
+				ErrStop("'--max-replicas' must be a number, 1 or more")
+			}
+			app.MustScale().MaxReplicas = &max
 		}
-		app.Subscription = sub
-		app.ID = app.AsID()
 	}
 
-	if cmd.Flags().Changed("resource-group") {
-		rg, _ := cmd.Flags().GetString("resource-group")
-		if rg == "" {
-			rg = GetConfigProperty("defaults.ResourceGroup")
+	if scale := app.MustTemplate().Scale; scale != nil &&
+		scale.MinReplicas != nil && scale.MaxReplicas != nil &&
+		*(scale.MinReplicas) > *(scale.MaxReplicas) {
+		ErrStop("Min replicas (%d) can't be more than max replicas (%d)",
+			*(scale.MinReplicas), *(scale.MaxReplicas))
+	}
+
+	if cmd.Flags().Changed("scale-rule") {
+		rules, _ := cmd.Flags().GetStringArray("scale-rule")
+		for _, rule := range rules {
+			app.SetScaleRule(rule)
 		}
-		app.ResourceGroup = rg
-		app.ID = app.AsID()
 	}
 
-	if cmd.Flags().Changed("location") {
-		loc, _ := cmd.Flags().GetString("location")
-		if loc == "" {
-			loc = GetConfigProperty("defaults.Location")
+	if cmd.Flags().Changed("remove-scale-rule") {
+		rules, _ := cmd.Flags().GetStringArray("remove-scale-rule")
+		for _, rule := range rules {
+			app.RemoveScaleRule(rule)
 		}
-		app.Location = &loc
 	}
 
-	envs, _ := cmd.Flags().GetStringArray("env")
-	for i, env := range envs {
-		if i == 0 {
-			// Just make sure the container is there first
-			SetJson(app,
-				`{"properties":{"template":{"containers":[{"name":"main"}]}}}`)
-			app.Properties.Template.Containers[0].Name = nil
+	if cmd.Flags().Changed("domain") {
+		domains, _ := cmd.Flags().GetStringArray("domain")
+		for _, domain := range domains {
+			app.SetCustomDomain(domain)
 		}
-		name, val, found := strings.Cut(env, "=")
-		c := app.Properties.Template.Containers[0]
+	}
 
-		pos := -1
-		for j, tmpE := range c.Env {
-			if tmpE.Name != nil && *(tmpE.Name) == name {
-				pos = j
-				break
+	if cmd.Flags().Changed("remove-domain") {
+		domains, _ := cmd.Flags().GetStringArray("remove-domain")
+		for _, domain := range domains {
+			app.RemoveCustomDomain(domain)
+		}
+	}
+
+	app.ProcessAuthFlags(cmd)
+	app.ProcessDaprFlags(cmd)
+	app.ProcessProbeFlags(cmd)
+	app.ProcessRevisionFlags(cmd)
+	app.ProcessIngressSecurityFlags(cmd)
+	CheckSecretRefs(app)
+	app.CheckResources()
+	app.CheckReadinessProbe()
+
+	if cmd.Flags().Changed("remove-registry") {
+		registries, _ := cmd.Flags().GetStringArray("remove-registry")
+		config := app.MustConfiguration()
+		for _, registry := range registries {
+			server := AcrServer(registry)
+			found := false
+			for i, reg := range config.Registries {
+				if strings.EqualFold(NotNil(reg.Server), server) {
+					config.Registries = append(config.Registries[:i],
+						config.Registries[i+1:]...)
+					found = true
+					break
+				}
+			}
+			if !found {
+				ErrStop("Registry %q was not found", registry)
 			}
 		}
+	}
+}
+
+// Implemented by the resources that have containers, e.g. aca-app and
+// aca-job, so they can share the processing of the container flags
+type AcaContainerHolder interface {
+	MustContainers() *[]*AcaAppContainer
+	MustInitContainers() *[]*AcaAppContainer
+	MustServiceBinds() *[]*AcaAppServiceBind
+	MustSecrets() *[]*AcaAppSecret
+}
+
+func (app *AcaApp) MustContainers() *[]*AcaAppContainer {
+	return &app.MustTemplate().Containers
+}
+
+func (app *AcaApp) MustInitContainers() *[]*AcaAppContainer {
+	return &app.MustTemplate().InitContainers
+}
+
+func (app *AcaApp) MustServiceBinds() *[]*AcaAppServiceBind {
+	return &app.MustTemplate().ServiceBinds
+}
+
+func (app *AcaApp) MustSecrets() *[]*AcaAppSecret {
+	return &app.MustConfiguration().Secrets
+}
+
+// Handlers for "--bind TYPE/NAME" values that aren't ACA services, keyed by
+// the nice type of the resource being bound to. "bind" is false for
+// "--unbind".
+var BindHandlers = map[string]func(holder AcaContainerHolder, name string, bind bool){}
+
+// The env vars that each of the BindHandlers manages, "--clear-env" leaves
+// them alone since "--unbind" needs them
+var BindEnvVars = map[string][]string{}
+
+// Passes "bindName" to its entry in BindHandlers. Returns false if there
+// isn't one, meaning it's a binding to an ACA service.
+func BindResource(holder AcaContainerHolder, bindName string, bind bool) bool {
+	niceType, name, _ := strings.Cut(bindName, "/")
+	handler := BindHandlers[niceType]
+	if handler == nil {
+		return false
+	}
+	handler(holder, name, bind)
+	return true
+}
 
+// Processes the "--image", "--secret", "--env", "--bind" and "--unbind"
+// flags, along with the ones that pick which container they apply to
+func ProcessContainerFlags(cmd *cobra.Command, holder AcaContainerHolder) {
+	names, _ := cmd.Flags().GetStringArray("remove-container")
+	for _, name := range names {
+		RemoveContainer(holder, name)
+	}
+
+	if cmd.Flags().Changed("image") {
+		TargetContainer(cmd, holder).Image =
+			NilStringPtr(FlagAsString(cmd, "image"))
+	}
+
+	secrets, _ := cmd.Flags().GetStringArray("secret")
+	for _, secret := range secrets {
+		SetParamSecret(holder.MustSecrets(), secret)
+	}
+
+	secrets, _ = cmd.Flags().GetStringArray("remove-secret")
+	for _, secret := range secrets {
+		found := false
+		for _, s := range *(holder.MustSecrets()) {
+			found = found || NotNil(s.Name) == secret
+		}
 		if !found {
-			// remove env var
-			if pos >= 0 {
-				c.Env = append(c.Env[:pos], c.Env[pos+1:]...)
+			ErrStop("Secret %q was not found", secret)
+		}
+		RemoveSecret(holder.MustSecrets(), secret)
+	}
+
+	ProcessEnvFlags(cmd, holder)
+
+	if cmd.Flags().Changed("cpu") {
+		c := TargetContainer(cmd, holder)
+		c.MustResources().CPU = nil
+		if val := FlagAsString(cmd, "cpu"); val != "" {
+			cpu, err := strconv.ParseFloat(val, 64)
+			if err != nil || cpu <= 0 {
+				ErrStop("'--cpu' value %q must be a number of cores, e.g. 0.5",
+					val)
 			}
-		} else {
-			if pos >= 0 {
-				// Update
-				c.Env[pos].Value = StringPtr(val)
-			} else {
-				// Add
-				c.Env = append(c.Env, &AcaAppEnv{
-					Name:  StringPtr(name),
-					Value: StringPtr(val)})
+			c.Resources.CPU = &cpu
+		}
+	}
+
+	if cmd.Flags().Changed("memory") {
+		c := TargetContainer(cmd, holder)
+		c.MustResources().Memory = nil
+		if val := FlagAsString(cmd, "memory"); val != "" {
+			c.Resources.Memory = StringPtr(FormatMemory(ParseMemory(val)))
+		}
+	}
+
+	if cmd.Flags().Changed("cpu") || cmd.Flags().Changed("memory") {
+		if c := TargetContainer(cmd, holder); c.Resources.CPU == nil &&
+			c.Resources.Memory == nil {
+			c.Resources = nil
+		}
+	}
+
+	if cmd.Flags().Changed("command") {
+		TargetContainer(cmd, holder).Command =
+			ParseQuotedString(FlagAsString(cmd, "command"))
+	}
+
+	if cmd.Flags().Changed("args") {
+		TargetContainer(cmd, holder).Args =
+			ParseQuotedString(FlagAsString(cmd, "args"))
+	}
+
+	bindServices, _ := cmd.Flags().GetStringArray("bind")
+	for _, bindName := range bindServices {
+		if !BindResource(holder, bindName, true) {
+			AddServiceBind(holder.MustServiceBinds(), bindName)
+		}
+	}
+
+	bindServices, _ = cmd.Flags().GetStringArray("unbind")
+	for _, bindName := range bindServices {
+		if !BindResource(holder, bindName, false) {
+			RemoveServiceBind(holder.MustServiceBinds(), bindName)
+		}
+	}
+}
+
+// Returns the first container, which is the one the flags edit by default.
+// It's added if it's not there yet.
+func MainContainer(containers *[]*AcaAppContainer) *AcaAppContainer {
+	if len(*containers) == 0 {
+		*containers = []*AcaAppContainer{{}}
+	}
+	return (*containers)[0]
+}
+
+// Returns the container's CPU cores and memory (in Gi). When just one of
+// them is set the other one follows ACA's 2Gi per core ratio, and when
+// neither is set it's 0.5 cores and 1Gi.
+func (c *AcaAppContainer) EffectiveResources() (float64, float64) {
+	cpu, mem := 0.0, 0.0
+	if c.Resources != nil {
+		if c.Resources.CPU != nil {
+			cpu = *(c.Resources.CPU)
+		}
+		if c.Resources.Memory != nil {
+			mem = ParseMemory(*(c.Resources.Memory))
+		}
+	}
+	switch {
+	case cpu == 0 && mem == 0:
+		return 0.5, 1
+	case mem == 0:
+		return cpu, cpu * 2
+	case cpu == 0:
+		return mem / 2, mem
+	}
+	return cpu, mem
+}
+
+// Parses memory like "1.5Gi", or just "1.5", into Gi
+func ParseMemory(str string) float64 {
+	mem, err := strconv.ParseFloat(strings.TrimSuffix(str, "Gi"), 64)
+	if err != nil || mem <= 0 {
+		ErrStop("Memory %q must be in Gi, e.g. 1Gi", str)
+	}
+	return mem
+}
+
+func FormatMemory(mem float64) string {
+	return strconv.FormatFloat(mem, 'f', -1, 64) + "Gi"
+}
+
+func (app *AcaApp) CheckResources() {
+	if props := app.Properties; props != nil && props.Template != nil {
+		CheckResources("App "+strconv.Quote(app.Name),
+			props.WorkloadProfileName, props.Template.Containers)
+	}
+}
+
+// On the Consumption plan the containers, all together, must use a
+// multiple of 0.25 cores, up to 4, with 2Gi of memory per core. Other
+// workload profiles are left for Azure to check. "what" is the app or job
+// for the error message. It's done by every path that saves an app or a
+// job, so it must not change anything.
+func CheckResources(what string, profile *string, containers []*AcaAppContainer) {
+	if wp := NotNil(profile); wp != "" &&
+		!strings.EqualFold(wp, "Consumption") {
+		return
+	}
+
+	cpu, mem := 0.0, 0.0
+	for _, c := range containers {
+		cCPU, cMem := c.EffectiveResources()
+		cpu, mem = cpu+cCPU, mem+cMem
+	}
+	if cpu == 0 {
+		return
+	}
+
+	quarters := cpu * 4
+	if quarters != float64(int(quarters)) || cpu > 4 || mem != cpu*2 {
+		ErrStop("%s would use %v cores and %s, ACA needs 0.25 to 4 "+
+			"cores, in 0.25 steps, with 2Gi per core (e.g. 0.5 and 1Gi, "+
+			"1.25 and 2.5Gi)", what, cpu, FormatMemory(mem))
+	}
+}
+
+// ARM names unnamed containers "main"
+func (c *AcaAppContainer) ContainerName() string {
+	if c.Name == nil {
+		return "main"
+	}
+	return *(c.Name)
+}
+
+func FindContainer(containers []*AcaAppContainer, name string) *AcaAppContainer {
+	for _, c := range containers {
+		if c.ContainerName() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Returns the container that the container flags apply to. That's the one
+// named by "--container", or "--init-container", and it's added if it's
+// not there yet. Otherwise it's the first one.
+func TargetContainer(cmd *cobra.Command, holder AcaContainerHolder) *AcaAppContainer {
+	containers, name := holder.MustContainers(), ""
+	if cmd.Flags().Changed("init-container") {
+		containers = holder.MustInitContainers()
+		name = FlagAsString(cmd, "init-container")
+	} else if cmd.Flags().Changed("container") {
+		name = FlagAsString(cmd, "container")
+	} else {
+		return MainContainer(containers)
+	}
+
+	if name == "" {
+		ErrStop("Container name can't be empty")
+	}
+	if c := FindContainer(*containers, name); c != nil {
+		return c
+	}
+	if !cmd.Flags().Changed("image") {
+		ErrStop("New container %q needs an '--image'", name)
+	}
+	c := &AcaAppContainer{Name: StringPtr(name)}
+	*containers = append(*containers, c)
+	return c
+}
+
+// Probe options as they appear in "--probe" and in forms
+var ProbeOptions = []string{"delay", "period", "timeout", "success", "failure"}
+
+func (p *AcaAppProbe) OptionPtr(option string) **int {
+	switch option {
+	case "delay":
+		return &p.InitialDelaySeconds
+	case "period":
+		return &p.PeriodSeconds
+	case "timeout":
+		return &p.TimeoutSeconds
+	case "success":
+		return &p.SuccessThreshold
+	case "failure":
+		return &p.FailureThreshold
+	}
+	return nil
+}
+
+// Returns the probe in "--probe" syntax, minus the "TYPE=", e.g.:
+//
+//	http:/healthz:8080,period=10,failure=3
+//	tcp:8080
+func (p *AcaAppProbe) String() string {
+	str := ""
+	if get := p.HttpGet; get != nil {
+		scheme := strings.ToLower(NotNil(get.Scheme))
+		if scheme == "" {
+			scheme = "http"
+		}
+		str = fmt.Sprintf("%s:%s:%s", scheme, NotNil(get.Path),
+			IntPtrString(get.Port))
+		if get.Host != nil {
+			str += ",host=" + *(get.Host)
+		}
+	} else if tcp := p.TcpSocket; tcp != nil {
+		str = "tcp:" + IntPtrString(tcp.Port)
+		if tcp.Host != nil {
+			str += ",host=" + *(tcp.Host)
+		}
+	}
+	for _, option := range ProbeOptions {
+		if val := *(p.OptionPtr(option)); val != nil {
+			str += fmt.Sprintf(",%s=%d", option, *val)
+		}
+	}
+	return str
+}
+
+// Parses a probe of the given type ("liveness", "readiness" or "startup")
+// from the form used by "--probe":
+//
+//	http:PATH:PORT[,OPTION=N...]
+//	https:PATH:PORT[,OPTION=N...]
+//	tcp:PORT[,OPTION=N...]
+//
+// where OPTION is one of host, delay, period, timeout, success or failure.
+func ParseProbe(probeType string, spec string) *AcaAppProbe {
+	switch strings.ToLower(probeType) {
+	case "liveness", "readiness", "startup":
+	default:
+		ErrStop("Probe type %q must be one of: liveness, readiness, startup",
+			probeType)
+	}
+
+	probe := &AcaAppProbe{
+		Type: StringPtr(strings.ToUpper(probeType[:1]) +
+			strings.ToLower(probeType[1:])),
+	}
+
+	parts := strings.Split(spec, ",")
+	getPort := func(str string) *int {
+		port, err := strconv.Atoi(str)
+		if err != nil || port <= 0 || port > 65535 {
+			ErrStop("Probe %q has an invalid port %q", spec, str)
+		}
+		return &port
+	}
+
+	kind, target, _ := strings.Cut(parts[0], ":")
+	host := (*string)(nil)
+	switch strings.ToLower(kind) {
+	case "http", "https":
+		i := strings.LastIndex(target, ":")
+		if i < 0 || !strings.HasPrefix(target, "/") {
+			ErrStop("Probe %q must be of the form: %s:PATH:PORT", spec, kind)
+		}
+		probe.HttpGet = &AcaAppProbeHttpGet{
+			Path: StringPtr(target[:i]),
+			Port: getPort(target[i+1:]),
+		}
+		if strings.ToLower(kind) == "https" {
+			probe.HttpGet.Scheme = StringPtr("HTTPS")
+		}
+	case "tcp":
+		probe.TcpSocket = &AcaAppProbeTcp{Port: getPort(target)}
+	default:
+		ErrStop("Probe %q must start with 'http:', 'https:' or 'tcp:'", spec)
+	}
+
+	for _, part := range parts[1:] {
+		key, value, found := strings.Cut(part, "=")
+		if !found || value == "" {
+			ErrStop("Probe option %q must be of the form: KEY=VALUE", part)
+		}
+		if key == "host" {
+			host = StringPtr(value)
+			continue
+		}
+		ptr := probe.OptionPtr(key)
+		if ptr == nil {
+			ErrStop("Unknown probe option %q, must be one of: host, %s", key,
+				strings.Join(ProbeOptions, ", "))
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			ErrStop("Probe option %q must be a non-negative number", part)
+		}
+		*ptr = &n
+	}
+
+	if probe.HttpGet != nil {
+		probe.HttpGet.Host = host
+	} else {
+		probe.TcpSocket.Host = host
+	}
+	return probe
+}
+
+// Adds, or replaces, the container's probe of the same type
+func (c *AcaAppContainer) SetProbe(probe *AcaAppProbe) {
+	c.RemoveProbe(NotNil(probe.Type))
+	c.Probes = append(c.Probes, probe)
+}
+
+// Returns true if the container had a probe of type "probeType"
+func (c *AcaAppContainer) RemoveProbe(probeType string) bool {
+	for i, probe := range c.Probes {
+		if strings.EqualFold(NotNil(probe.Type), probeType) {
+			c.Probes = append(c.Probes[:i], c.Probes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Processes "--probe TYPE=SPEC" and "--remove-probe TYPE", see ParseProbe.
+// They apply to the container chosen by "--container".
+func (app *AcaApp) ProcessProbeFlags(cmd *cobra.Command) {
+	if cmd.Flags().Changed("probe") {
+		probes, _ := cmd.Flags().GetStringArray("probe")
+		for _, probe := range probes {
+			probeType, spec, found := strings.Cut(probe, "=")
+			if !found || spec == "" {
+				ErrStop("Probe %q must be of the form: TYPE=SPEC", probe)
 			}
+			TargetContainer(cmd, app).SetProbe(ParseProbe(probeType, spec))
 		}
+	}
 
+	if cmd.Flags().Changed("remove-probe") {
+		probes, _ := cmd.Flags().GetStringArray("remove-probe")
+		for _, probeType := range probes {
+			c := TargetContainer(cmd, app)
+			if !c.RemoveProbe(probeType) {
+				ErrStop("Container %q has no %s probe", c.ContainerName(),
+					probeType)
+			}
+		}
 	}
+}
 
-	if cmd.Flags().Changed("ingress") {
-		tmp, _ := cmd.Flags().GetString("ingress")
-		val := (tmp == "external")
-		SetJson(app,
-			`{"properties":{"configuration":{"ingress":{"external":%v}}}}`, val)
+// Apps exposed to the internet should tell ACA when they're ready for
+// traffic, so warn if none of the containers have a readiness probe
+func (app *AcaApp) CheckReadinessProbe() {
+	config := app.MustProperties().Configuration
+	if config == nil || config.Ingress == nil ||
+		config.Ingress.External == nil || !*(config.Ingress.External) {
+		return
 	}
+	for _, c := range *(app.MustContainers()) {
+		for _, probe := range c.Probes {
+			if strings.EqualFold(NotNil(probe.Type), "readiness") {
+				return
+			}
+		}
+	}
+	fmt.Printf("Warning: app %q has external ingress but no readiness "+
+		"probe, see '--probe readiness=...'\n", app.Name)
+}
 
-	if cmd.Flags().Changed("internal") {
-		SetJson(app,
-			`{"properties":{"configuration":{"ingress":{"external":false}}}}`)
+// Removes the container, or init container, called "name". An app can't be
+// left without any containers.
+func RemoveContainer(holder AcaContainerHolder, name string) {
+	for _, containers := range []*[]*AcaAppContainer{
+		holder.MustContainers(), holder.MustInitContainers()} {
+		for i, c := range *containers {
+			if c.ContainerName() != name {
+				continue
+			}
+			if containers == holder.MustContainers() && len(*containers) == 1 {
+				ErrStop("Can't remove %q, it's the only container", name)
+			}
+			*containers = append((*containers)[:i], (*containers)[i+1:]...)
+			return
+		}
 	}
-	if cmd.Flags().Changed("external") {
-		SetJson(app,
-			`{"properties":{"configuration":{"ingress":{"external":true}}}}`)
+	ErrStop("Container %q was not found", name)
+}
+
+// Processes an "--env" value, which is either NAME=VALUE to add/update the
+// env var, or just NAME to remove it. A VALUE of "secretref:SECRET" makes
+// it reference one of the app's secrets.
+func (c *AcaAppContainer) SetEnv(env string) {
+	name, val, found := strings.Cut(env, "=")
+
+	if !found {
+		c.RemoveEnv(name)
+	} else if ref, isRef := strings.CutPrefix(val, "secretref:"); isRef {
+		c.SetEnvVar(&AcaAppEnv{Name: StringPtr(name), SecretRef: StringPtr(ref)})
+	} else {
+		c.SetEnvVar(&AcaAppEnv{Name: StringPtr(name), Value: StringPtr(val)})
 	}
+}
 
-	if cmd.Flags().Changed("port") {
-		port, _ := cmd.Flags().GetInt("port")
-		SetJson(app,
-			`{"properties":{"configuration":{"ingress":{"targetPort":%d}}}}`, port)
+// Processes "--env-file", "--env" and "--clear-env". The files are applied
+// first so "--env" can override them. Vars that already exist keep their
+// place, new ones go at the end, and "--clear-env" removes any that weren't
+// just set, other than the BindEnvVars.
+func ProcessEnvFlags(cmd *cobra.Command, holder AcaContainerHolder) {
+	envs := []string{}
+	files, _ := cmd.Flags().GetStringArray("env-file")
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		NoErr(err, "Error reading env file %q: %s", file, err)
+		envs = append(envs, DotEnvVars(data)...)
 	}
+	flagEnvs, _ := cmd.Flags().GetStringArray("env")
+	envs = append(envs, flagEnvs...)
 
-	if cmd.Flags().Changed("bind") {
-		bindServices, _ := cmd.Flags().GetStringArray("bind")
+	clear, _ := cmd.Flags().GetBool("clear-env")
+	if len(envs) == 0 && !clear {
+		return
+	}
 
-		templ := app.Properties.Template
+	c := TargetContainer(cmd, holder)
+	keep := map[string]bool{}
+	for _, env := range envs {
+		c.SetEnv(env)
+		name, _, found := strings.Cut(env, "=")
+		keep[name] = found
+	}
 
-		for _, bindName := range bindServices {
-			found := false
-			for i, sb := range templ.ServiceBinds {
-				if sb.ServiceId != nil && *sb.ServiceId == bindName {
-					templ.ServiceBinds = append(templ.ServiceBinds[:i],
-						templ.ServiceBinds[i+1:]...)
-					found = true
-					break
+	if clear {
+		for _, names := range BindEnvVars {
+			for _, name := range names {
+				if _, ok := keep[name]; !ok {
+					keep[name] = true
 				}
 			}
-			if found {
-				ErrStop("Binding %q already exists", bindName)
-			}
+		}
 
-			newBind := &AcaAppServiceBind{
-				ServiceId: StringPtr(bindName),
-				// Name:      bindName,
+		newEnv := []*AcaAppEnv{}
+		for _, env := range c.Env {
+			if keep[NotNil(env.Name)] {
+				newEnv = append(newEnv, env)
 			}
-			templ.ServiceBinds = append(templ.ServiceBinds, newBind)
+		}
+		c.Env = newEnv
+		if len(c.Env) == 0 {
+			c.Env = nil
+		}
+	}
+}
+
+// azx aca-app env export -n APP [--container NAME]
+//
+// Writes the container's env vars to stdout in dotenv syntax, which
+// "--env-file" can read back in.
+func ExportAcaAppEnvFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: ExportAcaAppEnvFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: ExportAcaAppEnvFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	app := LoadStageResource("aca-app", name).Object.(*AcaApp)
+
+	c := MainContainer(app.MustContainers())
+	if cName := FlagAsString(cmd, "container"); cName != "" {
+		c = FindContainer(append(*(app.MustContainers()),
+			*(app.MustInitContainers())...), cName)
+		if c == nil {
+			ErrStop("App %q has no container %q", name, cName)
 		}
 	}
 
-	if cmd.Flags().Changed("unbind") {
-		bindServices, _ := cmd.Flags().GetStringArray("unbind")
+	for _, env := range c.Env {
+		value := NotNil(env.Value)
+		if env.SecretRef != nil {
+			value = "secretref:" + *(env.SecretRef)
+		} else if strings.HasPrefix(value, "secretref:") {
+			ErrStop("Env var %q has a value that starts with \"secretref:\", "+
+				"it can't be exported", NotNil(env.Name))
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			ErrStop("Env var %q has a multi-line value, it can't be "+
+				"exported", NotNil(env.Name))
+		}
+		fmt.Println(DotEnvLine(NotNil(env.Name), value))
+	}
+}
+
+// Processes a "--secret NAME[=PARAM]" value. The secret's value is the
+// ${PARAM} parameter, which is only resolved when the ARM json is sent to
+// Azure (see ParamValue), so the real value never lands in the stage file.
+// PARAM is used as is, case and all, since env var names are case sensitive.
+func SetParamSecret(secrets *[]*AcaAppSecret, secret string) {
+	name, param, _ := strings.Cut(secret, "=")
+	if name == "" {
+		ErrStop("Secret %q is missing a name", secret)
+	}
+	if param == "" {
+		param = SecretParamName(name)
+	}
+	value := "${" + param + "}"
+	if !paramRE.MatchString(value) {
+		ErrStop("Secret parameter %q must be letters, digits and '_'s", param)
+	}
+	SetSecret(secrets, &AcaAppSecret{Name: StringPtr(name), Value: &value})
+}
 
-		templ := app.Properties.Template
+// "db-password" -> "DB_PASSWORD"
+func SecretParamName(name string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
 
-		for _, bindName := range bindServices {
+// Makes sure the env vars of all of the containers only reference secrets
+// that exist. Done after all of the flags since they can add secrets too.
+func CheckSecretRefs(holder AcaContainerHolder) {
+	containers := append(append([]*AcaAppContainer{},
+		*(holder.MustContainers())...), *(holder.MustInitContainers())...)
+	for _, c := range containers {
+		for _, env := range c.Env {
+			if env.SecretRef == nil {
+				continue
+			}
 			found := false
-			for i, sb := range templ.ServiceBinds {
-				// TODO check for the same service connected more than
-				// once but w/o them giving us a bindingName
-				if sb.ServiceId != nil && *sb.ServiceId == bindName {
-					templ.ServiceBinds = append(templ.ServiceBinds[:i],
-						templ.ServiceBinds[i+1:]...)
-					found = true
-					break
-				}
+			for _, s := range *(holder.MustSecrets()) {
+				found = found || NotNil(s.Name) == *(env.SecretRef)
 			}
 			if !found {
-				ErrStop("Binding %q was not found", bindName)
+				ErrStop("Env var %q references secret %q, which doesn't exist",
+					NotNil(env.Name), *(env.SecretRef))
 			}
 		}
 	}
 }
+
+func (c *AcaAppContainer) FindEnv(name string) *AcaAppEnv {
+	for _, env := range c.Env {
+		if NotNil(env.Name) == name {
+			return env
+		}
+	}
+	return nil
+}
+
+// Adds "env", or replaces the existing env var with the same name
+func (c *AcaAppContainer) SetEnvVar(env *AcaAppEnv) {
+	for i, tmpE := range c.Env {
+		if NotNil(tmpE.Name) == NotNil(env.Name) {
+			c.Env[i] = env
+			return
+		}
+	}
+	c.Env = append(c.Env, env)
+}
+
+func (c *AcaAppContainer) RemoveEnv(name string) {
+	for i, env := range c.Env {
+		if NotNil(env.Name) == name {
+			c.Env = append(c.Env[:i], c.Env[i+1:]...)
+			return
+		}
+	}
+}
+
+// Adds "secret", or replaces the existing secret with the same name
+func SetSecret(secrets *[]*AcaAppSecret, secret *AcaAppSecret) {
+	for i, s := range *secrets {
+		if NotNil(s.Name) == NotNil(secret.Name) {
+			(*secrets)[i] = secret
+			return
+		}
+	}
+	*secrets = append(*secrets, secret)
+}
+
+func RemoveSecret(secrets *[]*AcaAppSecret, name string) {
+	for i, s := range *secrets {
+		if NotNil(s.Name) == name {
+			*secrets = append((*secrets)[:i], (*secrets)[i+1:]...)
+			return
+		}
+	}
+}
+
+func AddServiceBind(binds *[]*AcaAppServiceBind, bindName string) {
+	bindName = AcaServiceBindName(bindName)
+	for _, sb := range *binds {
+		if sb.ServiceId != nil && *sb.ServiceId == bindName {
+			ErrStop("Binding %q already exists", bindName)
+		}
+	}
+
+	*binds = append(*binds, &AcaAppServiceBind{
+		ServiceId: StringPtr(bindName),
+	})
+}
+
+func RemoveServiceBind(binds *[]*AcaAppServiceBind, bindName string) {
+	bindName = AcaServiceBindName(bindName)
+	for i, sb := range *binds {
+		// TODO check for the same service connected more than
+		// once but w/o them giving us a bindingName
+		if sb.ServiceId != nil && *sb.ServiceId == bindName {
+			*binds = append((*binds)[:i], (*binds)[i+1:]...)
+			return
+		}
+	}
+	ErrStop("Binding %q was not found", bindName)
+}
+
+// Returns the resources that the containers' env vars reference via
+// ${type/name.prop} values
+func ContainerSubstitutionRefs(containers []*AcaAppContainer, sub string, rg string) []*ResourceReference {
+	refs := []*ResourceReference{}
+	for _, c := range containers {
+		for _, env := range c.Env {
+			refs = append(refs, SubstitutionRefs(NotNil(env.Value), sub, rg)...)
+		}
+	}
+	return refs
+}
+
+// Adds, or updates, the registry to pull images from. If there's no identity
+// then the admin credentials of the ACR are used.
+func (app *AcaApp) SetRegistry(server string, identity string) {
+	config := app.MustConfiguration()
+
+	var reg *AcaAppRegistry
+	for _, r := range config.Registries {
+		if strings.EqualFold(NotNil(r.Server), server) {
+			reg = r
+			break
+		}
+	}
+	if reg == nil {
+		reg = &AcaAppRegistry{Server: StringPtr(server)}
+		config.Registries = append(config.Registries, reg)
+	}
+
+	if identity != "" {
+		app.AddIdentity(identity)
+		reg.Identity = StringPtr(identity)
+		reg.Username = nil
+		reg.PasswordSecretRef = nil
+		return
+	}
+
+	acrName := AcrNameFromServer(server)
+	if acrName == "" {
+		ErrStop("Registry %q isn't an ACR so '--registry-identity' is "+
+			"required", server)
+	}
+	reg.Identity = nil
+	reg.Username = StringPtr(acrName)
+	reg.PasswordSecretRef = StringPtr(acrName + "-password")
+
+	// If the ACR is in our stage, make sure its admin user is enabled
+	stage := GetConfigProperty("currentStage")
+	res, err := ResourceFromFile(stage, ResourceFileName("acr", acrName))
+	if err != nil {
+		return
+	}
+	acr := res.Object.(*Acr)
+	if acr.Properties == nil || acr.Properties.AdminUserEnabled == nil ||
+		*(acr.Properties.AdminUserEnabled) == false {
+		acr.MustProperties().AdminUserEnabled = BoolPtr(true)
+		acr.Save()
+		fmt.Printf("Enabled the admin user on acr/%s\n", acrName)
+	}
+}
+
+// Gives "identity" ('system' or a user-assigned identity) the role that the
+// scaler needs to read the namespace's queues
+func (app *AcaApp) AddQueueReaderRole(ns string, identity string) {
+	nsRef := ServiceBusRef(ns, app.Subscription, app.ResourceGroup)
+
+	principalId := ""
+	if strings.EqualFold(identity, "system") {
+		appRef := &ResourceReference{
+			Subscription:  app.Subscription,
+			ResourceGroup: app.ResourceGroup,
+			Type:          app.Type,
+			Name:          app.Name,
+		}
+		principalId = appRef.AsSubstitution(PrincipalProps["aca-app"].Prop)
+	} else {
+		principalId = IdentityRef(identity).AsSubstitution(
+			PrincipalProps["identity"].Prop)
+	}
+	roleID := RoleDefinitionID(nsRef.Subscription,
+		"Azure Service Bus Data Receiver")
+	raName := NameUUID(nsRef.AsID(), roleID, principalId)
+
+	ra := &RoleAssignment{}
+	ra.InitScopedResource(ra, "Microsoft.Authorization/roleAssignments",
+		"role-assignment", raName, nsRef)
+	props := ra.MustProperties()
+	props.RoleDefinitionId = StringPtr(roleID)
+	props.PrincipalId = StringPtr(principalId)
+	props.PrincipalType = StringPtr("ServicePrincipal")
+	ra.Save()
+	fmt.Printf("Added role-assignment/%s\n", raName)
+}
+
+// Adds, or replaces, a KEDA "azure-servicebus" scale rule for the
+// NAMESPACE/QUEUE "queue". If there's no identity then the namespace's
+// connection string is used, via a secret that's generated at ARM time.
+func (app *AcaApp) SetQueueScaleRule(queue string, messages int, identity string) {
+	ns, queueName, found := strings.Cut(queue, "/")
+	if !found || ns == "" || queueName == "" {
+		ErrStop("Queue %q must be of the form: NAMESPACE/QUEUE", queue)
+	}
+	if messages < 0 {
+		ErrStop("'--messages' can't be negative")
+	}
+
+	custom := &AcaAppCustomScaleRule{
+		Type: StringPtr("azure-servicebus"),
+		Metadata: map[string]string{
+			"namespace": ns,
+			"queueName": queueName,
+		},
+	}
+	if messages > 0 {
+		custom.Metadata["messageCount"] = strconv.Itoa(messages)
+	}
+	if identity != "" {
+		app.AddIdentity(identity)
+		custom.Identity = StringPtr(identity)
+		app.AddQueueReaderRole(ns, identity)
+	} else {
+		custom.Auth = []*AcaAppScaleRuleAuth{{
+			SecretRef:        StringPtr(strings.ToLower(ns) + "-connection"),
+			TriggerParameter: StringPtr("connection"),
+		}}
+	}
+
+	rule := &AcaAppScaleRule{
+		Name:   StringPtr(strings.ToLower(ns + "-" + queueName)),
+		Custom: custom,
+	}
+
+	scale := app.MustScale()
+	for i, r := range scale.Rules {
+		if NotNil(r.Name) == *(rule.Name) {
+			scale.Rules[i] = rule
+			return
+		}
+	}
+	scale.Rules = append(scale.Rules, rule)
+}
+
+// Short names for the metadata of the built-in rule types
+var ScaleRuleAliases = map[string]map[string]string{
+	"http": {"concurrency": "concurrentRequests"},
+	"tcp":  {"concurrency": "concurrentConnections"},
+	"cron": {"replicas": "desiredReplicas"},
+}
+
+// Adds, or replaces, the scale rule described by "spec", see ParseScaleRule
+func (app *AcaApp) SetScaleRule(spec string) {
+	rule := ParseScaleRule(app, spec)
+	if rule.Custom != nil && rule.Custom.Identity != nil {
+		app.AddIdentity(*(rule.Custom.Identity))
+	}
+
+	scale := app.MustScale()
+	for i, r := range scale.Rules {
+		if NotNil(r.Name) == NotNil(rule.Name) {
+			scale.Rules[i] = rule
+			return
+		}
+	}
+	scale.Rules = append(scale.Rules, rule)
+}
+
+// Parses a "--scale-rule" value, a comma separated list of KEY=VALUE.
+// "name" is required and "type" defaults to "http". "auth" (PARAM:SECRET)
+// can be repeated, and its secret must be one of the holder's, "identity"
+// is only for KEDA scalers, and everything else is metadata. "cron" is the
+// KEDA cron scaler.
+func ParseScaleRule(holder AcaContainerHolder, spec string) *AcaAppScaleRule {
+	name, ruleType, identity := "", "http", ""
+	metadata := map[string]string{}
+	auth := []*AcaAppScaleRuleAuth{}
+
+	// Values with commas in them, e.g. cron schedules, need to be quoted
+	for _, part := range SplitQuoted(spec, ',') {
+		key, value, found := strings.Cut(part, "=")
+		if !found || key == "" || value == "" {
+			ErrStop("Scale rule %q must be of the form: KEY=VALUE,... "+
+				"(quote any VALUE that has a comma)", spec)
+		}
+		switch key {
+		case "name":
+			name = value
+		case "type":
+			ruleType = value
+		case "identity":
+			identity = value
+		case "auth":
+			param, secret, found := strings.Cut(value, ":")
+			if !found || param == "" || secret == "" {
+				ErrStop("Scale rule auth %q must be of the form: PARAM:SECRET",
+					value)
+			}
+			known := false
+			for _, s := range *(holder.MustSecrets()) {
+				known = known || NotNil(s.Name) == secret
+			}
+			if !known {
+				ErrStop("Scale rule auth %q: there's no secret named %q, "+
+					"see '--secret'", value, secret)
+			}
+			auth = append(auth, &AcaAppScaleRuleAuth{
+				TriggerParameter: StringPtr(param),
+				SecretRef:        StringPtr(secret),
+			})
+		default:
+			metadata[key] = value
+		}
+	}
+	if name == "" {
+		ErrStop("Scale rule %q is missing its 'name'", spec)
+	}
+
+	for alias, key := range ScaleRuleAliases[ruleType] {
+		if value, ok := metadata[alias]; ok {
+			delete(metadata, alias)
+			metadata[key] = value
+		}
+	}
+	if len(metadata) == 0 {
+		metadata = nil
+	}
+	if len(auth) == 0 {
+		auth = nil
+	}
+
+	rule := &AcaAppScaleRule{Name: StringPtr(name)}
+	switch ruleType {
+	case "http":
+		rule.Http = &AcaAppHttpScaleRule{Metadata: metadata, Auth: auth}
+	case "tcp":
+		rule.Tcp = &AcaAppHttpScaleRule{Metadata: metadata, Auth: auth}
+	default:
+		rule.Custom = &AcaAppCustomScaleRule{
+			Type:     StringPtr(ruleType),
+			Metadata: metadata,
+			Auth:     auth,
+		}
+		if identity != "" {
+			rule.Custom.Identity = StringPtr(identity)
+		}
+	}
+	if identity != "" && rule.Custom == nil {
+		ErrStop("Only KEDA scale rules can use an 'identity'")
+	}
+	if ruleType == "cron" {
+		for _, key := range []string{"timezone", "start", "end",
+			"desiredReplicas"} {
+			if metadata[key] == "" {
+				ErrStop("Cron scale rule %q is missing %q", name, key)
+			}
+		}
+	}
+	return rule
+}
+
+func (app *AcaApp) RemoveScaleRule(name string) {
+	scale := app.MustScale()
+	for i, r := range scale.Rules {
+		if NotNil(r.Name) == name {
+			scale.Rules = append(scale.Rules[:i], scale.Rules[i+1:]...)
+			if reflect.DeepEqual(*scale, AcaAppScale{}) {
+				app.Properties.Template.Scale = nil
+			}
+			return
+		}
+	}
+	ErrStop("Scale rule %q was not found", name)
+}
+
+// Adds (or updates) a custom domain that uses a managed certificate. If
+// the domain is in a DNS zone in the stage then the records that ACA needs
+// to validate it are added too, otherwise the user has to create them.
+func (app *AcaApp) SetCustomDomain(domain string) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	envRef := app.MustProperties().ResolveEnvironmentId()
+	certName := AcaManagedCertName(domain)
+	stage := GetConfigProperty("currentStage")
+
+	zone, rel := FindDnsZone(domain)
+	if zone != nil && rel == "" {
+		ErrStop("Custom domain %q is the apex of a DNS zone, which isn't "+
+			"supported", domain)
+	}
+
+	fileName := ResourceFileName("aca-managed-cert", envRef.Name+"/"+certName)
+	if _, err := ResourceFromFile(stage, fileName); err != nil {
+		NewAcaManagedCert(envRef, certName, domain).Save()
+		fmt.Printf("Added aca-managed-cert/%s/%s\n", envRef.Name, certName)
+	}
+
+	cname := app.Name + "." + envRef.AsSubstitution("properties.defaultDomain")
+	txt := envRef.AsSubstitution(
+		"properties.customDomainConfiguration.customDomainVerificationId")
+
+	if zone != nil {
+		for _, rec := range []struct{ kind, name, value string }{
+			{"CNAME", rel, cname},
+			{"TXT", "asuid." + rel, txt},
+		} {
+			nice := "dns-" + strings.ToLower(rec.kind)
+			fileName := ResourceFileName(nice, zone.Name+"/"+rec.name)
+			if _, err := ResourceFromFile(stage, fileName); err != nil {
+				NewDnsRecord(zone, rec.kind, rec.name, rec.value).Save()
+				fmt.Printf("Added %s/%s/%s\n", nice, zone.Name, rec.name)
+			}
+		}
+	} else {
+		fmt.Printf("No DNS zone for %q is in the stage, create these records "+
+			"before provisioning:\n", domain)
+		fmt.Printf("  CNAME %s -> %s.<env default domain>\n", domain, app.Name)
+		fmt.Printf("  TXT   asuid.%s -> <env verification ID>\n", domain)
+	}
+
+	newCd := &AcaAppCustomDomain{
+		Name:          StringPtr(domain),
+		BindingType:   StringPtr("SniEnabled"),
+		CertificateId: StringPtr(certName),
+	}
+
+	ingress := app.MustIngress()
+	for i, cd := range ingress.CustomDomains {
+		if strings.EqualFold(NotNil(cd.Name), domain) {
+			ingress.CustomDomains[i] = newCd
+			return
+		}
+	}
+	ingress.CustomDomains = append(ingress.CustomDomains, newCd)
+}
+
+// A managed certificate can only be created once its hostname is on the app,
+// so for any that aren't in Azure yet: the app is added with those hostnames
+// unbound, then the certificates are created, and then they're bound.
+func (app *AcaApp) ProvisionSteps(r *ResourceBase) {
+	certs := []*ResourceBase{}
+	unbound := []*AcaAppCustomDomain{}
+	if app.Properties != nil && app.Properties.Configuration != nil &&
+		app.Properties.Configuration.Ingress != nil {
+		for _, cd := range app.Properties.Configuration.Ingress.CustomDomains {
+			certRef := app.Properties.ResolveCertificate(cd)
+			if certRef == nil {
+				continue
+			}
+			data, err := downloadResource(certRef.Subscription,
+				certRef.ResourceGroup, certRef.Type, certRef.Name,
+				certRef.APIVersion)
+			NoErr(err, "Error downloading %q: %s", certRef.Name, err)
+			if data != nil {
+				continue
+			}
+
+			cert, err := ResourceFromFile(r.Stage,
+				ResourceFileName("aca-managed-cert", certRef.Name))
+			NoErr(err, "Error reading the certificate for %q: %s",
+				NotNil(cd.Name), err)
+			certs = append(certs, cert)
+
+			cd.unbound = true
+			unbound = append(unbound, cd)
+		}
+	}
+
+	r.Put()
+	if len(certs) == 0 {
+		return
+	}
+
+	for _, cert := range certs {
+		cert.Provision()
+	}
+	for _, cd := range unbound {
+		cd.unbound = false
+	}
+	fmt.Printf("Provision: %s/%s (binding certificates)\n", r.NiceType,
+		r.Name)
+	r.Put()
+}
+
+// Removes the custom domain along with the managed certificate and DNS
+// records that SetCustomDomain created for it
+func (app *AcaApp) RemoveCustomDomain(domain string) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	ingress := app.MustIngress()
+	pos := -1
+	for i, cd := range ingress.CustomDomains {
+		if strings.EqualFold(NotNil(cd.Name), domain) {
+			pos = i
+			break
+		}
+	}
+	if pos < 0 {
+		ErrStop("Custom domain %q was not found", domain)
+	}
+	ingress.CustomDomains = append(ingress.CustomDomains[:pos],
+		ingress.CustomDomains[pos+1:]...)
+
+	// Just from the stage, they're not deleted from Azure
+	stage := GetConfigProperty("currentStage")
+	envRef := app.MustProperties().ResolveEnvironmentId()
+	certName := envRef.Name + "/" + AcaManagedCertName(domain)
+	if DeleteStageFile(stage,
+		ResourceFileName("aca-managed-cert", certName)) == nil {
+		fmt.Printf("Removed aca-managed-cert/%s\n", certName)
+	}
+
+	if zone, rel := FindDnsZone(domain); zone != nil && rel != "" {
+		for _, rec := range []string{"dns-cname/" + rel, "dns-txt/asuid." + rel} {
+			nice, name, _ := strings.Cut(rec, "/")
+			name = zone.Name + "/" + name
+			if DeleteStageFile(stage, ResourceFileName(nice, name)) == nil {
+				fmt.Printf("Removed %s/%s\n", nice, name)
+			}
+		}
+	}
+}
+
+// Returns the app's Dapr app ID, "" if Dapr isn't enabled
+func (app *AcaApp) DaprAppId() string {
+	if app.Properties == nil || app.Properties.Configuration == nil {
+		return ""
+	}
+	dapr := app.Properties.Configuration.Dapr
+	if dapr == nil || dapr.Enabled == nil || !*(dapr.Enabled) {
+		return ""
+	}
+	return NotNil(dapr.AppId)
+}
+
+// "--dapr-app-id" and "--dapr-port" imply "--dapr". Turning it off keeps
+// the other settings so it's easy to turn it back on.
+func (app *AcaApp) ProcessDaprFlags(cmd *cobra.Command) {
+	if !cmd.Flags().Changed("dapr") && !cmd.Flags().Changed("dapr-app-id") &&
+		!cmd.Flags().Changed("dapr-port") {
+		return
+	}
+
+	config := app.MustConfiguration()
+	if config.Dapr == nil {
+		config.Dapr = &AcaAppDapr{}
+	}
+	dapr := config.Dapr
+
+	enabled := true
+	if cmd.Flags().Changed("dapr") {
+		enabled, _ = cmd.Flags().GetBool("dapr")
+	}
+	dapr.Enabled = BoolPtr(enabled)
+
+	if cmd.Flags().Changed("dapr-app-id") {
+		dapr.AppId = NilStringPtr(FlagAsString(cmd, "dapr-app-id"))
+	}
+	if dapr.AppId == nil {
+		dapr.AppId = StringPtr(app.Name)
+	}
+
+	if cmd.Flags().Changed("dapr-port") {
+		port, _ := cmd.Flags().GetInt("dapr-port")
+		if port == 0 {
+			dapr.AppPort = nil
+		} else {
+			dapr.AppPort = &port
+		}
+	}
+}
+
+// Returns a reference to the env storage used by the volume, or nil if the
+// volume doesn't use one (e.g. EmptyDir)
+func (aap *AcaAppProperties) ResolveVolumeStorage(vol *AcaAppVolume) *ResourceReference {
+	if NotNil(vol.StorageType) != "AzureFile" || NotNil(vol.StorageName) == "" {
+		return nil
+	}
+
+	envRef := aap.ResolveEnvironmentId()
+	return &ResourceReference{
+		Subscription:  envRef.Subscription,
+		ResourceGroup: envRef.ResourceGroup,
+		Type:          "Microsoft.App/managedEnvironments/storages",
+		APIVersion:    GetResourceDef("Microsoft.App/managedEnvironments/storages").Defaults["APIVERSION"],
+		Name:          envRef.Name + "/" + *(vol.StorageName),
+		Origin:        *(vol.StorageName),
+	}
+}
+
+// Processes a "--volume" value, which is one of:
+//
+//	NAME=emptydir:PATH       - ephemeral volume
+//	NAME=ACCOUNT/SHARE:PATH  - Azure Files share, the env storage is created
+//	NAME=STORAGE:PATH        - existing env storage
+//	NAME                     - remove the volume, and its mounts
+//
+// The volume is mounted into the container picked by "--container" or
+// "--init-container", or else the first one.
+func (app *AcaApp) SetVolume(volume string, cmd *cobra.Command) {
+	templ := app.MustTemplate()
+
+	name, val, found := strings.Cut(volume, "=")
+	if name == "" {
+		ErrStop("Volume %q is missing a name", volume)
+	}
+
+	pos := -1
+	for i, vol := range templ.Volumes {
+		if NotNil(vol.Name) == name {
+			pos = i
+			break
+		}
+	}
+
+	if !found {
+		// Remove the volume and any mounts of it
+		if pos < 0 {
+			ErrStop("Volume %q was not found", name)
+		}
+		templ.Volumes = append(templ.Volumes[:pos], templ.Volumes[pos+1:]...)
+		for _, c := range append(templ.Containers, templ.InitContainers...) {
+			for i := 0; i < len(c.VolumeMounts); i++ {
+				if NotNil(c.VolumeMounts[i].VolumeName) == name {
+					c.VolumeMounts = append(c.VolumeMounts[:i],
+						c.VolumeMounts[i+1:]...)
+					i--
+				}
+			}
+		}
+		return
+	}
+
+	storage, path, found := strings.Cut(val, ":")
+	if !found || storage == "" || !strings.HasPrefix(path, "/") {
+		ErrStop("Volume %q must be of the form: NAME=STORAGE:/PATH", volume)
+	}
+
+	vol := &AcaAppVolume{Name: StringPtr(name)}
+	if strings.EqualFold(storage, "emptydir") {
+		vol.StorageType = StringPtr("EmptyDir")
+	} else {
+		vol.StorageType = StringPtr("AzureFile")
+		if account, share, isShare := strings.Cut(storage, "/"); isShare {
+			// Use (or create) an env storage for the share
+			storage = strings.ToLower(account + "-" + share)
+			envRef := app.MustProperties().ResolveEnvironmentId()
+			stage := GetConfigProperty("currentStage")
+			fileName := ResourceFileName("aca-env-storage", envRef.Name+"/"+storage)
+			if _, err := ResourceFromFile(stage, fileName); err != nil {
+				NewAcaEnvStorage(envRef, storage, account+"/"+share).Save()
+				fmt.Printf("Added aca-env-storage/%s/%s\n", envRef.Name, storage)
+			}
+		}
+		vol.StorageName = StringPtr(storage)
+	}
+
+	if pos >= 0 {
+		templ.Volumes[pos] = vol
+	} else {
+		templ.Volumes = append(templ.Volumes, vol)
+	}
+
+	c := TargetContainer(cmd, app)
+	for _, vm := range c.VolumeMounts {
+		if NotNil(vm.VolumeName) == name {
+			vm.MountPath = StringPtr(path)
+			return
+		}
+	}
+	c.VolumeMounts = append(c.VolumeMounts, &AcaAppVolumeMount{
+		VolumeName: StringPtr(name),
+		MountPath:  StringPtr(path),
+	})
+}
+
+// Processes a "--keyvault-secret" value, which is either NAME=VAULT/SECRET
+// to add/update the secret, or just NAME to remove it
+func (app *AcaApp) SetKeyVaultSecret(secret string, identity string) {
+	config := app.MustConfiguration()
+
+	name, val, found := strings.Cut(secret, "=")
+	if name == "" {
+		ErrStop("Secret %q is missing a name", secret)
+	}
+
+	pos := -1
+	for i, s := range config.Secrets {
+		if NotNil(s.Name) == name {
+			pos = i
+			break
+		}
+	}
+
+	if !found {
+		if pos < 0 {
+			ErrStop("Secret %q was not found", name)
+		}
+		config.Secrets = append(config.Secrets[:pos], config.Secrets[pos+1:]...)
+		return
+	}
+
+	vault, vaultSecret, found := strings.Cut(val, "/")
+	if !found || vault == "" || vaultSecret == "" {
+		ErrStop("Secret %q must be of the form: NAME=VAULT/SECRET", secret)
+	}
+	if identity == "" {
+		ErrStop("Secret %q needs an identity, see '--keyvault-identity'", name)
+	}
+
+	app.AddIdentity(identity)
+	newSecret := &AcaAppSecret{
+		Name:        StringPtr(name),
+		KeyVaultUrl: StringPtr(KeyVaultSecretURL(vault, vaultSecret)),
+		Identity:    StringPtr(identity),
+	}
+
+	if pos >= 0 {
+		config.Secrets[pos] = newSecret
+	} else {
+		config.Secrets = append(config.Secrets, newSecret)
+	}
+}
+
+// Sets the env var that the Application Insights SDKs look for. Its value is
+// a ${...} reference so the connection string is only pulled from Azure at
+// ARM time. "" removes it.
+func (app *AcaApp) SetAppInsights(name string, cmd *cobra.Command) {
+	envName := "APPLICATIONINSIGHTS_CONNECTION_STRING"
+
+	c := TargetContainer(cmd, app)
+	if name == "" {
+		c.RemoveEnv(envName)
+		return
+	}
+
+	aiRef := AppInsightsRef(name, app.Subscription, app.ResourceGroup)
+	c.SetEnvVar(&AcaAppEnv{
+		Name:  StringPtr(envName),
+		Value: StringPtr(aiRef.AsSubstitution("properties.ConnectionString")),
+	})
+}
+
+// Returns the app's identities, "system" first then the user-assigned ones
+func (app *AcaApp) IdentityNames() []string {
+	names := []string{}
+	if app.Identity == nil {
+		return names
+	}
+	if strings.Contains(NotNil(app.Identity.Type), "SystemAssigned") {
+		names = append(names, "system")
+	}
+	users := []string{}
+	for name := range app.Identity.UserAssignedIdentities {
+		users = append(users, name)
+	}
+	sort.Strings(users)
+	return append(names, users...)
+}
+
+// Assigns an identity to the app, either "system" or a user-assigned
+// identity's name (or ID)
+func (app *AcaApp) AddIdentity(identity string) {
+	if app.Identity == nil {
+		app.Identity = &AcaAppIdentity{}
+	}
+	if strings.EqualFold(identity, "system") {
+		app.Identity.Type = StringPtr("SystemAssigned")
+	} else {
+		if app.Identity.UserAssignedIdentities == nil {
+			app.Identity.UserAssignedIdentities =
+				map[string]*AcaAppUserIdentity{}
+		}
+		app.Identity.UserAssignedIdentities[identity] = &AcaAppUserIdentity{}
+	}
+	app.Identity.SetType()
+}
+
+func (app *AcaApp) RemoveIdentity(identity string) {
+	found := false
+	if id := app.Identity; id != nil {
+		if strings.EqualFold(identity, "system") {
+			found = strings.Contains(NotNil(id.Type), "SystemAssigned")
+			id.Type = nil
+		} else if _, found = id.UserAssignedIdentities[identity]; found {
+			delete(id.UserAssignedIdentities, identity)
+		}
+		id.SetType()
+	}
+	if !found {
+		ErrStop("Identity %q was not found", identity)
+	}
+	if len(app.IdentityNames()) == 0 {
+		app.Identity = nil
+	}
+}
+
+// Recalculates "type" based on which identities are assigned
+func (aai *AcaAppIdentity) SetType() {
+	system := strings.Contains(NotNil(aai.Type), "SystemAssigned")
+	user := len(aai.UserAssignedIdentities) > 0
+	if len(aai.UserAssignedIdentities) == 0 {
+		aai.UserAssignedIdentities = nil
+	}
+
+	switch {
+	case system && user:
+		aai.Type = StringPtr("SystemAssigned,UserAssigned")
+	case system:
+		aai.Type = StringPtr("SystemAssigned")
+	case user:
+		aai.Type = StringPtr("UserAssigned")
+	default:
+		aai.Type = nil
+	}
+}
+
+// ---
+
+// The app's built-in authentication (EasyAuth). There's only ever one of
+// these per app and it's always called "current".
+type AcaAppAuthPlatform struct {
+	Enabled        *bool   `json:"enabled,omitempty"`
+	RuntimeVersion *string `json:"runtimeVersion,omitempty"`
+}
+
+type AcaAppAuthGlobalValidation struct {
+	UnauthenticatedClientAction *string  `json:"unauthenticatedClientAction,omitempty"`
+	RedirectToProvider          *string  `json:"redirectToProvider,omitempty"`
+	ExcludedPaths               []string `json:"excludedPaths,omitempty"`
+}
+
+type AcaAppAuthEntraRegistration struct {
+	OpenIdIssuer            *string `json:"openIdIssuer,omitempty"`
+	ClientId                *string `json:"clientId,omitempty"`
+	ClientSecretSettingName *string `json:"clientSecretSettingName,omitempty"`
+}
+
+type AcaAppAuthEntraValidation struct {
+	AllowedAudiences []string `json:"allowedAudiences,omitempty"`
+}
+
+type AcaAppAuthEntra struct {
+	Enabled      *bool                        `json:"enabled,omitempty"`
+	Registration *AcaAppAuthEntraRegistration `json:"registration,omitempty"`
+	Validation   *AcaAppAuthEntraValidation   `json:"validation,omitempty"`
+}
+
+type AcaAppAuthIdentityProviders struct {
+	AzureActiveDirectory *AcaAppAuthEntra `json:"azureActiveDirectory,omitempty"`
+}
+
+type AcaAppAuthProperties struct {
+	Platform          *AcaAppAuthPlatform          `json:"platform,omitempty"`
+	GlobalValidation  *AcaAppAuthGlobalValidation  `json:"globalValidation,omitempty"`
+	IdentityProviders *AcaAppAuthIdentityProviders `json:"identityProviders,omitempty"`
+}
+
+type AcaAppAuth struct {
+	ResourceBase
+
+	Properties *AcaAppAuthProperties `json:"properties,omitempty"`
+}
+
+func (auth *AcaAppAuth) MarshalJSON() ([]byte, error) {
+	tmpAuth := *auth
+	if WhyMarshal == "ARM" {
+		// Copy it so we don't touch the original
+		props := AcaAppAuthProperties{}
+		if auth.Properties != nil {
+			props = *(auth.Properties)
+		}
+		tmpAuth.Properties = &props
+
+		platform := AcaAppAuthPlatform{}
+		if props.Platform != nil {
+			platform = *(props.Platform)
+		}
+		if platform.Enabled == nil {
+			platform.Enabled = BoolPtr(true)
+		}
+		props.Platform = &platform
+
+		gv := AcaAppAuthGlobalValidation{}
+		if props.GlobalValidation != nil {
+			gv = *(props.GlobalValidation)
+		}
+		if gv.UnauthenticatedClientAction == nil {
+			gv.UnauthenticatedClientAction = StringPtr("AllowAnonymous")
+		}
+		if gv.RedirectToProvider == nil &&
+			*(gv.UnauthenticatedClientAction) == "RedirectToLoginPage" &&
+			props.IdentityProviders != nil &&
+			props.IdentityProviders.AzureActiveDirectory != nil {
+			gv.RedirectToProvider = StringPtr("azureactivedirectory")
+		}
+		props.GlobalValidation = &gv
+
+		if ip := props.IdentityProviders; ip != nil &&
+			ip.AzureActiveDirectory != nil &&
+			ip.AzureActiveDirectory.Enabled == nil {
+			tmpIp := *ip
+			entra := *(ip.AzureActiveDirectory)
+			entra.Enabled = BoolPtr(true)
+			tmpIp.AzureActiveDirectory = &entra
+			props.IdentityProviders = &tmpIp
+		}
+	}
+	return json.Marshal(tmpAuth)
+}
+
+func (auth *AcaAppAuth) AppName() string {
+	app, _, _ := strings.Cut(auth.Name, "/")
+	return app
+}
+
+func (auth *AcaAppAuth) MustProperties() *AcaAppAuthProperties {
+	if auth.Properties == nil {
+		auth.Properties = &AcaAppAuthProperties{}
+	}
+	return auth.Properties
+}
+
+func (auth *AcaAppAuth) MustEntra() *AcaAppAuthEntra {
+	props := auth.MustProperties()
+	if props.IdentityProviders == nil {
+		props.IdentityProviders = &AcaAppAuthIdentityProviders{}
+	}
+	if props.IdentityProviders.AzureActiveDirectory == nil {
+		props.IdentityProviders.AzureActiveDirectory = &AcaAppAuthEntra{}
+	}
+	return props.IdentityProviders.AzureActiveDirectory
+}
+
+func (auth *AcaAppAuth) MustEntraRegistration() *AcaAppAuthEntraRegistration {
+	entra := auth.MustEntra()
+	if entra.Registration == nil {
+		entra.Registration = &AcaAppAuthEntraRegistration{}
+	}
+	return entra.Registration
+}
+
+func (auth *AcaAppAuth) MustGlobalValidation() *AcaAppAuthGlobalValidation {
+	props := auth.MustProperties()
+	if props.GlobalValidation == nil {
+		props.GlobalValidation = &AcaAppAuthGlobalValidation{}
+	}
+	return props.GlobalValidation
+}
+
+// Entra is the only provider azx manages, so that's the only one checked
+func (auth *AcaAppAuth) Entra() *AcaAppAuthEntra {
+	if props := auth.Properties; props != nil &&
+		props.IdentityProviders != nil {
+		return props.IdentityProviders.AzureActiveDirectory
+	}
+	return nil
+}
+
+func (auth *AcaAppAuth) IsEnabled() bool {
+	props := auth.Properties
+	return props == nil || props.Platform == nil ||
+		props.Platform.Enabled == nil || *(props.Platform.Enabled)
+}
+
+func (auth *AcaAppAuth) DependsOn() []*ResourceReference {
+	return []*ResourceReference{{
+		Subscription:  auth.Subscription,
+		ResourceGroup: auth.ResourceGroup,
+		Type:          "Microsoft.App/containerApps",
+		APIVersion:    GetResourceDef("Microsoft.App/containerApps").Defaults["APIVERSION"],
+		Name:          auth.AppName(),
+	}}
+}
+
+// Adds the auth props to "form". Used by the auth's own form and by the
+// app's, since that's where people look for it.
+func (auth *AcaAppAuth) AddSettingsToForm(form *Form) {
+	if p := auth.Properties.Platform; p != nil && p.Enabled != nil {
+		form.AddProp("Enabled", fmt.Sprintf("%v", *(p.Enabled)))
+	}
+	if gv := auth.Properties.GlobalValidation; gv != nil {
+		if gv.UnauthenticatedClientAction != nil {
+			form.AddProp("Unauthenticated", *(gv.UnauthenticatedClientAction))
+		}
+		if gv.RedirectToProvider != nil {
+			form.AddProp("Redirect To", *(gv.RedirectToProvider))
+		}
+		if len(gv.ExcludedPaths) > 0 {
+			form.AddProp("Excluded Paths", strings.Join(gv.ExcludedPaths, ","))
+		}
+	}
+	if entra := auth.Entra(); entra != nil {
+		form.AddProp("Provider", "entra")
+		if reg := entra.Registration; reg != nil {
+			if reg.ClientId != nil {
+				form.AddProp("Client ID", *(reg.ClientId))
+			}
+			if reg.ClientSecretSettingName != nil {
+				form.AddProp("Client Secret", "secretref:"+
+					*(reg.ClientSecretSettingName))
+			}
+			if reg.OpenIdIssuer != nil {
+				form.AddProp("Issuer", *(reg.OpenIdIssuer))
+			}
+		}
+		if v := entra.Validation; v != nil && len(v.AllowedAudiences) > 0 {
+			form.AddProp("Audiences", strings.Join(v.AllowedAudiences, ","))
+		}
+	}
+}
+
+func (auth *AcaAppAuth) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*ACA-App-Auth(" + auth.Name + ")"
+	form.AddProp("App", auth.AppName())
+	form.AddProp("Subscription", auth.Subscription)
+	form.AddProp("ResourceGroup", auth.ResourceGroup)
+	if auth.Properties != nil {
+		auth.AddSettingsToForm(form)
+	}
+
+	return form
+}
+
+func (auth *AcaAppAuth) FromForm(r *ResourceBase, f *Form) {
+	newAuth := &AcaAppAuth{
+		ResourceBase: auth.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "App":
+			// Skip
+		case "Subscription":
+			newAuth.Subscription = item.Value
+		case "ResourceGroup":
+			newAuth.ResourceGroup = item.Value
+		case "Enabled":
+			newAuth.MustProperties().Platform =
+				&AcaAppAuthPlatform{Enabled: BoolPtr(item.Value == "true")}
+		case "Unauthenticated":
+			newAuth.MustGlobalValidation().UnauthenticatedClientAction =
+				StringPtr(item.Value)
+		case "Redirect To":
+			newAuth.MustGlobalValidation().RedirectToProvider =
+				StringPtr(item.Value)
+		case "Excluded Paths":
+			newAuth.MustGlobalValidation().ExcludedPaths =
+				strings.Split(item.Value, ",")
+		case "Provider":
+			if item.Value != "entra" {
+				panic("Unknown auth provider: " + item.Value)
+			}
+			newAuth.MustEntra()
+		case "Client ID":
+			newAuth.MustEntraRegistration().ClientId = StringPtr(item.Value)
+		case "Client Secret":
+			name, _ := strings.CutPrefix(item.Value, "secretref:")
+			newAuth.MustEntraRegistration().ClientSecretSettingName =
+				StringPtr(name)
+		case "Issuer":
+			newAuth.MustEntraRegistration().OpenIdIssuer = StringPtr(item.Value)
+		case "Audiences":
+			newAuth.MustEntra().Validation = &AcaAppAuthEntraValidation{
+				AllowedAudiences: strings.Split(item.Value, ","),
+			}
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newAuth, "", "  ")
+
+	r.Object = newAuth
+	r.RawData = data
+}
+
+func (auth *AcaAppAuth) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(auth, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (auth *AcaAppAuth) ToJson() string {
+	data, _ := json.MarshalIndent(auth, "", "  ")
+	return string(data)
+}
+
+// Azure fills in the rest of the providers, login and http settings with
+// its defaults, but since they aren't in the struct they're already gone
+func (auth *AcaAppAuth) HideServerFields() {
+	if auth.Properties != nil && auth.Properties.Platform != nil {
+		auth.Properties.Platform.RuntimeVersion = nil
+	}
+}
+
+// Returns the app's auth config from the stage, nil if it doesn't have one
+func FindAcaAppAuth(stage string, app string) *AcaAppAuth {
+	res, err := ResourceFromFile(stage,
+		ResourceFileName("aca-app-auth", app+"/current"))
+	if err != nil {
+		return nil
+	}
+	return res.Object.(*AcaAppAuth)
+}
+
+// Returns Azure's copy of the app's auth config, nil if it has none
+func DownloadAcaAppAuth(app *AcaApp) *AcaAppAuth {
+	authType := "Microsoft.App/containerApps/authConfigs"
+	data, err := downloadResource(app.Subscription, app.ResourceGroup,
+		authType, app.Name+"/current",
+		GetResourceDef(authType).Defaults["APIVERSION"])
+	NoErr(err, "Error downloading the auth config of %q: %s", app.Name, err)
+	if data == nil {
+		return nil
+	}
+
+	res, err := ResourceFromBytes(app.Stage, app.Name+"/current", data)
+	NoErr(err, "Error parsing the auth config of %q: %s", app.Name, err)
+	auth := res.Object.(*AcaAppAuth)
+	auth.HideServerFields()
+	return auth
+}
+
+// Processes the "--auth-*" flags, which manage the app's auth config rather
+// than the app itself
+func (app *AcaApp) ProcessAuthFlags(cmd *cobra.Command) {
+	changed := false
+	for _, flag := range []string{"auth-provider", "client-id",
+		"client-secret", "auth-issuer", "require-auth"} {
+		changed = changed || cmd.Flags().Changed(flag)
+	}
+	if !changed {
+		return
+	}
+
+	stage := GetConfigProperty("currentStage")
+	auth := FindAcaAppAuth(stage, app.Name)
+	isNew := (auth == nil)
+	if isNew {
+		if !cmd.Flags().Changed("auth-provider") {
+			ErrStop("App %q has no authentication, use '--auth-provider'",
+				app.Name)
+		}
+		auth = &AcaAppAuth{}
+		auth.InitResource(auth, "Microsoft.App/containerApps/authConfigs",
+			"aca-app-auth", app.Name+"/current")
+	}
+	auth.Subscription = app.Subscription
+	auth.ResourceGroup = app.ResourceGroup
+
+	if cmd.Flags().Changed("auth-provider") {
+		switch provider := FlagAsString(cmd, "auth-provider"); provider {
+		case "entra":
+			auth.MustProperties().Platform = nil
+			auth.MustEntra()
+		case "none":
+			if isNew {
+				ErrStop("App %q has no authentication to disable", app.Name)
+			}
+			// Keep it so "up" turns it off in Azure too
+			auth.MustProperties().Platform =
+				&AcaAppAuthPlatform{Enabled: BoolPtr(false)}
+		default:
+			ErrStop("Unknown auth provider %q, must be 'entra' or 'none'",
+				provider)
+		}
+	}
+
+	if cmd.Flags().Changed("client-id") {
+		auth.MustEntraRegistration().ClientId =
+			NilStringPtr(FlagAsString(cmd, "client-id"))
+	}
+
+	if cmd.Flags().Changed("client-secret") {
+		secret := FlagAsString(cmd, "client-secret")
+		if secret != "" && app.MustProperties().FindSecret(secret) == nil {
+			ErrStop("App %q has no secret named %q", app.Name, secret)
+		}
+		auth.MustEntraRegistration().ClientSecretSettingName =
+			NilStringPtr(secret)
+	}
+
+	if cmd.Flags().Changed("auth-issuer") {
+		auth.MustEntraRegistration().OpenIdIssuer =
+			NilStringPtr(FlagAsString(cmd, "auth-issuer"))
+	}
+
+	if cmd.Flags().Changed("require-auth") {
+		action := "AllowAnonymous"
+		if req, _ := cmd.Flags().GetBool("require-auth"); req {
+			action = "RedirectToLoginPage"
+		}
+		auth.MustGlobalValidation().UnauthenticatedClientAction =
+			StringPtr(action)
+		auth.MustGlobalValidation().RedirectToProvider = nil
+	}
+
+	if entra := auth.Entra(); entra != nil && auth.IsEnabled() &&
+		(entra.Registration == nil || entra.Registration.ClientId == nil) {
+		ErrStop("Entra authentication needs a '--client-id'")
+	}
+
+	auth.Save()
+	if isNew {
+		fmt.Printf("Added aca-app-auth/%s\n", auth.Name)
+	}
+}
+
+// Processes "--workload-profile", the profile must be in the app's, or
+// job's, environment. "" goes back to the default (Consumption).
+func ProcessWorkloadProfileFlag(cmd *cobra.Command, env *string, profile **string) {
+	if !cmd.Flags().Changed("workload-profile") {
+		return
+	}
+	name := FlagAsString(cmd, "workload-profile")
+	if name != "" {
+		CheckWorkloadProfile(ResolveAcaEnvironment(env), name)
+	}
+	*profile = NilStringPtr(name)
+}
+
+// Processes "--revision-mode" and "--revision-suffix". Single is Azure's
+// default mode so it's not saved, same for an empty suffix.
+func (app *AcaApp) ProcessRevisionFlags(cmd *cobra.Command) {
+	if cmd.Flags().Changed("revision-mode") {
+		mode := strings.ToLower(FlagAsString(cmd, "revision-mode"))
+		switch mode {
+		case "single":
+			app.MustConfiguration().ActiveRevisionsMode = nil
+		case "multiple":
+			app.MustConfiguration().ActiveRevisionsMode = StringPtr("Multiple")
+		default:
+			ErrStop("Revision mode %q must be 'single' or 'multiple'", mode)
+		}
+	}
+
+	if cmd.Flags().Changed("revision-suffix") {
+		suffix := FlagAsString(cmd, "revision-suffix")
+		if suffix != "" && !revisionSuffixRE.MatchString(suffix) {
+			ErrStop("Revision suffix %q must be lower case letters, numbers "+
+				"and '-'s", suffix)
+		}
+		app.MustTemplate().RevisionSuffix = NilStringPtr(suffix)
+	}
+
+	if config := app.MustProperties().Configuration; config != nil &&
+		config.Ingress != nil && config.ActiveRevisionsMode == nil {
+		for _, t := range config.Ingress.Traffic {
+			if t.Revision() != "latest" || t.Label != nil {
+				ErrStop("App %q splits traffic between revisions, it needs "+
+					"'--revision-mode multiple'", app.Name)
+			}
+		}
+	}
+}
+
+var revisionSuffixRE = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// Creates a traffic entry from its form/flag strings
+func NewTraffic(revision string, weight string, label string) *AcaAppTraffic {
+	t := &AcaAppTraffic{Label: NilStringPtr(label)}
+	if revision == "latest" {
+		t.LatestRevision = BoolPtr(true)
+	} else {
+		t.RevisionName = StringPtr(revision)
+	}
+	if weight != "" {
+		w, err := strconv.Atoi(weight)
+		if err != nil || w < 0 || w > 100 {
+			ErrStop("Weight %q of revision %q must be 0 to 100", weight,
+				revision)
+		}
+		t.Weight = &w
+	}
+	return t
+}
+
+func (ing *AcaAppIngress) FindTraffic(revision string) *AcaAppTraffic {
+	for _, t := range ing.Traffic {
+		if t.Revision() == revision {
+			return t
+		}
+	}
+	return nil
+}
+
+// azx aca-app traffic -n APP [--set REV=WEIGHT,...] [--label LABEL=REV]...
+//
+// "--set" replaces all of the weights, while labels stay with their
+// revisions. Revisions without a weight or a label are dropped.
+func TrafficAcaAppFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: TrafficAcaAppFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: TrafficAcaAppFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	app := LoadStageResource("aca-app", name).Object.(*AcaApp)
+	ing := app.NeedIngress("traffic")
+
+	if cmd.Flags().Changed("set") {
+		for _, t := range ing.Traffic {
+			t.Weight = nil
+		}
+		total := 0
+		seen := map[string]bool{}
+		for _, part := range strings.Split(FlagAsString(cmd, "set"), ",") {
+			rev, weight, found := strings.Cut(part, "=")
+			if !found || rev == "" || weight == "" {
+				ErrStop("Traffic %q must be of the form: REVISION=WEIGHT", part)
+			}
+			if seen[rev] {
+				ErrStop("Revision %q is in '--set' more than once", rev)
+			}
+			seen[rev] = true
+			newT := NewTraffic(rev, weight, "")
+			if t := ing.FindTraffic(rev); t != nil {
+				t.Weight = newT.Weight
+			} else {
+				ing.Traffic = append(ing.Traffic, newT)
+			}
+			total += *(newT.Weight)
+		}
+		if total != 100 {
+			ErrStop("Traffic weights add up to %d, not 100", total)
+		}
+	}
+
+	labels, _ := cmd.Flags().GetStringArray("label")
+	for _, label := range labels {
+		label, rev, _ := strings.Cut(label, "=")
+		if label == "" {
+			ErrStop("Label must be of the form: LABEL=REVISION")
+		}
+		for _, t := range ing.Traffic {
+			if NotNil(t.Label) == label {
+				t.Label = nil
+			}
+		}
+		if rev == "" {
+			continue
+		}
+		t := ing.FindTraffic(rev)
+		if t == nil {
+			t = NewTraffic(rev, "", "")
+			ing.Traffic = append(ing.Traffic, t)
+		}
+		t.Label = StringPtr(label)
+	}
+
+	traffic := []*AcaAppTraffic{}
+	for _, t := range ing.Traffic {
+		if t.Weight != nil || t.Label != nil {
+			traffic = append(traffic, t)
+		}
+	}
+	ing.Traffic = traffic
+	// All traffic to the latest revision is the default, no need to save it
+	if len(traffic) == 1 && traffic[0].Revision() == "latest" &&
+		traffic[0].Label == nil && *(traffic[0].Weight) == 100 {
+		ing.Traffic = nil
+	}
+
+	app.ProcessRevisionFlags(cmd)
+	app.SaveAndUp(cmd)
+}
+
+// Adds the IP rules, sticky sessions, client cert and CORS settings to the
+// "Ingress" section
+func (ing *AcaAppIngress) AddSecurityToForm(nf *Form) {
+	if ing.Transport != nil {
+		nf.AddProp("Transport", *(ing.Transport))
+	}
+	if ing.ExposedPort != nil {
+		nf.AddProp("Exposed Port", fmt.Sprintf("%d", *(ing.ExposedPort)))
+	}
+	if ing.ClientCertificateMode != nil {
+		nf.AddProp("Client Cert", *(ing.ClientCertificateMode))
+	}
+	if ing.StickySessions != nil && ing.StickySessions.Affinity != nil {
+		nf.AddProp("Sessions", *(ing.StickySessions.Affinity))
+	}
+	if cors := ing.CorsPolicy; cors != nil {
+		if len(cors.AllowedOrigins) > 0 {
+			nf.AddProp("CORS Origins", strings.Join(cors.AllowedOrigins, ", "))
+		}
+		if len(cors.AllowedMethods) > 0 {
+			nf.AddProp("CORS Methods", strings.Join(cors.AllowedMethods, ", "))
+		}
+	}
+	if len(ing.AdditionalPortMappings) > 0 {
+		pf := nf.AddArray("Additional Ports", "")
+		for _, pm := range ing.AdditionalPortMappings {
+			pf.AddProp(IntPtrString(pm.TargetPort), pm.String())
+		}
+	}
+	if len(ing.IPSecurityRestrictions) > 0 {
+		ipf := nf.AddArray("IP Rules", "")
+		for _, rule := range ing.IPSecurityRestrictions {
+			ipf.AddProp(NotNil(rule.Name), strings.ToLower(NotNil(rule.Action))+
+				" "+NotNil(rule.IPAddressRange))
+		}
+	}
+}
+
+// The reverse of AddSecurityToForm
+func (ing *AcaAppIngress) SecurityFromForm(item *Form) {
+	ing.Transport = NilStringPtr(item.GetProp("Transport"))
+	if val := item.GetProp("Exposed Port"); val != "" {
+		i, _ := strconv.Atoi(val)
+		ing.ExposedPort = &i
+	}
+	ing.ClientCertificateMode = NilStringPtr(item.GetProp("Client Cert"))
+	if val := item.GetProp("Sessions"); val != "" {
+		ing.StickySessions = &AcaAppStickySessions{Affinity: StringPtr(val)}
+	}
+	splitList := func(val string) []string {
+		list := []string{}
+		for _, str := range strings.Split(val, ",") {
+			if str = strings.TrimSpace(str); str != "" {
+				list = append(list, str)
+			}
+		}
+		return list
+	}
+	if val := item.GetProp("CORS Origins"); val != "" {
+		ing.MustCorsPolicy().AllowedOrigins = splitList(val)
+	}
+	if val := item.GetProp("CORS Methods"); val != "" {
+		ing.MustCorsPolicy().AllowedMethods = splitList(val)
+	}
+	for _, sub := range item.Items {
+		if sub.Title == "Additional Ports" {
+			for _, port := range sub.Items {
+				ing.AdditionalPortMappings = append(ing.AdditionalPortMappings,
+					ParsePortMapping(port.Title+":"+port.Value))
+			}
+			continue
+		}
+		if sub.Title != "IP Rules" {
+			continue
+		}
+		for _, rule := range sub.Items {
+			action, cidr, _ := strings.Cut(rule.Value, " ")
+			switch strings.ToLower(action) {
+			case "allow":
+				action = "Allow"
+			case "deny":
+				action = "Deny"
+			default:
+				ErrStop("IP rule %q must be of the form: Allow|Deny CIDR",
+					rule.Title)
+			}
+			if cidr = strings.TrimSpace(cidr); cidr == "" {
+				ErrStop("IP rule %q is missing its CIDR", rule.Title)
+			}
+			ing.IPSecurityRestrictions = append(ing.IPSecurityRestrictions,
+				&AcaAppIPRestriction{
+					Name:           StringPtr(rule.Title),
+					IPAddressRange: StringPtr(cidr),
+					Action:         StringPtr(action),
+				})
+		}
+	}
+}
+
+func (ing *AcaAppIngress) MustCorsPolicy() *AcaAppCorsPolicy {
+	if ing.CorsPolicy == nil {
+		ing.CorsPolicy = &AcaAppCorsPolicy{}
+	}
+	return ing.CorsPolicy
+}
+
+// Adds, or replaces, an IP rule. "spec" is [NAME=]CIDR and the name
+// defaults to the CIDR with its '.'s and '/' turned into '-'s.
+func (ing *AcaAppIngress) SetIPRule(action string, spec string) {
+	name, cidr, found := strings.Cut(spec, "=")
+	if !found {
+		cidr = name
+		name = strings.NewReplacer(".", "-", "/", "-", ":", "-").Replace(cidr)
+	}
+	if _, _, err := net.ParseCIDR(cidr); err != nil {
+		if net.ParseIP(cidr) == nil {
+			ErrStop("%q isn't a valid IP address or CIDR range", cidr)
+		}
+	}
+	for _, rule := range ing.IPSecurityRestrictions {
+		if !strings.EqualFold(NotNil(rule.Action), action) {
+			ErrStop("Can't mix allow and deny IP rules, rule %q is %q",
+				NotNil(rule.Name), NotNil(rule.Action))
+		}
+	}
+	ing.RemoveIPRule(name)
+	ing.IPSecurityRestrictions = append(ing.IPSecurityRestrictions,
+		&AcaAppIPRestriction{
+			Name:           StringPtr(name),
+			IPAddressRange: StringPtr(cidr),
+			Action:         StringPtr(action),
+		})
+}
+
+// Removes the IP rule with the given name, or CIDR range. Returns false if
+// there wasn't one.
+func (ing *AcaAppIngress) RemoveIPRule(nameOrCIDR string) bool {
+	for i, rule := range ing.IPSecurityRestrictions {
+		if NotNil(rule.Name) == nameOrCIDR ||
+			NotNil(rule.IPAddressRange) == nameOrCIDR {
+			ing.IPSecurityRestrictions = append(ing.IPSecurityRestrictions[:i],
+				ing.IPSecurityRestrictions[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Processes "--allow-ip", "--deny-ip", "--remove-ip", "--sticky-sessions",
+// "--cors-origin", "--cors-method" and "--client-cert"
+func (app *AcaApp) ProcessIngressSecurityFlags(cmd *cobra.Command) {
+	flags := []string{"allow-ip", "deny-ip", "remove-ip", "sticky-sessions",
+		"cors-origin", "cors-method", "client-cert"}
+	changed := false
+	for _, flag := range flags {
+		changed = changed || cmd.Flags().Changed(flag)
+	}
+	if !changed {
+		return
+	}
+
+	ing := app.NeedIngress("security settings")
+
+	ips, _ := cmd.Flags().GetStringArray("remove-ip")
+	for _, ip := range ips {
+		if !ing.RemoveIPRule(ip) {
+			ErrStop("App %q has no IP rule %q", app.Name, ip)
+		}
+	}
+	ips, _ = cmd.Flags().GetStringArray("allow-ip")
+	for _, ip := range ips {
+		ing.SetIPRule("Allow", ip)
+	}
+	ips, _ = cmd.Flags().GetStringArray("deny-ip")
+	for _, ip := range ips {
+		ing.SetIPRule("Deny", ip)
+	}
+
+	if cmd.Flags().Changed("sticky-sessions") {
+		ing.StickySessions = nil
+		if sticky, _ := cmd.Flags().GetBool("sticky-sessions"); sticky {
+			ing.StickySessions = &AcaAppStickySessions{
+				Affinity: StringPtr("sticky"),
+			}
+		}
+	}
+
+	if cmd.Flags().Changed("client-cert") {
+		switch mode := strings.ToLower(FlagAsString(cmd, "client-cert")); mode {
+		case "ignore":
+			ing.ClientCertificateMode = nil
+		case "require", "accept":
+			ing.ClientCertificateMode = StringPtr(mode)
+		default:
+			ErrStop("Client cert mode %q must be 'require', 'accept' or "+
+				"'ignore'", mode)
+		}
+	}
+
+	for _, flag := range []string{"cors-origin", "cors-method"} {
+		if !cmd.Flags().Changed(flag) {
+			continue
+		}
+		list := &(ing.MustCorsPolicy().AllowedOrigins)
+		if flag == "cors-method" {
+			list = &(ing.CorsPolicy.AllowedMethods)
+		}
+		vals, _ := cmd.Flags().GetStringArray(flag)
+		if len(vals) == 0 { // pflag turns a lone '' into an empty list
+			*list = nil
+		}
+		for _, val := range vals {
+			if val == "" {
+				*list = nil
+				continue
+			}
+			if flag == "cors-method" {
+				val = strings.ToUpper(val)
+			}
+			found := false
+			for _, v := range *list {
+				found = found || v == val
+			}
+			if !found {
+				*list = append(*list, val)
+			}
+		}
+	}
+	if cors := ing.CorsPolicy; cors != nil &&
+		reflect.DeepEqual(*cors, AcaAppCorsPolicy{}) {
+		ing.CorsPolicy = nil
+	}
+}
+
+func ParsePort(what string, str string) int {
+	port, err := strconv.Atoi(str)
+	if err != nil || port < 1 || port > 65535 {
+		ErrStop("%s %q must be a port number (1-65535)", what, str)
+	}
+	return port
+}
+
+// Parses an additional port mapping: TARGET[:EXPOSED][:external]
+func ParsePortMapping(spec string) *AcaAppPortMapping {
+	pm := &AcaAppPortMapping{External: BoolPtr(false)}
+	parts := strings.Split(spec, ":")
+	if last := parts[len(parts)-1]; len(parts) > 1 &&
+		(last == "external" || last == "internal") {
+		pm.External = BoolPtr(last == "external")
+		parts = parts[:len(parts)-1]
+	}
+	if len(parts) > 2 || parts[0] == "" {
+		ErrStop("Additional port %q must be of the form: "+
+			"TARGET[:EXPOSED][:external]", spec)
+	}
+	pm.TargetPort = IntPtr(ParsePort("Target port", parts[0]))
+	if len(parts) == 2 {
+		pm.ExposedPort = IntPtr(ParsePort("Exposed port", parts[1]))
+	}
+	return pm
+}
+
+// Returns the mapping in ParsePortMapping's syntax, minus the target port
+func (pm *AcaAppPortMapping) String() string {
+	str := ""
+	if pm.ExposedPort != nil {
+		str = fmt.Sprintf("%d:", *(pm.ExposedPort))
+	}
+	if pm.External != nil && *(pm.External) {
+		return str + "external"
+	}
+	return str + "internal"
+}
+
+// Processes "--transport", "--exposed-port", "--additional-port" and
+// "--remove-additional-port"
+func (app *AcaApp) ProcessTransportFlags(cmd *cobra.Command) {
+	changed := false
+	for _, flag := range []string{"transport", "exposed-port",
+		"additional-port", "remove-additional-port"} {
+		changed = changed || cmd.Flags().Changed(flag)
+	}
+	if !changed {
+		return
+	}
+
+	ing := app.NeedIngress("transport and ports")
+
+	if cmd.Flags().Changed("transport") {
+		switch t := strings.ToLower(FlagAsString(cmd, "transport")); t {
+		case "auto":
+			ing.Transport = nil
+		case "http", "http2", "tcp":
+			ing.Transport = StringPtr(t)
+		default:
+			ErrStop("Transport %q must be one of: auto, http, http2, tcp", t)
+		}
+	}
+
+	if cmd.Flags().Changed("exposed-port") {
+		ing.ExposedPort = nil
+		if val := FlagAsString(cmd, "exposed-port"); val != "" {
+			ing.ExposedPort = IntPtr(ParsePort("--exposed-port", val))
+		}
+	}
+
+	ports, _ := cmd.Flags().GetStringArray("remove-additional-port")
+	for _, port := range ports {
+		pos := -1
+		for i, pm := range ing.AdditionalPortMappings {
+			if IntPtrString(pm.TargetPort) == port {
+				pos = i
+			}
+		}
+		if pos < 0 {
+			ErrStop("App %q has no additional port %q", app.Name, port)
+		}
+		ing.AdditionalPortMappings = append(ing.AdditionalPortMappings[:pos],
+			ing.AdditionalPortMappings[pos+1:]...)
+	}
+
+	ports, _ = cmd.Flags().GetStringArray("additional-port")
+	for _, port := range ports {
+		pm := ParsePortMapping(port)
+		for i, old := range ing.AdditionalPortMappings {
+			if IntPtrString(old.TargetPort) == IntPtrString(pm.TargetPort) {
+				ing.AdditionalPortMappings = append(
+					ing.AdditionalPortMappings[:i],
+					ing.AdditionalPortMappings[i+1:]...)
+				break
+			}
+		}
+		ing.AdditionalPortMappings = append(ing.AdditionalPortMappings, pm)
+	}
+
+	isTCP := NotNil(ing.Transport) == "tcp"
+	if isTCP && ing.TargetPort == nil {
+		ErrStop("TCP ingress needs a '--port', there's no default for it")
+	}
+	if !isTCP && ing.ExposedPort != nil {
+		ErrStop("'--exposed-port' is only valid with '--transport tcp'")
+	}
+}
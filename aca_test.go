@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Returns "v" as json, for comparing parsed values with what they should be
+func toJson(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal: %s", err)
+	}
+	return string(data)
+}
+
+func TestParseScaleRule(t *testing.T) {
+	app := &AcaApp{}
+	SetSecret(app.MustSecrets(), &AcaAppSecret{Name: StringPtr("conn")})
+
+	tests := []struct{ spec, rule string }{
+		{"name=h",
+			`{"name":"h","http":{}}`},
+		{"name=h,concurrency=10",
+			`{"name":"h","http":{"metadata":{"concurrentRequests":"10"}}}`},
+		{"name=t,type=tcp,concurrency=5",
+			`{"name":"t","tcp":{"metadata":{"concurrentConnections":"5"}}}`},
+		{`name=c,type=cron,timezone=UTC,start="0 8 * * 1,2",end=0 9 * * *,replicas=2`,
+			`{"name":"c","custom":{"type":"cron","metadata":{"desiredReplicas":"2","end":"0 9 * * *","start":"0 8 * * 1,2","timezone":"UTC"}}}`},
+		{"name=q,type=azure-servicebus,queueName=q1,auth=connection:conn",
+			`{"name":"q","custom":{"type":"azure-servicebus","metadata":{"queueName":"q1"},"auth":[{"secretRef":"conn","triggerParameter":"connection"}]}}`},
+		{"name=k,type=kafka,identity=system,topic=t",
+			`{"name":"k","custom":{"type":"kafka","metadata":{"topic":"t"},"identity":"system"}}`},
+		// Aliases are only for their own type
+		{"name=k,type=kafka,concurrency=1",
+			`{"name":"k","custom":{"type":"kafka","metadata":{"concurrency":"1"}}}`},
+	}
+
+	for _, test := range tests {
+		if rule := toJson(t, ParseScaleRule(app, test.spec)); rule != test.rule {
+			t.Errorf("ParseScaleRule(%q):\n got: %s\nwant: %s", test.spec,
+				rule, test.rule)
+		}
+	}
+
+	bad := map[string]string{
+		"no-name":       "type=http",
+		"no-value":      "name=h,concurrency",
+		"empty-value":   "name=h,concurrency=",
+		"unquoted":      "name=c,type=cron,start=0 8 * * 1,2",
+		"bad-auth":      "name=q,type=kafka,auth=conn",
+		"unknown-auth":  "name=q,type=kafka,auth=connection:nope",
+		"http-identity": "name=h,identity=system",
+		"cron-missing":  "name=c,type=cron,timezone=UTC",
+	}
+	for id, spec := range bad {
+		expectErrStop(t, id, func() { ParseScaleRule(app, spec) })
+	}
+}
+
+func TestParseProbe(t *testing.T) {
+	tests := []struct{ probeType, spec, probe string }{
+		{"liveness", "http:/healthz:8080",
+			`{"type":"Liveness","httpGet":{"path":"/healthz","port":8080}}`},
+		{"Readiness", "https:/a:b:443,host=example.com",
+			`{"type":"Readiness","httpGet":{"path":"/a:b","port":443,"scheme":"HTTPS","host":"example.com"}}`},
+		{"STARTUP", "tcp:5432,delay=5,period=10,timeout=1,success=1,failure=3",
+			`{"type":"Startup","tcpSocket":{"port":5432},"initialDelaySeconds":5,"periodSeconds":10,"timeoutSeconds":1,"successThreshold":1,"failureThreshold":3}`},
+		{"liveness", "tcp:80,host=db,delay=0",
+			`{"type":"Liveness","tcpSocket":{"port":80,"host":"db"},"initialDelaySeconds":0}`},
+	}
+
+	for _, test := range tests {
+		probe := ParseProbe(test.probeType, test.spec)
+		if got := toJson(t, probe); got != test.probe {
+			t.Errorf("ParseProbe(%q, %q):\n got: %s\nwant: %s",
+				test.probeType, test.spec, got, test.probe)
+		}
+		// Forms show probes with String so it has to parse back the same
+		again := ParseProbe(test.probeType, probe.String())
+		if toJson(t, again) != test.probe {
+			t.Errorf("ParseProbe(%q) didn't round trip: %s", test.spec,
+				probe.String())
+		}
+	}
+
+	bad := map[string][]string{
+		"type":         {"ready", "tcp:80"},
+		"kind":         {"liveness", "grpc:80"},
+		"no-path":      {"liveness", "http:8080"},
+		"rel-path":     {"liveness", "http:healthz:8080"},
+		"port":         {"liveness", "tcp:http"},
+		"port-range":   {"liveness", "tcp:70000"},
+		"option":       {"liveness", "tcp:80,retries=3"},
+		"option-value": {"liveness", "tcp:80,delay=-1"},
+		"option-form":  {"liveness", "tcp:80,delay"},
+	}
+	for id, args := range bad {
+		expectErrStop(t, id, func() { ParseProbe(args[0], args[1]) })
+	}
+}
+
+func floatPtr(f float64) *float64 { return &f }
+
+func TestEffectiveResources(t *testing.T) {
+	tests := []struct {
+		cpu     *float64
+		memory  *string
+		cpu2    float64
+		memory2 float64
+	}{
+		{nil, nil, 0.5, 1},
+		{floatPtr(1), nil, 1, 2},
+		{nil, StringPtr("3Gi"), 1.5, 3},
+		{nil, StringPtr("0.5"), 0.25, 0.5},
+		{floatPtr(0.75), StringPtr("1.5Gi"), 0.75, 1.5},
+		{floatPtr(1), StringPtr("1Gi"), 1, 1},
+	}
+
+	for _, test := range tests {
+		c := &AcaAppContainer{}
+		if test.cpu != nil || test.memory != nil {
+			c.Resources = &AcaAppResources{CPU: test.cpu, Memory: test.memory}
+		}
+		if cpu, mem := c.EffectiveResources(); cpu != test.cpu2 ||
+			mem != test.memory2 {
+			t.Errorf("EffectiveResources(%s) = %v, %v, should be %v, %v",
+				toJson(t, c.Resources), cpu, mem, test.cpu2, test.memory2)
+		}
+	}
+}
+
+func TestCheckResources(t *testing.T) {
+	container := func(cpu float64, mem string) *AcaAppContainer {
+		c := &AcaAppContainer{Resources: &AcaAppResources{}}
+		if cpu != 0 {
+			c.Resources.CPU = floatPtr(cpu)
+		}
+		if mem != "" {
+			c.Resources.Memory = StringPtr(mem)
+		}
+		return c
+	}
+
+	tests := []struct {
+		id         string
+		profile    *string
+		containers []*AcaAppContainer
+		ok         bool
+	}{
+		{"none", nil, nil, true},
+		{"default", nil, []*AcaAppContainer{{}}, true},
+		{"quarter", nil, []*AcaAppContainer{container(0.25, "")}, true},
+		{"max", nil, []*AcaAppContainer{container(4, "8Gi")}, true},
+		{"sum", StringPtr("Consumption"),
+			[]*AcaAppContainer{container(0.5, ""), container(0, "1.5Gi")}, true},
+		{"other-profile", StringPtr("D4"),
+			[]*AcaAppContainer{container(3, "16Gi")}, true},
+		{"step", nil, []*AcaAppContainer{container(0.3, "")}, false},
+		{"too-big", nil, []*AcaAppContainer{container(4.25, "")}, false},
+		{"ratio", nil, []*AcaAppContainer{container(1, "1Gi")}, false},
+		{"sum-too-big", StringPtr("consumption"),
+			[]*AcaAppContainer{container(3, ""), container(1.25, "")}, false},
+	}
+
+	for _, test := range tests {
+		if test.ok {
+			// ErrStop would exit, failing the test
+			CheckResources(test.id, test.profile, test.containers)
+		} else {
+			expectErrStop(t, test.id, func() {
+				CheckResources(test.id, test.profile, test.containers)
+			})
+		}
+	}
+
+	expectErrStop(t, "memory", func() {
+		CheckResources("memory", nil,
+			[]*AcaAppContainer{container(0, "1GB")})
+	})
+}
+
+func TestParsePortMapping(t *testing.T) {
+	tests := []struct{ spec, pm, str string }{
+		{"8080", `{"external":false,"targetPort":8080}`, "internal"},
+		{"8080:internal", `{"external":false,"targetPort":8080}`, "internal"},
+		{"8080:external", `{"external":true,"targetPort":8080}`, "external"},
+		{"8080:80", `{"external":false,"targetPort":8080,"exposedPort":80}`,
+			"80:internal"},
+		{"8080:80:external",
+			`{"external":true,"targetPort":8080,"exposedPort":80}`,
+			"80:external"},
+	}
+
+	for _, test := range tests {
+		pm := ParsePortMapping(test.spec)
+		if got := toJson(t, pm); got != test.pm {
+			t.Errorf("ParsePortMapping(%q):\n got: %s\nwant: %s", test.spec,
+				got, test.pm)
+		}
+		if str := pm.String(); str != test.str {
+			t.Errorf("ParsePortMapping(%q).String() = %q, should be %q",
+				test.spec, str, test.str)
+		}
+	}
+
+	bad := map[string]string{
+		"empty":       "",
+		"no-target":   ":80",
+		"only-flag":   "external",
+		"bad-target":  "http",
+		"bad-exposed": "8080:http",
+		"range":       "0",
+		"range-high":  "8080:65536",
+		"too-many":    "8080:80:90",
+		"bad-flag":    "8080:80:public",
+	}
+	for id, spec := range bad {
+		expectErrStop(t, id, func() { ParsePortMapping(spec) })
+	}
+}
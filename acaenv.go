@@ -0,0 +1,1449 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/duglin/dlog"
+	"github.com/spf13/cobra"
+)
+
+func initAcaEnv() {
+	log.VPrintf(3, "Init initAcaEnv")
+	setupAcaEnvCmds()
+	setupAcaEnvResourceDefs()
+	RegisteredParsers = append(RegisteredParsers, AcaEnvFromARMJson)
+	ParamFields["Microsoft.App/managedEnvironments/daprComponents"] = []string{
+		"properties.secrets[].value"}
+}
+
+func setupAcaEnvCmds() {
+	cmd := &cobra.Command{
+		Use:   "aca-env",
+		Short: "Add an Azure Container App Environment",
+		Run:   AddAcaEnvFunc,
+	}
+	AddResourceFlags(cmd, "environment")
+	cmd.Flags().String("logs-workspace", "", "Log Analytics workspace to send logs to")
+	cmd.Flags().StringArray("workload-profile", nil, "NAME=TYPE[,min=N][,max=N] dedicated workload profile, TYPE is e.g. D4 or E8")
+	cmd.Flags().StringArray("remove-workload-profile", nil, "Workload profile to remove")
+	AddCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:   "aca-env",
+		Short: "Update an Azure Container App Environment",
+		Run:   UpdateAcaEnvFunc,
+	}
+	AddResourceFlags(cmd, "environment")
+	cmd.Flags().String("logs-workspace", "", "Log Analytics workspace to send logs to")
+	cmd.Flags().StringArray("workload-profile", nil, "NAME=TYPE[,min=N][,max=N] dedicated workload profile, TYPE is e.g. D4 or E8")
+	cmd.Flags().StringArray("remove-workload-profile", nil, "Workload profile to remove")
+	UpdateCmd.AddCommand(cmd)
+
+	AddShowCmd("aca-env", "Show details about an Azure Container App Environment",
+		"environment")
+
+	// ---
+
+	cmd = &cobra.Command{
+		Use:   "aca-env-storage",
+		Short: "Add an Azure Files storage to an ACA environment",
+		Run:   AddAcaEnvStorageFunc,
+	}
+	AddResourceFlags(cmd, "storage ([ENV/]NAME)")
+	cmd.Flags().String("share", "", "Azure Files share (ACCOUNT/SHARE)")
+	cmd.Flags().String("access-mode", "", "'ReadWrite' or 'ReadOnly'")
+	cmd.MarkFlagRequired("share")
+	AddCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:   "aca-env-storage",
+		Short: "Update an Azure Files storage of an ACA environment",
+		Run:   UpdateAcaEnvStorageFunc,
+	}
+	AddResourceFlags(cmd, "storage ([ENV/]NAME)")
+	cmd.Flags().String("share", "", "Azure Files share (ACCOUNT/SHARE)")
+	cmd.Flags().String("access-mode", "", "'ReadWrite' or 'ReadOnly'")
+	UpdateCmd.AddCommand(cmd)
+
+	AddShowCmd("aca-env-storage",
+		"Show details about an Azure Files storage of an ACA environment",
+		"storage (ENV/NAME)")
+
+	// ---
+
+	cmd = &cobra.Command{
+		Use:   "aca-managed-cert",
+		Short: "Add a managed certificate to an ACA environment",
+		Run:   AddAcaManagedCertFunc,
+	}
+	AddResourceFlags(cmd, "certificate ([ENV/]NAME)")
+	cmd.Flags().String("domain", "", "Domain name the certificate is for")
+	cmd.MarkFlagRequired("domain")
+	AddCmd.AddCommand(cmd)
+
+	AddShowCmd("aca-managed-cert",
+		"Show details about a managed certificate of an ACA environment",
+		"certificate (ENV/NAME)")
+
+	// ---
+
+	cmd = &cobra.Command{
+		Use:   "dapr-component",
+		Short: "Add a Dapr component to an ACA environment",
+		Run:   AddDaprComponentFunc,
+	}
+	addDaprComponentFlags(cmd)
+	AddCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:   "dapr-component",
+		Short: "Update a Dapr component of an ACA environment",
+		Run:   UpdateDaprComponentFunc,
+	}
+	addDaprComponentFlags(cmd)
+	cmd.Flags().StringArray("remove-metadata", nil, "Metadata item to remove")
+	cmd.Flags().StringArray("remove-secret", nil, "Secret to remove")
+	cmd.Flags().StringArray("remove-scope", nil, "Dapr app ID to remove from the scopes")
+	UpdateCmd.AddCommand(cmd)
+
+	AddShowCmd("dapr-component",
+		"Show details about a Dapr component of an ACA environment",
+		"component (ENV/NAME)")
+}
+
+func addDaprComponentFlags(cmd *cobra.Command) {
+	AddResourceFlags(cmd, "component ([ENV/]NAME)")
+	cmd.Flags().String("type", "", "Component type, e.g. 'state.redis'")
+	cmd.Flags().String("version", "", "Component version (default: v1)")
+	cmd.Flags().String("resource", "", "Resource to use (servicebus/NAME, storage/NAME, or redis/NAME for an existing Azure Cache for Redis)")
+	cmd.Flags().StringArray("metadata", nil, "NAME=VALUE, or NAME=secretref:SECRET, metadata item")
+	cmd.Flags().StringArray("secret", nil, "NAME[=PARAM] secret whose value comes from the ${PARAM} parameter, case sensitive (default: NAME in upper case)")
+	cmd.Flags().StringArray("scope", nil, "Dapr app ID that can use the component")
+}
+
+func setupAcaEnvResourceDefs() {
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.App/managedEnvironments/storages",
+		Defaults: map[string]string{
+			"APIVERSION": "2023-05-01",
+		},
+	})
+
+	// The certificate isn't usable until DigiCert has issued it
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.App/managedEnvironments/managedCertificates",
+		Defaults: map[string]string{
+			"APIVERSION": "2023-05-01",
+			"WAIT":       "true",
+			"WAITBUSY":   "Pending",
+		},
+	})
+	ResourceAliases["aca-managed-cert"] =
+		"Microsoft.App/managedEnvironments/managedCertificates"
+
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.App/managedEnvironments/daprComponents",
+		Defaults: map[string]string{
+			"APIVERSION": "2023-05-01",
+		},
+	})
+	ResourceAliases["dapr-component"] =
+		"Microsoft.App/managedEnvironments/daprComponents"
+}
+
+type AcaEnvLogAnalyticsConfiguration struct {
+	CustomerId *string `json:"customerId,omitempty"`
+	SharedKey  *string `json:"sharedKey,omitempty"`
+}
+
+type AcaEnvAppLogsConfiguration struct {
+	Destination               *string                          `json:"destination,omitempty"`
+	LogAnalyticsConfiguration *AcaEnvLogAnalyticsConfiguration `json:"logAnalyticsConfiguration,omitempty"`
+}
+
+type AcaEnvProperties struct {
+	AppLogsConfiguration *AcaEnvAppLogsConfiguration `json:"appLogsConfiguration,omitempty"`
+	WorkloadProfiles     []*AcaEnvWorkloadProfile    `json:"workloadProfiles,omitempty"`
+}
+
+// A dedicated (or Consumption) set of machines that apps can run on
+type AcaEnvWorkloadProfile struct {
+	Name                *string `json:"name,omitempty"`
+	WorkloadProfileType *string `json:"workloadProfileType,omitempty"` // D4, E8...
+	MinimumCount        *int    `json:"minimumCount,omitempty"`
+	MaximumCount        *int    `json:"maximumCount,omitempty"`
+}
+
+type AcaEnv struct {
+	ResourceBase
+
+	Location   *string           `json:"location,omitempty"`
+	Properties *AcaEnvProperties `json:"properties,omitempty"`
+}
+
+func (env *AcaEnv) MarshalJSON() ([]byte, error) {
+	tmpEnv := *env
+	if WhyMarshal == "ARM" {
+		if tmpEnv.Location == nil {
+			tmpEnv.Location = StringPtr(GetConfigProperty("defaults.Location"))
+		}
+		if tmpEnv.Location == nil || *(tmpEnv.Location) == "" {
+			ErrStop(`Missing "location" for "%s/%s"`, env.NiceType, env.Name)
+		}
+
+		// An env with dedicated profiles must list Consumption too
+		if props := env.Properties; props != nil &&
+			len(props.WorkloadProfiles) > 0 &&
+			props.FindWorkloadProfile("Consumption") == nil {
+			tmpProps := *props
+			tmpProps.WorkloadProfiles = append([]*AcaEnvWorkloadProfile{{
+				Name:                StringPtr("Consumption"),
+				WorkloadProfileType: StringPtr("Consumption"),
+			}}, props.WorkloadProfiles...)
+			tmpEnv.Properties = &tmpProps
+		}
+
+		// The shared key is never stored, it's pulled from the workspace
+		if wsRef := env.ResolveLogsWorkspace(); wsRef != nil &&
+			env.Properties.AppLogsConfiguration.LogAnalyticsConfiguration.SharedKey == nil {
+
+			// Copy them so we don't touch the original
+			props := *(tmpEnv.Properties)
+			logs := *(props.AppLogsConfiguration)
+			la := *(logs.LogAnalyticsConfiguration)
+			logs.LogAnalyticsConfiguration = &la
+			props.AppLogsConfiguration = &logs
+			tmpEnv.Properties = &props
+
+			la.SharedKey = StringPtr(
+				wsRef.AsSubstitution("sharedKeys.primarySharedKey"))
+		}
+	}
+	return json.Marshal(tmpEnv)
+}
+
+// Returns a reference to the Log Analytics workspace that the logs are sent
+// to, or nil if the customerId isn't a ${...} reference to one
+func (env *AcaEnv) ResolveLogsWorkspace() *ResourceReference {
+	if env.Properties == nil || env.Properties.AppLogsConfiguration == nil ||
+		env.Properties.AppLogsConfiguration.LogAnalyticsConfiguration == nil {
+		return nil
+	}
+
+	la := env.Properties.AppLogsConfiguration.LogAnalyticsConfiguration
+	for _, ref := range SubstitutionRefs(NotNil(la.CustomerId),
+		env.Subscription, env.ResourceGroup) {
+		if ref.Type == "Microsoft.OperationalInsights/workspaces" {
+			return ref
+		}
+	}
+	return nil
+}
+
+// Sends the env's logs to the named workspace, or turns off logging if ""
+func (env *AcaEnv) SetLogsWorkspace(name string) {
+	if name == "" {
+		if env.Properties != nil {
+			env.Properties.AppLogsConfiguration = nil
+		}
+		return
+	}
+
+	wsRef := LogAnalyticsRef(name, env.Subscription, env.ResourceGroup)
+	env.MustProperties().AppLogsConfiguration = &AcaEnvAppLogsConfiguration{
+		Destination: StringPtr("log-analytics"),
+		LogAnalyticsConfiguration: &AcaEnvLogAnalyticsConfiguration{
+			CustomerId: StringPtr(wsRef.AsSubstitution("properties.customerId")),
+		},
+	}
+}
+
+func (env *AcaEnv) MustProperties() *AcaEnvProperties {
+	if env.Properties == nil {
+		env.Properties = &AcaEnvProperties{}
+	}
+	return env.Properties
+}
+
+func (env *AcaEnv) DependsOn() []*ResourceReference {
+	refs := []*ResourceReference{}
+	if wsRef := env.ResolveLogsWorkspace(); wsRef != nil {
+		refs = append(refs, wsRef)
+	}
+	return refs
+}
+
+func (env *AcaEnv) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*ACA-Env(" + env.Name + ")"
+	form.AddProp("Name", env.Name)
+	if NotNil(env.Location) != "" {
+		form.AddProp("Location", NotNil(env.Location))
+	}
+	form.AddProp("Subscription", env.Subscription)
+	form.AddProp("ResourceGroup", env.ResourceGroup)
+
+	if props := env.Properties; props != nil &&
+		props.AppLogsConfiguration != nil {
+		logs := props.AppLogsConfiguration
+		nf := form.AddSection("Logs", NotNil(logs.Destination))
+		if wsRef := env.ResolveLogsWorkspace(); wsRef != nil {
+			nf.AddProp("Workspace", wsRef.Name)
+		} else if la := logs.LogAnalyticsConfiguration; la != nil &&
+			la.CustomerId != nil {
+			nf.AddProp("Customer ID", *(la.CustomerId))
+		}
+	}
+
+	if props := env.Properties; props != nil && len(props.WorkloadProfiles) > 0 {
+		nf := form.AddArray("Workload Profiles", "")
+		for _, wp := range props.WorkloadProfiles {
+			sec := nf.AddSection("*Profile:"+NotNil(wp.Name), "")
+			sec.AddProp("Name", NotNil(wp.Name))
+			sec.AddProp("Type", NotNil(wp.WorkloadProfileType))
+			if wp.MinimumCount != nil {
+				sec.AddProp("Min Count", fmt.Sprintf("%d", *(wp.MinimumCount)))
+			}
+			if wp.MaximumCount != nil {
+				sec.AddProp("Max Count", fmt.Sprintf("%d", *(wp.MaximumCount)))
+			}
+		}
+	}
+
+	return form
+}
+
+func (env *AcaEnv) FromForm(r *ResourceBase, f *Form) {
+	newEnv := &AcaEnv{
+		ResourceBase: env.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name":
+			// Skip
+		case "Location":
+			newEnv.Location = StringPtr(item.Value)
+		case "Subscription":
+			newEnv.Subscription = item.Value
+		case "ResourceGroup":
+			newEnv.ResourceGroup = item.Value
+		case "Logs":
+			if ws := item.GetProp("Workspace"); ws != "" {
+				newEnv.SetLogsWorkspace(ws)
+			} else {
+				logs := &AcaEnvAppLogsConfiguration{
+					Destination: NilStringPtr(item.Value),
+				}
+				if id := item.GetProp("Customer ID"); id != "" {
+					logs.LogAnalyticsConfiguration =
+						&AcaEnvLogAnalyticsConfiguration{
+							CustomerId: StringPtr(id),
+						}
+				}
+				newEnv.MustProperties().AppLogsConfiguration = logs
+			}
+		case "Workload Profiles":
+			for _, sec := range item.Items {
+				wp := &AcaEnvWorkloadProfile{
+					Name:                StringPtr(sec.GetProp("Name")),
+					WorkloadProfileType: StringPtr(sec.GetProp("Type")),
+				}
+				if val := sec.GetProp("Min Count"); val != "" {
+					i, err := strconv.Atoi(val)
+					NoErr(err, "Bad Min Count value %q: %s", val, err)
+					wp.MinimumCount = &i
+				}
+				if val := sec.GetProp("Max Count"); val != "" {
+					i, err := strconv.Atoi(val)
+					NoErr(err, "Bad Max Count value %q: %s", val, err)
+					wp.MaximumCount = &i
+				}
+				newEnv.MustProperties().WorkloadProfiles =
+					append(newEnv.MustProperties().WorkloadProfiles, wp)
+			}
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newEnv, "", "  ")
+
+	r.Object = newEnv
+	r.RawData = data
+}
+
+func (env *AcaEnv) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(env, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (env *AcaEnv) ToJson() string {
+	data, _ := json.MarshalIndent(env, "", "  ")
+	return string(data)
+}
+
+func (env *AcaEnv) HideServerFields() {
+	// The Consumption profile is added by Azure and by our ARM json, it's
+	// not in the stage files. Diffs do this to both sides.
+	if props := env.Properties; props != nil {
+		profiles := []*AcaEnvWorkloadProfile{}
+		for _, wp := range props.WorkloadProfiles {
+			if !strings.EqualFold(NotNil(wp.WorkloadProfileType), "Consumption") {
+				profiles = append(profiles, wp)
+			}
+		}
+		props.WorkloadProfiles = nil
+		if len(profiles) > 0 {
+			props.WorkloadProfiles = profiles
+		}
+	}
+}
+
+func (env *AcaEnv) HideSecrets() {
+	if props := env.Properties; props != nil &&
+		props.AppLogsConfiguration != nil &&
+		props.AppLogsConfiguration.LogAnalyticsConfiguration != nil {
+		props.AppLogsConfiguration.LogAnalyticsConfiguration.SharedKey = nil
+	}
+}
+
+func AddAcaEnvFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddAcaEnvFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddAcaEnvFunc")
+
+	env := &AcaEnv{}
+	name, _ := cmd.Flags().GetString("name")
+	env.InitResource(env, "Microsoft.App/managedEnvironments", "aca-env", name)
+	env.Location = StringPtr(GetConfigProperty("defaults.Location"))
+
+	// If default isn't set, use this one
+	if GetConfigProperty("defaults.aca-env") == "" {
+		SetConfigProperty("defaults.aca-env", name, false)
+	}
+
+	env.ProcessFlags(cmd)
+	env.SaveAndUp(cmd)
+}
+
+func UpdateAcaEnvFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdateAcaEnvFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdateAcaEnvFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	env := LoadStageResource("aca-env", name).Object.(*AcaEnv)
+
+	env.ProcessFlags(cmd)
+	env.SaveAndUp(cmd)
+}
+
+func (env *AcaEnv) ProcessFlags(cmd *cobra.Command) {
+	env.ProcessResourceFlags(cmd, &env.Location)
+
+	if cmd.Flags().Changed("logs-workspace") {
+		env.SetLogsWorkspace(FlagAsString(cmd, "logs-workspace"))
+	}
+
+	names, _ := cmd.Flags().GetStringArray("remove-workload-profile")
+	for _, name := range names {
+		if !env.RemoveWorkloadProfile(name) {
+			ErrStop("Environment %q has no workload profile %q", env.Name, name)
+		}
+	}
+
+	profiles, _ := cmd.Flags().GetStringArray("workload-profile")
+	for _, profile := range profiles {
+		env.SetWorkloadProfile(profile)
+	}
+}
+
+func (props *AcaEnvProperties) FindWorkloadProfile(name string) *AcaEnvWorkloadProfile {
+	for _, wp := range props.WorkloadProfiles {
+		if strings.EqualFold(NotNil(wp.Name), name) {
+			return wp
+		}
+	}
+	return nil
+}
+
+// Adds, or replaces, a dedicated workload profile. "spec" is:
+//
+//	NAME=TYPE[,min=N][,max=N]
+//
+// where TYPE is a machine size like D4 or E16.
+func (env *AcaEnv) SetWorkloadProfile(spec string) {
+	parts := strings.Split(spec, ",")
+	name, wpType, _ := strings.Cut(parts[0], "=")
+	if name == "" || wpType == "" {
+		ErrStop("Workload profile %q must be of the form: "+
+			"NAME=TYPE[,min=N][,max=N]", spec)
+	}
+	if strings.EqualFold(name, "Consumption") ||
+		strings.EqualFold(wpType, "Consumption") {
+		ErrStop("The Consumption workload profile is always there, it " +
+			"can't be added")
+	}
+
+	wp := &AcaEnvWorkloadProfile{
+		Name:                StringPtr(name),
+		WorkloadProfileType: StringPtr(strings.ToUpper(wpType)),
+	}
+	for _, part := range parts[1:] {
+		key, val, _ := strings.Cut(part, "=")
+		i, err := strconv.Atoi(val)
+		if err != nil || i < 0 {
+			ErrStop("Workload profile option %q must be a number, 0 or more",
+				part)
+		}
+		switch key {
+		case "min":
+			wp.MinimumCount = &i
+		case "max":
+			wp.MaximumCount = &i
+		default:
+			ErrStop("Unknown workload profile option %q, must be 'min' "+
+				"or 'max'", key)
+		}
+	}
+	if wp.MinimumCount != nil && wp.MaximumCount != nil &&
+		*(wp.MinimumCount) > *(wp.MaximumCount) {
+		ErrStop("Workload profile %q's min (%d) is more than its max (%d)",
+			name, *(wp.MinimumCount), *(wp.MaximumCount))
+	}
+
+	env.RemoveWorkloadProfile(name)
+	props := env.MustProperties()
+	props.WorkloadProfiles = append(props.WorkloadProfiles, wp)
+}
+
+// Returns false if there was no profile called "name"
+func (env *AcaEnv) RemoveWorkloadProfile(name string) bool {
+	props := env.MustProperties()
+	for i, wp := range props.WorkloadProfiles {
+		if strings.EqualFold(NotNil(wp.Name), name) {
+			props.WorkloadProfiles = append(props.WorkloadProfiles[:i],
+				props.WorkloadProfiles[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Stops if the env has no workload profile called "name". The env in the
+// stage is checked first, if it's not there then the one in Azure is.
+// Consumption is always valid.
+func CheckWorkloadProfile(envRef *ResourceReference, name string) {
+	if strings.EqualFold(name, "Consumption") {
+		return
+	}
+
+	stage := GetConfigProperty("currentStage")
+	if res, err := ResourceFromFile(stage,
+		ResourceFileName("aca-env", envRef.Name)); err == nil {
+		env := res.Object.(*AcaEnv)
+		if env.MustProperties().FindWorkloadProfile(name) == nil {
+			ErrStop("Environment %q has no workload profile %q, see "+
+				"'azx update aca-env --workload-profile'", envRef.Name, name)
+		}
+		return
+	}
+
+	data, err := downloadResource(envRef.Subscription, envRef.ResourceGroup,
+		envRef.Type, envRef.Name, envRef.APIVersion)
+	if err != nil {
+		ErrStop("Can't check workload profile %q, environment %q isn't in "+
+			"the stage and couldn't be downloaded: %s", name, envRef.Name, err)
+	}
+	if data == nil {
+		ErrStop("Environment %q isn't in the stage or in Azure", envRef.Name)
+	}
+	azEnv := AcaEnv{}
+	err = json.Unmarshal(data, &azEnv)
+	NoErr(err, "Error parsing environment %q: %s", envRef.Name, err)
+	if azEnv.MustProperties().FindWorkloadProfile(name) == nil {
+		ErrStop("Environment %q has no workload profile %q", envRef.Name, name)
+	}
+}
+
+// ---
+
+type AcaEnvStorageAzureFile struct {
+	AccountName *string `json:"accountName,omitempty"`
+	AccountKey  *string `json:"accountKey,omitempty"`
+	ShareName   *string `json:"shareName,omitempty"`
+	AccessMode  *string `json:"accessMode,omitempty"`
+}
+
+type AcaEnvStorageProperties struct {
+	AzureFile *AcaEnvStorageAzureFile `json:"azureFile,omitempty"`
+}
+
+type AcaEnvStorage struct {
+	ResourceBase
+
+	Properties *AcaEnvStorageProperties `json:"properties,omitempty"`
+}
+
+func (aes *AcaEnvStorage) MarshalJSON() ([]byte, error) {
+	tmpAes := *aes
+	if WhyMarshal == "ARM" && aes.Properties != nil &&
+		aes.Properties.AzureFile != nil {
+
+		// Copy them so we don't touch the original
+		props := *(aes.Properties)
+		azureFile := *(props.AzureFile)
+		props.AzureFile = &azureFile
+		tmpAes.Properties = &props
+
+		if azureFile.AccessMode == nil {
+			azureFile.AccessMode = StringPtr("ReadWrite")
+		}
+		if azureFile.AccountKey == nil {
+			azureFile.AccountKey = StringPtr(
+				aes.ResolveAccount().AsSubstitution("keys.keys[0].value"))
+		}
+	}
+	return json.Marshal(tmpAes)
+}
+
+func (aes *AcaEnvStorage) MustAzureFile() *AcaEnvStorageAzureFile {
+	if aes.Properties == nil {
+		aes.Properties = &AcaEnvStorageProperties{}
+	}
+	if aes.Properties.AzureFile == nil {
+		aes.Properties.AzureFile = &AcaEnvStorageAzureFile{}
+	}
+	return aes.Properties.AzureFile
+}
+
+func (aes *AcaEnvStorage) EnvName() string {
+	env, _, _ := strings.Cut(aes.Name, "/")
+	return env
+}
+
+func (aes *AcaEnvStorage) StorageName() string {
+	_, name, _ := strings.Cut(aes.Name, "/")
+	return name
+}
+
+func (aes *AcaEnvStorage) ResolveAccount() *ResourceReference {
+	return StorageRef(NotNil(aes.MustAzureFile().AccountName),
+		aes.Subscription, aes.ResourceGroup)
+}
+
+func (aes *AcaEnvStorage) DependsOn() []*ResourceReference {
+	refs := []*ResourceReference{{
+		Subscription:  aes.Subscription,
+		ResourceGroup: aes.ResourceGroup,
+		Type:          "Microsoft.App/managedEnvironments",
+		APIVersion:    GetResourceDef("Microsoft.App/managedEnvironments").Defaults["APIVERSION"],
+		Name:          aes.EnvName(),
+	}}
+
+	if af := aes.MustAzureFile(); af.AccountName != nil {
+		acctRef := aes.ResolveAccount()
+		refs = append(refs, acctRef)
+
+		if af.ShareName != nil {
+			shareRef := *acctRef
+			shareRef.Type = "Microsoft.Storage/storageAccounts/fileServices/shares"
+			shareRef.Name = *(af.AccountName) + "/default/" + *(af.ShareName)
+			refs = append(refs, &shareRef)
+		}
+	}
+
+	return refs
+}
+
+func (aes *AcaEnvStorage) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*ACA-Env-Storage(" + aes.Name + ")"
+	form.AddProp("Name", aes.StorageName())
+	form.AddProp("Environment", aes.EnvName())
+	form.AddProp("Subscription", aes.Subscription)
+	form.AddProp("ResourceGroup", aes.ResourceGroup)
+
+	if aes.Properties != nil && aes.Properties.AzureFile != nil {
+		af := aes.Properties.AzureFile
+		nf := form.AddSection("Azure File", "")
+		if af.AccountName != nil {
+			nf.AddProp("Account", *(af.AccountName))
+		}
+		if af.ShareName != nil {
+			nf.AddProp("Share", *(af.ShareName))
+		}
+		if af.AccessMode != nil {
+			nf.AddProp("Access Mode", *(af.AccessMode))
+		}
+	}
+
+	return form
+}
+
+func (aes *AcaEnvStorage) FromForm(r *ResourceBase, f *Form) {
+	newAes := &AcaEnvStorage{
+		ResourceBase: aes.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name", "Environment":
+			// Skip
+		case "Subscription":
+			newAes.Subscription = item.Value
+		case "ResourceGroup":
+			newAes.ResourceGroup = item.Value
+		case "Azure File":
+			af := newAes.MustAzureFile()
+			af.AccountName = NilStringPtr(item.GetProp("Account"))
+			af.ShareName = NilStringPtr(item.GetProp("Share"))
+			af.AccessMode = NilStringPtr(item.GetProp("Access Mode"))
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newAes, "", "  ")
+
+	r.Object = newAes
+	r.RawData = data
+}
+
+func (aes *AcaEnvStorage) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(aes, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (aes *AcaEnvStorage) ToJson() string {
+	data, _ := json.MarshalIndent(aes, "", "  ")
+	return string(data)
+}
+
+func (aes *AcaEnvStorage) HideServerFields() {
+}
+
+func (aes *AcaEnvStorage) HideSecrets() {
+	if aes.Properties != nil && aes.Properties.AzureFile != nil {
+		aes.Properties.AzureFile.AccountKey = nil
+	}
+}
+
+func AcaEnvFromARMJson(data []byte) *ResourceBase {
+	env := &AcaEnv{}
+	if res := ParseARMResource(data, "Microsoft.App/managedEnvironments",
+		"aca-env", env, &env.ResourceBase); res != nil {
+		return res
+	}
+
+	aes := &AcaEnvStorage{}
+	if res := ParseARMResource(data, "Microsoft.App/managedEnvironments/storages",
+		"aca-env-storage", aes, &aes.ResourceBase); res != nil {
+		return res
+	}
+
+	amc := &AcaManagedCert{}
+	if res := ParseARMResource(data,
+		"Microsoft.App/managedEnvironments/managedCertificates",
+		"aca-managed-cert", amc, &amc.ResourceBase); res != nil {
+		return res
+	}
+
+	comp := &DaprComponent{}
+	if res := ParseARMResource(data,
+		"Microsoft.App/managedEnvironments/daprComponents",
+		"dapr-component", comp, &comp.ResourceBase); res != nil {
+		return res
+	}
+
+	return nil
+}
+
+// Creates the env storage, in the current stage, for an Azure Files share
+func NewAcaEnvStorage(envRef *ResourceReference, name string, share string) *AcaEnvStorage {
+	aes := &AcaEnvStorage{}
+	aes.InitResource(aes, "Microsoft.App/managedEnvironments/storages",
+		"aca-env-storage", envRef.Name+"/"+name)
+	aes.Subscription = envRef.Subscription
+	aes.ResourceGroup = envRef.ResourceGroup
+	aes.SetShare(share)
+
+	return aes
+}
+
+// "share" is of the form ACCOUNT/SHARE
+func (aes *AcaEnvStorage) SetShare(share string) {
+	account, shareName, found := strings.Cut(share, "/")
+	if !found || account == "" || shareName == "" {
+		ErrStop("Share %q must be of the form: ACCOUNT/SHARE", share)
+	}
+	af := aes.MustAzureFile()
+	af.AccountName = StringPtr(account)
+	af.ShareName = StringPtr(shareName)
+}
+
+func AddAcaEnvStorageFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddAcaEnvStorageFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddAcaEnvStorageFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	name = ChildName(name, GetConfigProperty("defaults.aca-env"), "ENV")
+
+	aes := &AcaEnvStorage{}
+	aes.InitResource(aes, "Microsoft.App/managedEnvironments/storages",
+		"aca-env-storage", name)
+
+	aes.ProcessFlags(cmd)
+	aes.SaveAndUp(cmd)
+}
+
+func UpdateAcaEnvStorageFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdateAcaEnvStorageFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdateAcaEnvStorageFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	name = ChildName(name, GetConfigProperty("defaults.aca-env"), "ENV")
+	aes := LoadStageResource("aca-env-storage", name).Object.(*AcaEnvStorage)
+
+	aes.ProcessFlags(cmd)
+	aes.SaveAndUp(cmd)
+}
+
+func (aes *AcaEnvStorage) ProcessFlags(cmd *cobra.Command) {
+	aes.ProcessResourceFlags(cmd, nil)
+
+	if cmd.Flags().Changed("share") {
+		aes.SetShare(FlagAsString(cmd, "share"))
+	}
+
+	if cmd.Flags().Changed("access-mode") {
+		aes.MustAzureFile().AccessMode =
+			NilStringPtr(FlagAsString(cmd, "access-mode"))
+	}
+}
+
+// ---
+
+type AcaManagedCertProperties struct {
+	SubjectName             *string `json:"subjectName,omitempty"`
+	DomainControlValidation *string `json:"domainControlValidation,omitempty"`
+}
+
+type AcaManagedCert struct {
+	ResourceBase
+
+	Location   *string                   `json:"location,omitempty"`
+	Properties *AcaManagedCertProperties `json:"properties,omitempty"`
+}
+
+func (amc *AcaManagedCert) MarshalJSON() ([]byte, error) {
+	tmpAmc := *amc
+	if WhyMarshal == "ARM" {
+		if tmpAmc.Location == nil {
+			tmpAmc.Location = StringPtr(GetConfigProperty("defaults.Location"))
+		}
+		if tmpAmc.Location == nil || *(tmpAmc.Location) == "" {
+			ErrStop(`Missing "location" for "%s/%s"`, amc.NiceType, amc.Name)
+		}
+
+		// Copy it so we don't touch the original
+		props := AcaManagedCertProperties{}
+		if amc.Properties != nil {
+			props = *(amc.Properties)
+		}
+		tmpAmc.Properties = &props
+
+		if props.DomainControlValidation == nil {
+			props.DomainControlValidation = StringPtr("CNAME")
+		}
+	}
+	return json.Marshal(tmpAmc)
+}
+
+func (amc *AcaManagedCert) EnvName() string {
+	env, _, _ := strings.Cut(amc.Name, "/")
+	return env
+}
+
+func (amc *AcaManagedCert) CertName() string {
+	_, name, _ := strings.Cut(amc.Name, "/")
+	return name
+}
+
+func (amc *AcaManagedCert) MustProperties() *AcaManagedCertProperties {
+	if amc.Properties == nil {
+		amc.Properties = &AcaManagedCertProperties{}
+	}
+	return amc.Properties
+}
+
+// The domain's DNS records need to exist before the certificate can be
+// validated, so if they're in the stage they're created first
+func (amc *AcaManagedCert) DependsOn() []*ResourceReference {
+	refs := []*ResourceReference{{
+		Subscription:  amc.Subscription,
+		ResourceGroup: amc.ResourceGroup,
+		Type:          "Microsoft.App/managedEnvironments",
+		APIVersion:    GetResourceDef("Microsoft.App/managedEnvironments").Defaults["APIVERSION"],
+		Name:          amc.EnvName(),
+	}}
+
+	if amc.Properties != nil && amc.Properties.SubjectName != nil {
+		refs = append(refs, DnsRecordRefs(*(amc.Properties.SubjectName))...)
+	}
+	return append(refs, amc.AppRefs()...)
+}
+
+// Returns references to the apps, in the stage, whose custom domains use
+// this cert. Azure needs the hostname to be on the app before the cert can
+// be created, see AcaApp.ProvisionSteps.
+func (amc *AcaManagedCert) AppRefs() []*ResourceReference {
+	refs := []*ResourceReference{}
+	for _, res := range GetStageResources("") {
+		app, ok := res.Object.(*AcaApp)
+		if !ok || app.Properties == nil || app.Properties.Configuration == nil ||
+			app.Properties.Configuration.Ingress == nil {
+			continue
+		}
+		for _, cd := range app.Properties.Configuration.Ingress.CustomDomains {
+			certRef := app.Properties.ResolveCertificate(cd)
+			if certRef != nil && certRef.Name == amc.Name {
+				refs = append(refs, res.AsRef())
+				break
+			}
+		}
+	}
+	return refs
+}
+
+func (amc *AcaManagedCert) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*ACA-Managed-Cert(" + amc.Name + ")"
+	form.AddProp("Name", amc.CertName())
+	form.AddProp("Environment", amc.EnvName())
+	if NotNil(amc.Location) != "" {
+		form.AddProp("Location", NotNil(amc.Location))
+	}
+	form.AddProp("Subscription", amc.Subscription)
+	form.AddProp("ResourceGroup", amc.ResourceGroup)
+	if props := amc.Properties; props != nil {
+		if props.SubjectName != nil {
+			form.AddProp("Domain", *(props.SubjectName))
+		}
+		if props.DomainControlValidation != nil {
+			form.AddProp("Validation", *(props.DomainControlValidation))
+		}
+	}
+
+	return form
+}
+
+func (amc *AcaManagedCert) FromForm(r *ResourceBase, f *Form) {
+	newAmc := &AcaManagedCert{
+		ResourceBase: amc.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name", "Environment":
+			// Skip
+		case "Location":
+			newAmc.Location = StringPtr(item.Value)
+		case "Subscription":
+			newAmc.Subscription = item.Value
+		case "ResourceGroup":
+			newAmc.ResourceGroup = item.Value
+		case "Domain":
+			newAmc.MustProperties().SubjectName = StringPtr(item.Value)
+		case "Validation":
+			newAmc.MustProperties().DomainControlValidation =
+				StringPtr(item.Value)
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newAmc, "", "  ")
+
+	r.Object = newAmc
+	r.RawData = data
+}
+
+func (amc *AcaManagedCert) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(amc, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (amc *AcaManagedCert) ToJson() string {
+	data, _ := json.MarshalIndent(amc, "", "  ")
+	return string(data)
+}
+
+func (amc *AcaManagedCert) HideServerFields() {
+}
+
+// Creates the managed certificate, in the current stage, for "domain"
+func NewAcaManagedCert(envRef *ResourceReference, name string, domain string) *AcaManagedCert {
+	amc := &AcaManagedCert{}
+	amc.InitResource(amc, "Microsoft.App/managedEnvironments/managedCertificates",
+		"aca-managed-cert", envRef.Name+"/"+name)
+	amc.Subscription = envRef.Subscription
+	amc.ResourceGroup = envRef.ResourceGroup
+	amc.Location = StringPtr(GetConfigProperty("defaults.Location"))
+	amc.MustProperties().SubjectName = StringPtr(domain)
+
+	return amc
+}
+
+// The name of the managed certificate that azx creates for "domain"
+func AcaManagedCertName(domain string) string {
+	return strings.ReplaceAll(strings.ToLower(domain), ".", "-")
+}
+
+func AddAcaManagedCertFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddAcaManagedCertFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddAcaManagedCertFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	name = ChildName(name, GetConfigProperty("defaults.aca-env"), "ENV")
+
+	amc := &AcaManagedCert{}
+	amc.InitResource(amc, "Microsoft.App/managedEnvironments/managedCertificates",
+		"aca-managed-cert", name)
+	amc.Location = StringPtr(GetConfigProperty("defaults.Location"))
+	amc.ProcessResourceFlags(cmd, &amc.Location)
+	amc.MustProperties().SubjectName = StringPtr(FlagAsString(cmd, "domain"))
+
+	amc.SaveAndUp(cmd)
+}
+
+// ---
+
+type DaprComponentMetadata struct {
+	Name      *string `json:"name,omitempty"`
+	Value     *string `json:"value,omitempty"`
+	SecretRef *string `json:"secretRef,omitempty"`
+}
+
+type DaprComponentProperties struct {
+	ComponentType *string                  `json:"componentType,omitempty"`
+	Version       *string                  `json:"version,omitempty"`
+	IgnoreErrors  *bool                    `json:"ignoreErrors,omitempty"`
+	InitTimeout   *string                  `json:"initTimeout,omitempty"`
+	Secrets       []*AcaAppSecret          `json:"secrets,omitempty"`
+	Metadata      []*DaprComponentMetadata `json:"metadata,omitempty"`
+	Scopes        []string                 `json:"scopes,omitempty"`
+}
+
+type DaprComponent struct {
+	ResourceBase
+
+	Properties *DaprComponentProperties `json:"properties,omitempty"`
+}
+
+// Handlers for "--resource TYPE/NAME", keyed by the nice type of the
+// resource. They fill in the component's type (if it's not set), metadata
+// and secrets so it can connect to the resource.
+var DaprComponentSources = map[string]func(comp *DaprComponent, name string){}
+
+func (comp *DaprComponent) MarshalJSON() ([]byte, error) {
+	tmpComp := *comp
+	if WhyMarshal == "ARM" {
+		// Copy it so we don't touch the original
+		props := DaprComponentProperties{}
+		if comp.Properties != nil {
+			props = *(comp.Properties)
+		}
+		tmpComp.Properties = &props
+
+		if props.Version == nil {
+			props.Version = StringPtr("v1")
+		}
+	}
+	return json.Marshal(tmpComp)
+}
+
+func (comp *DaprComponent) EnvName() string {
+	env, _, _ := strings.Cut(comp.Name, "/")
+	return env
+}
+
+func (comp *DaprComponent) ComponentName() string {
+	_, name, _ := strings.Cut(comp.Name, "/")
+	return name
+}
+
+func (comp *DaprComponent) MustProperties() *DaprComponentProperties {
+	if comp.Properties == nil {
+		comp.Properties = &DaprComponentProperties{}
+	}
+	return comp.Properties
+}
+
+func (comp *DaprComponent) FindMetadata(name string) *DaprComponentMetadata {
+	if comp.Properties == nil {
+		return nil
+	}
+	for _, md := range comp.Properties.Metadata {
+		if NotNil(md.Name) == name {
+			return md
+		}
+	}
+	return nil
+}
+
+// Only one of "value" and "secretRef" should be non-empty
+func (comp *DaprComponent) SetMetadata(name string, value string, secretRef string) {
+	md := comp.FindMetadata(name)
+	if md == nil {
+		md = &DaprComponentMetadata{Name: StringPtr(name)}
+		props := comp.MustProperties()
+		props.Metadata = append(props.Metadata, md)
+	}
+	md.Value = NilStringPtr(value)
+	md.SecretRef = NilStringPtr(secretRef)
+}
+
+func (comp *DaprComponent) RemoveMetadata(name string) {
+	props := comp.MustProperties()
+	for i, md := range props.Metadata {
+		if NotNil(md.Name) == name {
+			props.Metadata = append(props.Metadata[:i], props.Metadata[i+1:]...)
+			return
+		}
+	}
+	ErrStop("Metadata item %q was not found", name)
+}
+
+// Secrets and metadata can reference other resources, e.g. a Service Bus
+// namespace's connection string, so those need to exist first
+func (comp *DaprComponent) DependsOn() []*ResourceReference {
+	refs := []*ResourceReference{{
+		Subscription:  comp.Subscription,
+		ResourceGroup: comp.ResourceGroup,
+		Type:          "Microsoft.App/managedEnvironments",
+		APIVersion:    GetResourceDef("Microsoft.App/managedEnvironments").Defaults["APIVERSION"],
+		Name:          comp.EnvName(),
+	}}
+
+	if props := comp.Properties; props != nil {
+		for _, secret := range props.Secrets {
+			refs = append(refs, SubstitutionRefs(NotNil(secret.Value),
+				comp.Subscription, comp.ResourceGroup)...)
+		}
+		for _, md := range props.Metadata {
+			refs = append(refs, SubstitutionRefs(NotNil(md.Value),
+				comp.Subscription, comp.ResourceGroup)...)
+		}
+	}
+	return refs
+}
+
+func (comp *DaprComponent) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*Dapr-Component(" + comp.Name + ")"
+	form.AddProp("Name", comp.ComponentName())
+	form.AddProp("Environment", comp.EnvName())
+	form.AddProp("Subscription", comp.Subscription)
+	form.AddProp("ResourceGroup", comp.ResourceGroup)
+
+	props := comp.Properties
+	if props == nil {
+		return form
+	}
+
+	if props.ComponentType != nil {
+		form.AddProp("Type", *(props.ComponentType))
+	}
+	if props.Version != nil {
+		form.AddProp("Version", *(props.Version))
+	}
+	if props.IgnoreErrors != nil {
+		form.AddProp("Ignore Errors", fmt.Sprintf("%v", *(props.IgnoreErrors)))
+	}
+	if props.InitTimeout != nil {
+		form.AddProp("Init Timeout", *(props.InitTimeout))
+	}
+	if len(props.Scopes) > 0 {
+		form.AddProp("Scopes", strings.Join(props.Scopes, ","))
+	}
+	if len(props.Metadata) > 0 {
+		mf := form.AddArray("Metadata", "")
+		for _, md := range props.Metadata {
+			if md.SecretRef != nil {
+				mf.AddProp(NotNil(md.Name), "secretref:"+*(md.SecretRef))
+			} else {
+				mf.AddProp(NotNil(md.Name), NotNil(md.Value))
+			}
+		}
+	}
+	SecretsToForm(form, props.Secrets)
+
+	return form
+}
+
+func (comp *DaprComponent) FromForm(r *ResourceBase, f *Form) {
+	newComp := &DaprComponent{
+		ResourceBase: comp.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name", "Environment":
+			// Skip
+		case "Subscription":
+			newComp.Subscription = item.Value
+		case "ResourceGroup":
+			newComp.ResourceGroup = item.Value
+		case "Type":
+			newComp.MustProperties().ComponentType = StringPtr(item.Value)
+		case "Version":
+			newComp.MustProperties().Version = StringPtr(item.Value)
+		case "Ignore Errors":
+			newComp.MustProperties().IgnoreErrors =
+				BoolPtr(item.Value == "true")
+		case "Init Timeout":
+			newComp.MustProperties().InitTimeout = StringPtr(item.Value)
+		case "Scopes":
+			newComp.MustProperties().Scopes = strings.Split(item.Value, ",")
+		case "Metadata":
+			for _, md := range item.Items {
+				ref, isRef := strings.CutPrefix(md.Value, "secretref:")
+				if isRef {
+					newComp.SetMetadata(md.Title, "", ref)
+				} else {
+					newComp.SetMetadata(md.Title, md.Value, "")
+				}
+			}
+		case "Secrets":
+			newComp.MustProperties().Secrets =
+				SecretsFromForm(item, comp.MustProperties().Secrets)
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newComp, "", "  ")
+
+	r.Object = newComp
+	r.RawData = data
+}
+
+func (comp *DaprComponent) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(comp, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (comp *DaprComponent) ToJson() string {
+	data, _ := json.MarshalIndent(comp, "", "  ")
+	return string(data)
+}
+
+func (comp *DaprComponent) HideServerFields() {
+	if comp.Properties != nil {
+		MaskServerSecrets(comp.Properties.Secrets)
+	}
+}
+
+// Azure never returns the values of secrets so just compare their names
+func (comp *DaprComponent) HideSecrets() {
+	if comp.Properties != nil {
+		for _, secret := range comp.Properties.Secrets {
+			secret.Value = nil
+		}
+	}
+}
+
+// Returns the Dapr app IDs of the apps, in the stage, that are in "env"
+func DaprAppIds(env string) map[string]bool {
+	ids := map[string]bool{}
+	for _, res := range GetStageResources("") {
+		if res.NiceType != "aca-app" {
+			continue
+		}
+		app := res.Object.(*AcaApp)
+		if app.MustProperties().ResolveEnvironmentId().Name != env {
+			continue
+		}
+		if id := app.DaprAppId(); id != "" {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// Scopes that don't match an app will just be ignored by Dapr, which is
+// easy to miss, so let the user know. The app might not be added yet, so
+// it's not an error.
+func (comp *DaprComponent) CheckScopes() {
+	if comp.Properties == nil || len(comp.Properties.Scopes) == 0 {
+		return
+	}
+	ids := DaprAppIds(comp.EnvName())
+	for _, scope := range comp.Properties.Scopes {
+		if !ids[scope] {
+			fmt.Printf("Warning: no app in environment %q has Dapr app ID %q\n",
+				comp.EnvName(), scope)
+		}
+	}
+}
+
+func AddDaprComponentFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddDaprComponentFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddDaprComponentFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	name = ChildName(name, GetConfigProperty("defaults.aca-env"), "ENV")
+
+	comp := &DaprComponent{}
+	comp.InitResource(comp, "Microsoft.App/managedEnvironments/daprComponents",
+		"dapr-component", name)
+
+	comp.ProcessFlags(cmd)
+	if comp.Properties == nil || comp.Properties.ComponentType == nil {
+		ErrStop("Dapr component %q needs a '--type' or '--resource'", name)
+	}
+	comp.SaveAndUp(cmd)
+}
+
+func UpdateDaprComponentFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdateDaprComponentFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdateDaprComponentFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	name = ChildName(name, GetConfigProperty("defaults.aca-env"), "ENV")
+	comp := LoadStageResource("dapr-component", name).Object.(*DaprComponent)
+
+	comp.ProcessFlags(cmd)
+	comp.SaveAndUp(cmd)
+}
+
+func (comp *DaprComponent) ProcessFlags(cmd *cobra.Command) {
+	comp.ProcessResourceFlags(cmd, nil)
+
+	if cmd.Flags().Changed("type") {
+		comp.MustProperties().ComponentType =
+			NilStringPtr(FlagAsString(cmd, "type"))
+	}
+
+	if cmd.Flags().Changed("version") {
+		comp.MustProperties().Version = NilStringPtr(FlagAsString(cmd, "version"))
+	}
+
+	if cmd.Flags().Changed("resource") {
+		resource := FlagAsString(cmd, "resource")
+		niceType, name, _ := strings.Cut(resource, "/")
+		handler := DaprComponentSources[niceType]
+		if handler == nil || name == "" {
+			keys := []string{}
+			for key := range DaprComponentSources {
+				keys = append(keys, key+"/NAME")
+			}
+			sort.Strings(keys)
+			ErrStop("Unsupported Dapr component resource %q, must be one of: %s",
+				resource, strings.Join(keys, ", "))
+		}
+		handler(comp, name)
+	}
+
+	if cmd.Flags().Changed("secret") {
+		secrets, _ := cmd.Flags().GetStringArray("secret")
+		for _, secret := range secrets {
+			SetParamSecret(&comp.MustProperties().Secrets, secret)
+		}
+	}
+
+	if cmd.Flags().Changed("metadata") {
+		items, _ := cmd.Flags().GetStringArray("metadata")
+		for _, item := range items {
+			name, value, _ := strings.Cut(item, "=")
+			if name == "" {
+				ErrStop("Metadata %q must be of the form: NAME=VALUE", item)
+			}
+			if ref, isRef := strings.CutPrefix(value, "secretref:"); isRef {
+				comp.SetMetadata(name, "", ref)
+			} else {
+				comp.SetMetadata(name, value, "")
+			}
+		}
+	}
+
+	if cmd.Flags().Changed("remove-metadata") {
+		items, _ := cmd.Flags().GetStringArray("remove-metadata")
+		for _, item := range items {
+			comp.RemoveMetadata(item)
+		}
+	}
+
+	if cmd.Flags().Changed("remove-secret") {
+		secrets, _ := cmd.Flags().GetStringArray("remove-secret")
+		for _, secret := range secrets {
+			RemoveSecret(&comp.MustProperties().Secrets, secret)
+		}
+	}
+
+	// Every secretRef needs to point to one of the component's secrets
+	if props := comp.Properties; props != nil {
+		for _, md := range props.Metadata {
+			if md.SecretRef == nil {
+				continue
+			}
+			found := false
+			for _, secret := range props.Secrets {
+				found = found || NotNil(secret.Name) == *(md.SecretRef)
+			}
+			if !found {
+				ErrStop("Metadata %q references secret %q, which doesn't exist",
+					NotNil(md.Name), *(md.SecretRef))
+			}
+		}
+	}
+
+	if cmd.Flags().Changed("scope") {
+		scopes, _ := cmd.Flags().GetStringArray("scope")
+		props := comp.MustProperties()
+		for _, scope := range scopes {
+			found := false
+			for _, s := range props.Scopes {
+				found = found || s == scope
+			}
+			if !found {
+				props.Scopes = append(props.Scopes, scope)
+			}
+		}
+		comp.CheckScopes()
+	}
+
+	if cmd.Flags().Changed("remove-scope") {
+		scopes, _ := cmd.Flags().GetStringArray("remove-scope")
+		props := comp.MustProperties()
+		for _, scope := range scopes {
+			found := false
+			for i, s := range props.Scopes {
+				if s == scope {
+					props.Scopes = append(props.Scopes[:i], props.Scopes[i+1:]...)
+					found = true
+					break
+				}
+			}
+			if !found {
+				ErrStop("Scope %q was not found", scope)
+			}
+		}
+	}
+}
@@ -0,0 +1,800 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/duglin/dlog"
+	"github.com/spf13/cobra"
+)
+
+func initAcaJob() {
+	log.VPrintf(3, "Init initAcaJob")
+	setupAcaJobCmds()
+	setupAcaJobResourceDefs()
+	RegisteredParsers = append(RegisteredParsers, AcaJobFromARMJson)
+	ParamFields["Microsoft.App/jobs"] = []string{
+		"properties.configuration.secrets[].value"}
+}
+
+func setupAcaJobCmds() {
+	cmd := &cobra.Command{
+		Use:   "aca-job",
+		Short: "Add an Azure Container App Job",
+		Run:   AddAcaJobFunc,
+	}
+	addAcaJobFlags(cmd)
+	AddCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:   "aca-job",
+		Short: "Update an Azure Container App Job",
+		Run:   UpdateAcaJobFunc,
+	}
+	addAcaJobFlags(cmd)
+	UpdateCmd.AddCommand(cmd)
+
+	AddShowCmd("aca-job", "Show details about an Azure Container App Job", "job")
+
+	// ---
+
+	jobCmd := &cobra.Command{
+		Use:   "aca-job",
+		Short: "Manage Azure Container App Jobs",
+	}
+	RootCmd.AddCommand(jobCmd)
+
+	cmd = &cobra.Command{
+		Use:   "start",
+		Short: "Start an execution of a job and wait for it to finish",
+		Run:   StartAcaJobFunc,
+	}
+	cmd.Flags().StringP("name", "n", "", "Name of job")
+	cmd.MarkFlagRequired("name")
+	jobCmd.AddCommand(cmd)
+}
+
+func addAcaJobFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("name", "n", "", "Name of job")
+	cmd.Flags().StringP("image", "i", "", "Name of container image")
+	cmd.Flags().String("container", "", "Container that --image and --env apply to, added if needed (default: the first one)")
+	cmd.Flags().String("init-container", "", "Same as --container but for an init container")
+	cmd.Flags().StringArray("remove-container", nil, "Container, or init container, to remove")
+	cmd.Flags().String("environment", "", "Name of ACA environment")
+	cmd.Flags().String("workload-profile", "", "Environment workload profile to run on, '' for Consumption")
+	cmd.Flags().StringP("subscription", "s", "", "Subscription ID")
+	cmd.Flags().StringP("resource-group", "g", "", "Resource Group")
+	cmd.Flags().StringP("location", "l", "", "Location")
+	cmd.Flags().StringArrayP("env", "e", nil, "Name/value of env var")
+	cmd.Flags().StringArray("env-file", nil, "dotenv file of env vars, values can be 'secretref:SECRET'")
+	cmd.Flags().Bool("clear-env", false, "Remove the env vars that aren't set by --env, --env-file or a --bind")
+	cmd.Flags().StringArray("secret", nil, "NAME[=PARAM] secret whose value comes from the ${PARAM} parameter, case sensitive (default: NAME in upper case)")
+	cmd.Flags().StringArray("remove-secret", nil, "Secret to remove")
+	cmd.Flags().String("cpu", "", "CPU cores, e.g. 0.5, '' for the default")
+	cmd.Flags().String("memory", "", "Memory, e.g. 1Gi, '' for the default (2Gi per core)")
+	cmd.Flags().String("command", "", "Command to run, instead of the image's entrypoint, '' to remove")
+	cmd.Flags().String("args", "", "Arguments for the command, '' to remove")
+	cmd.Flags().StringArray("bind", nil, "Services to connect to")
+	cmd.Flags().StringArray("unbind", nil, "Bindings/services to disconnect")
+	cmd.Flags().String("trigger", "", "'manual', 'schedule' or 'event'")
+	cmd.Flags().String("cron", "", "Cron expression of a 'schedule' trigger")
+	cmd.Flags().StringArray("scale-rule", nil, "name=NAME,type=TYPE,KEY=VALUE,auth=PARAM:SECRET scale rule of an 'event' trigger (TYPE: cron, or a KEDA scaler), quote values with commas")
+	cmd.Flags().StringArray("remove-scale-rule", nil, "Scale rule to remove")
+	cmd.Flags().Int("parallelism", 0, "# of replicas to run per execution")
+	cmd.Flags().Int("retry-limit", 0, "# of times to retry a failed replica")
+	cmd.Flags().Int("timeout", 0, "Max # of seconds a replica can run")
+	cmd.Flags().Bool("up", false, "Provision after update")
+	cmd.MarkFlagRequired("name")
+}
+
+func setupAcaJobResourceDefs() {
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.App/jobs",
+		URL:  "https://management.azure.com/subscriptions/${SUBSCRIPTION}/resourceGroups/${RESOURCEGROUP}/providers/Microsoft.App/jobs/${NAME}?api-version=${APIVERSION}",
+		Defaults: map[string]string{
+			"APIVERSION": "2023-11-02-preview",
+			"WAIT":       "true",
+		},
+	})
+	ResourceAliases["aca-job"] = "Microsoft.App/jobs"
+}
+
+// The trigger types, as ARM spells them
+var AcaJobTriggers = []string{"Manual", "Schedule", "Event"}
+
+type AcaJobTriggerConfig struct {
+	CronExpression         *string      `json:"cronExpression,omitempty"`
+	Parallelism            *int         `json:"parallelism,omitempty"`
+	ReplicaCompletionCount *int         `json:"replicaCompletionCount,omitempty"`
+	Scale                  *AcaJobScale `json:"scale,omitempty"`
+}
+
+type AcaJobScale struct {
+	MinExecutions   *int               `json:"minExecutions,omitempty"`
+	MaxExecutions   *int               `json:"maxExecutions,omitempty"`
+	PollingInterval *int               `json:"pollingInterval,omitempty"`
+	Rules           []*AcaJobScaleRule `json:"rules,omitempty"`
+}
+
+// Jobs only use KEDA scalers so, unlike an app's, their rules are flat
+type AcaJobScaleRule struct {
+	Name     *string                `json:"name,omitempty"`
+	Type     *string                `json:"type,omitempty"`
+	Metadata map[string]string      `json:"metadata,omitempty"`
+	Auth     []*AcaAppScaleRuleAuth `json:"auth,omitempty"`
+}
+
+type AcaJobConfiguration struct {
+	TriggerType           *string              `json:"triggerType,omitempty"`
+	ReplicaTimeout        *int                 `json:"replicaTimeout,omitempty"`
+	ReplicaRetryLimit     *int                 `json:"replicaRetryLimit,omitempty"`
+	ManualTriggerConfig   *AcaJobTriggerConfig `json:"manualTriggerConfig,omitempty"`
+	ScheduleTriggerConfig *AcaJobTriggerConfig `json:"scheduleTriggerConfig,omitempty"`
+	EventTriggerConfig    *AcaJobTriggerConfig `json:"eventTriggerConfig,omitempty"`
+	Secrets               []*AcaAppSecret      `json:"secrets,omitempty"`
+	Registries            []*AcaAppRegistry    `json:"registries,omitempty"`
+}
+
+type AcaJobTemplate struct {
+	Containers     []*AcaAppContainer   `json:"containers,omitempty"`
+	InitContainers []*AcaAppContainer   `json:"initContainers,omitempty"`
+	ServiceBinds   []*AcaAppServiceBind `json:"serviceBinds,omitempty"`
+}
+
+type AcaJobProperties struct {
+	EnvironmentId       *string              `json:"environmentId,omitempty"`
+	WorkloadProfileName *string              `json:"workloadProfileName,omitempty"`
+	Configuration       *AcaJobConfiguration `json:"configuration,omitempty"`
+	Template            *AcaJobTemplate      `json:"template,omitempty"`
+}
+
+type AcaJob struct {
+	ResourceBase
+
+	Location   *string           `json:"location,omitempty"`
+	Properties *AcaJobProperties `json:"properties,omitempty"`
+}
+
+func (job *AcaJob) MarshalJSON() ([]byte, error) {
+	tmpJob := *job
+	if WhyMarshal == "ARM" {
+		if tmpJob.Location == nil {
+			tmpJob.Location = StringPtr(GetConfigProperty("defaults.Location"))
+		}
+		if tmpJob.Location == nil || *(tmpJob.Location) == "" {
+			ErrStop(`Missing "location" for "%s/%s"`, job.NiceType, job.Name)
+		}
+	}
+	return json.Marshal(tmpJob)
+}
+
+func (ajp *AcaJobProperties) MarshalJSON() ([]byte, error) {
+	type tmpType AcaJobProperties // avoid recursion
+	tmpAjp := tmpType(*ajp)
+	if WhyMarshal == "ARM" {
+		tmpAjp.EnvironmentId = StringPtr(ResolveAcaEnvironment(ajp.EnvironmentId).AsID())
+		if tmpAjp.WorkloadProfileName == nil {
+			tmpAjp.WorkloadProfileName = StringPtr("Consumption")
+		}
+		if tmpAjp.Configuration == nil {
+			tmpAjp.Configuration = &AcaJobConfiguration{}
+		}
+	}
+	return json.Marshal(tmpAjp)
+}
+
+func (ajc *AcaJobConfiguration) MarshalJSON() ([]byte, error) {
+	type tmpType AcaJobConfiguration // avoid recursion
+	tmpAjc := tmpType(*ajc)
+	if WhyMarshal == "ARM" {
+		if tmpAjc.TriggerType == nil {
+			tmpAjc.TriggerType = StringPtr("Manual")
+		}
+		if tmpAjc.ReplicaTimeout == nil {
+			tmpAjc.ReplicaTimeout = IntPtr(1800)
+		}
+
+		// Copy the trigger's config so we don't touch the original
+		tc := AcaJobTriggerConfig{}
+		field := (*AcaJobConfiguration)(&tmpAjc).triggerConfigField()
+		if *field != nil {
+			tc = **field
+		}
+		*field = &tc
+
+		if tc.Parallelism == nil {
+			tc.Parallelism = IntPtr(1)
+		}
+		if tc.ReplicaCompletionCount == nil {
+			tc.ReplicaCompletionCount = IntPtr(1)
+		}
+		switch *(tmpAjc.TriggerType) {
+		case "Schedule":
+			if NotNil(tc.CronExpression) == "" {
+				ErrStop("A 'schedule' trigger needs a cron expression, " +
+					"see '--cron'")
+			}
+		case "Event":
+			scale := AcaJobScale{}
+			if tc.Scale != nil {
+				scale = *(tc.Scale)
+			}
+			tc.Scale = &scale
+			if len(scale.Rules) == 0 {
+				ErrStop("An 'event' trigger needs a scale rule, " +
+					"see '--scale-rule'")
+			}
+			if scale.MinExecutions == nil {
+				scale.MinExecutions = IntPtr(0)
+			}
+			if scale.MaxExecutions == nil {
+				scale.MaxExecutions = IntPtr(10)
+			}
+			if scale.PollingInterval == nil {
+				scale.PollingInterval = IntPtr(30)
+			}
+		}
+	}
+	return json.Marshal(tmpAjc)
+}
+
+// Returns the field that holds the config of the job's trigger type
+func (ajc *AcaJobConfiguration) triggerConfigField() **AcaJobTriggerConfig {
+	switch NotNil(ajc.TriggerType) {
+	case "Schedule":
+		return &ajc.ScheduleTriggerConfig
+	case "Event":
+		return &ajc.EventTriggerConfig
+	}
+	return &ajc.ManualTriggerConfig
+}
+
+func (job *AcaJob) MustProperties() *AcaJobProperties {
+	if job.Properties == nil {
+		job.Properties = &AcaJobProperties{}
+	}
+	return job.Properties
+}
+
+func (job *AcaJob) MustConfiguration() *AcaJobConfiguration {
+	if props := job.MustProperties(); props.Configuration == nil {
+		props.Configuration = &AcaJobConfiguration{}
+	}
+	return job.Properties.Configuration
+}
+
+func (job *AcaJob) MustTemplate() *AcaJobTemplate {
+	if props := job.MustProperties(); props.Template == nil {
+		props.Template = &AcaJobTemplate{}
+	}
+	return job.Properties.Template
+}
+
+func (job *AcaJob) MustTriggerConfig() *AcaJobTriggerConfig {
+	field := job.MustConfiguration().triggerConfigField()
+	if *field == nil {
+		*field = &AcaJobTriggerConfig{}
+	}
+	return *field
+}
+
+func (job *AcaJob) MustContainers() *[]*AcaAppContainer {
+	return &job.MustTemplate().Containers
+}
+
+func (job *AcaJob) MustInitContainers() *[]*AcaAppContainer {
+	return &job.MustTemplate().InitContainers
+}
+
+func (job *AcaJob) MustServiceBinds() *[]*AcaAppServiceBind {
+	return &job.MustTemplate().ServiceBinds
+}
+
+func (job *AcaJob) MustSecrets() *[]*AcaAppSecret {
+	return &job.MustConfiguration().Secrets
+}
+
+func (job *AcaJob) MustScale() *AcaJobScale {
+	if tc := job.MustTriggerConfig(); tc.Scale == nil {
+		tc.Scale = &AcaJobScale{}
+	}
+	return job.MustTriggerConfig().Scale
+}
+
+// Adds, or replaces, the scale rule of an 'event' trigger described by
+// "spec", see ParseScaleRule. Only KEDA scalers can be used.
+func (job *AcaJob) SetScaleRule(spec string) {
+	appRule := ParseScaleRule(job, spec)
+	custom := appRule.Custom
+	if custom == nil {
+		ErrStop("Job scale rule %q must be a KEDA scaler, not http or tcp",
+			NotNil(appRule.Name))
+	}
+	if custom.Identity != nil {
+		ErrStop("Job scale rule %q can't use an 'identity', use 'auth' "+
+			"instead", NotNil(appRule.Name))
+	}
+	rule := &AcaJobScaleRule{
+		Name:     appRule.Name,
+		Type:     custom.Type,
+		Metadata: custom.Metadata,
+		Auth:     custom.Auth,
+	}
+
+	scale := job.MustScale()
+	for i, r := range scale.Rules {
+		if NotNil(r.Name) == NotNil(rule.Name) {
+			scale.Rules[i] = rule
+			return
+		}
+	}
+	scale.Rules = append(scale.Rules, rule)
+}
+
+func (job *AcaJob) RemoveScaleRule(name string) {
+	scale := job.MustScale()
+	for i, r := range scale.Rules {
+		if NotNil(r.Name) == name {
+			scale.Rules = append(scale.Rules[:i], scale.Rules[i+1:]...)
+			return
+		}
+	}
+	ErrStop("Scale rule %q was not found", name)
+}
+
+// Changes the trigger type, the old trigger's parallelism settings are
+// carried over to the new one
+func (job *AcaJob) SetTrigger(trigger string) {
+	config := job.MustConfiguration()
+	oldField := config.triggerConfigField()
+	oldTc := *oldField
+
+	config.TriggerType = StringPtr(trigger)
+	newField := config.triggerConfigField()
+	if newField == oldField {
+		return
+	}
+	*oldField = nil
+
+	if oldTc != nil && (oldTc.Parallelism != nil ||
+		oldTc.ReplicaCompletionCount != nil) {
+		tc := job.MustTriggerConfig()
+		tc.Parallelism = oldTc.Parallelism
+		tc.ReplicaCompletionCount = oldTc.ReplicaCompletionCount
+	}
+}
+
+func (job *AcaJob) DependsOn() []*ResourceReference {
+	refs := []*ResourceReference{}
+
+	if props := job.Properties; props != nil {
+		refs = append(refs, ResolveAcaEnvironment(props.EnvironmentId))
+
+		if template := props.Template; template != nil {
+			for _, sb := range template.ServiceBinds {
+				if sb.ServiceId != nil {
+					refs = append(refs, sb.ResolveServiceId())
+				}
+			}
+
+			// Env vars can reference other resources, e.g. ${type/name.prop}
+			refs = append(refs, ContainerSubstitutionRefs(
+				append(template.Containers, template.InitContainers...),
+				job.Subscription, job.ResourceGroup)...)
+		}
+
+		if config := props.Configuration; config != nil &&
+			config.EventTriggerConfig != nil &&
+			config.EventTriggerConfig.Scale != nil {
+			for _, rule := range config.EventTriggerConfig.Scale.Rules {
+				queueRef := ScalerQueueRef(NotNil(rule.Type), rule.Metadata,
+					job.Subscription, job.ResourceGroup)
+				if queueRef != nil {
+					refs = append(refs, queueRef)
+				}
+			}
+		}
+	}
+
+	return refs
+}
+
+func (job *AcaJob) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*ACA-Job(" + job.Name + ")"
+	form.AddProp("Name", job.Name)
+	if job.Properties != nil && NotNil(job.Properties.EnvironmentId) != "" {
+		form.AddProp("Environment", NotNil(job.Properties.EnvironmentId))
+	}
+	if NotNil(job.Location) != "" {
+		form.AddProp("Location", NotNil(job.Location))
+	}
+	form.AddProp("Subscription", job.Subscription)
+	form.AddProp("ResourceGroup", job.ResourceGroup)
+
+	if job.Properties == nil {
+		return form
+	}
+
+	if wp := job.Properties.WorkloadProfileName; wp != nil {
+		form.AddProp("Workload Profile", *wp)
+	}
+
+	if config := job.Properties.Configuration; config != nil {
+		if config.ReplicaTimeout != nil {
+			form.AddProp("Timeout", fmt.Sprintf("%d", *(config.ReplicaTimeout)))
+		}
+		if config.ReplicaRetryLimit != nil {
+			form.AddProp("Retry Limit",
+				fmt.Sprintf("%d", *(config.ReplicaRetryLimit)))
+		}
+
+		tc := *(config.triggerConfigField())
+		if config.TriggerType != nil || tc != nil {
+			nf := form.AddSection("Trigger", NotNil(config.TriggerType))
+			if tc != nil {
+				if tc.CronExpression != nil {
+					nf.AddProp("Cron", *(tc.CronExpression))
+				}
+				if tc.Parallelism != nil {
+					nf.AddProp("Parallelism", fmt.Sprintf("%d", *(tc.Parallelism)))
+				}
+				if tc.ReplicaCompletionCount != nil {
+					nf.AddProp("Completions",
+						fmt.Sprintf("%d", *(tc.ReplicaCompletionCount)))
+				}
+				if scale := tc.Scale; scale != nil {
+					if scale.MinExecutions != nil {
+						nf.AddProp("Min Executions",
+							fmt.Sprintf("%d", *(scale.MinExecutions)))
+					}
+					if scale.MaxExecutions != nil {
+						nf.AddProp("Max Executions",
+							fmt.Sprintf("%d", *(scale.MaxExecutions)))
+					}
+					if scale.PollingInterval != nil {
+						nf.AddProp("Polling Interval",
+							fmt.Sprintf("%d", *(scale.PollingInterval)))
+					}
+					if len(scale.Rules) > 0 {
+						rf := nf.AddArray("Scale Rules", "")
+						for _, rule := range scale.Rules {
+							sec := rf.AddSection("*Rule:"+NotNil(rule.Name), "")
+							sec.AddProp("Name", NotNil(rule.Name))
+							sec.AddProp("Type", NotNil(rule.Type))
+							ScaleRuleDetailsToForm(sec, rule.Metadata, rule.Auth)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if config := job.Properties.Configuration; config != nil {
+		SecretsToForm(form, config.Secrets)
+	}
+
+	if template := job.Properties.Template; template != nil {
+		ContainersToForm(form, "Containers", template.Containers)
+		ContainersToForm(form, "Init Containers", template.InitContainers)
+
+		if len(template.ServiceBinds) > 0 {
+			nf := form.AddArray("Bindings", "")
+			for _, bind := range template.ServiceBinds {
+				sec := nf.AddSection("*Service:"+NotNil(bind.ServiceId), "")
+				sec.AddProp("Service", NotNil(bind.ServiceId))
+				if bind.Name != nil {
+					sec.AddProp("Name", NotNil(bind.Name))
+				}
+			}
+		}
+	}
+
+	return form
+}
+
+func (job *AcaJob) FromForm(r *ResourceBase, f *Form) {
+	newJob := &AcaJob{
+		ResourceBase: job.ResourceBase,
+	}
+
+	atoi := func(item *Form) *int {
+		i, err := strconv.Atoi(item.Value)
+		NoErr(err, "%q must be an integer: %s", item.Title, item.Value)
+		return &i
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name":
+			// Skip
+		case "Environment":
+			newJob.MustProperties().EnvironmentId = StringPtr(item.Value)
+		case "Location":
+			newJob.Location = StringPtr(item.Value)
+		case "Subscription":
+			newJob.Subscription = item.Value
+		case "ResourceGroup":
+			newJob.ResourceGroup = item.Value
+		case "Workload Profile":
+			newJob.MustProperties().WorkloadProfileName = StringPtr(item.Value)
+		case "Timeout":
+			newJob.MustConfiguration().ReplicaTimeout = atoi(item)
+		case "Retry Limit":
+			newJob.MustConfiguration().ReplicaRetryLimit = atoi(item)
+
+		case "Trigger":
+			newJob.MustConfiguration().TriggerType = NilStringPtr(item.Value)
+			for _, prop := range item.Items {
+				tc := newJob.MustTriggerConfig()
+				switch prop.Title {
+				case "Cron":
+					tc.CronExpression = StringPtr(prop.Value)
+				case "Parallelism":
+					tc.Parallelism = atoi(prop)
+				case "Completions":
+					tc.ReplicaCompletionCount = atoi(prop)
+				case "Min Executions", "Max Executions", "Polling Interval",
+					"Scale Rules":
+					if tc.Scale == nil {
+						tc.Scale = &AcaJobScale{}
+					}
+					switch prop.Title {
+					case "Min Executions":
+						tc.Scale.MinExecutions = atoi(prop)
+					case "Max Executions":
+						tc.Scale.MaxExecutions = atoi(prop)
+					case "Polling Interval":
+						tc.Scale.PollingInterval = atoi(prop)
+					case "Scale Rules":
+						for _, ruleSec := range prop.Items {
+							metadata, auth := ScaleRuleDetailsFromForm(ruleSec)
+							tc.Scale.Rules = append(tc.Scale.Rules,
+								&AcaJobScaleRule{
+									Name:     NilStringPtr(ruleSec.GetProp("Name")),
+									Type:     NilStringPtr(ruleSec.GetProp("Type")),
+									Metadata: metadata,
+									Auth:     auth,
+								})
+						}
+					}
+				default:
+					panic("Unknown trigger item: " + prop.Title)
+				}
+			}
+
+		case "Secrets":
+			newJob.MustConfiguration().Secrets =
+				SecretsFromForm(item, *(job.MustSecrets()))
+
+		case "Containers":
+			newJob.MustTemplate().Containers = ContainersFromForm(item)
+
+		case "Init Containers":
+			newJob.MustTemplate().InitContainers = ContainersFromForm(item)
+
+		case "Bindings":
+			for _, bindSec := range item.Items {
+				newJob.MustTemplate().ServiceBinds =
+					append(newJob.MustTemplate().ServiceBinds,
+						&AcaAppServiceBind{
+							ServiceId: NilStringPtr(bindSec.GetProp("Service")),
+							Name:      NilStringPtr(bindSec.GetProp("Name")),
+						})
+			}
+
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	newJob.CheckResources()
+
+	data, _ := json.MarshalIndent(newJob, "", "  ")
+
+	r.Object = newJob
+	r.RawData = data
+}
+
+func (job *AcaJob) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(job, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (job *AcaJob) ToJson() string {
+	data, _ := json.MarshalIndent(job, "", "  ")
+	return string(data)
+}
+
+func (job *AcaJob) HideServerFields() {
+	if job.Properties != nil && job.Properties.Configuration != nil {
+		MaskServerSecrets(job.Properties.Configuration.Secrets)
+	}
+}
+
+// Azure never returns the values of secrets so just compare their names
+func (job *AcaJob) HideSecrets() {
+	if job.Properties != nil && job.Properties.Configuration != nil {
+		for _, secret := range job.Properties.Configuration.Secrets {
+			secret.Value = nil
+		}
+	}
+}
+
+func AcaJobFromARMJson(data []byte) *ResourceBase {
+	job := &AcaJob{}
+	return ParseARMResource(data, "Microsoft.App/jobs", "aca-job", job,
+		&job.ResourceBase)
+}
+
+func AddAcaJobFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddAcaJobFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddAcaJobFunc")
+
+	job := &AcaJob{}
+	name, _ := cmd.Flags().GetString("name")
+	job.InitResource(job, "Microsoft.App/jobs", "aca-job", name)
+	job.Location = StringPtr(GetConfigProperty("defaults.Location"))
+
+	job.ProcessFlags(cmd)
+	job.SaveAndUp(cmd)
+}
+
+func UpdateAcaJobFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdateAcaJobFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdateAcaJobFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	job := LoadStageResource("aca-job", name).Object.(*AcaJob)
+
+	job.ProcessFlags(cmd)
+	job.SaveAndUp(cmd)
+}
+
+func (job *AcaJob) ProcessFlags(cmd *cobra.Command) {
+	ProcessAcaEnvironmentFlag(cmd, &job.MustProperties().EnvironmentId)
+	job.ProcessResourceFlags(cmd, &job.Location)
+	ProcessContainerFlags(cmd, job)
+	ProcessWorkloadProfileFlag(cmd, job.MustProperties().EnvironmentId,
+		&job.MustProperties().WorkloadProfileName)
+
+	if cmd.Flags().Changed("trigger") {
+		trigger := FlagAsString(cmd, "trigger")
+		found := false
+		for _, t := range AcaJobTriggers {
+			if strings.EqualFold(trigger, t) {
+				job.SetTrigger(t)
+				found = true
+				break
+			}
+		}
+		if !found {
+			ErrStop("Trigger %q must be one of: %s", trigger,
+				strings.ToLower(strings.Join(AcaJobTriggers, ", ")))
+		}
+	}
+
+	if cmd.Flags().Changed("cron") {
+		cron := FlagAsString(cmd, "cron")
+		if NotNil(job.MustConfiguration().TriggerType) != "Schedule" {
+			if cmd.Flags().Changed("trigger") || cron == "" {
+				ErrStop("'--cron' is only valid on a 'schedule' trigger")
+			}
+			job.SetTrigger("Schedule")
+		}
+		job.MustTriggerConfig().CronExpression = NilStringPtr(cron)
+	}
+
+	if cmd.Flags().Changed("scale-rule") ||
+		cmd.Flags().Changed("remove-scale-rule") {
+		if NotNil(job.MustConfiguration().TriggerType) != "Event" {
+			if cmd.Flags().Changed("trigger") ||
+				!cmd.Flags().Changed("scale-rule") {
+				ErrStop("Scale rules are only valid on an 'event' trigger")
+			}
+			job.SetTrigger("Event")
+		}
+		rules, _ := cmd.Flags().GetStringArray("scale-rule")
+		for _, rule := range rules {
+			job.SetScaleRule(rule)
+		}
+		rules, _ = cmd.Flags().GetStringArray("remove-scale-rule")
+		for _, rule := range rules {
+			job.RemoveScaleRule(rule)
+		}
+	}
+
+	if cmd.Flags().Changed("parallelism") {
+		p, _ := cmd.Flags().GetInt("parallelism")
+		if p < 1 {
+			ErrStop("'--parallelism' must be at least 1")
+		}
+		job.MustTriggerConfig().Parallelism = IntPtr(p)
+	}
+
+	if cmd.Flags().Changed("retry-limit") {
+		r, _ := cmd.Flags().GetInt("retry-limit")
+		if r < 0 {
+			ErrStop("'--retry-limit' can't be negative")
+		}
+		job.MustConfiguration().ReplicaRetryLimit = IntPtr(r)
+	}
+
+	if cmd.Flags().Changed("timeout") {
+		t, _ := cmd.Flags().GetInt("timeout")
+		if t < 1 {
+			ErrStop("'--timeout' must be at least 1 second")
+		}
+		job.MustConfiguration().ReplicaTimeout = IntPtr(t)
+	}
+
+	CheckSecretRefs(job)
+	job.CheckResources()
+}
+
+func (job *AcaJob) CheckResources() {
+	if props := job.Properties; props != nil && props.Template != nil {
+		CheckResources("Job "+strconv.Quote(job.Name),
+			props.WorkloadProfileName, props.Template.Containers)
+	}
+}
+
+func StartAcaJobFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: StartAcaJobFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: StartAcaJobFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	job := LoadStageResource("aca-job", name)
+
+	resURL := fmt.Sprintf("https://management.azure.com%s/start?api-version=%s",
+		job.AsID(), job.APIVersion)
+	httpRes := doHTTP("POST", resURL, nil)
+	if httpRes.ErrorMessage != "" {
+		ErrStop("Error starting aca-job/%s: %s", name, httpRes.ErrorMessage)
+	}
+
+	execution := struct{ Name string }{}
+	json.Unmarshal(httpRes.Body, &execution)
+	if execution.Name == "" {
+		ErrStop("Error starting aca-job/%s: no execution was returned", name)
+	}
+	fmt.Printf("Started: aca-job/%s (execution: %s)\n", name, execution.Name)
+
+	resURL = fmt.Sprintf("https://management.azure.com%s/executions/%s"+
+		"?api-version=%s", job.AsID(), execution.Name, job.APIVersion)
+	status := ""
+	for {
+		httpRes = doHTTP("GET", resURL, nil)
+		if httpRes.ErrorMessage != "" {
+			ErrStop("Error getting status of execution %q: %s",
+				execution.Name, httpRes.ErrorMessage)
+		}
+
+		getData := struct {
+			Properties struct{ Status string }
+		}{}
+		err := json.Unmarshal(httpRes.Body, &getData)
+		NoErr(err, "Error parsing status of execution %q: %s\n%s",
+			execution.Name, err, string(httpRes.Body))
+
+		if getData.Properties.Status != status {
+			status = getData.Properties.Status
+			fmt.Printf("Status: %s\n", status)
+		}
+
+		switch status {
+		case "Succeeded":
+			return
+		case "Failed", "Stopped", "Degraded":
+			ErrStop("Execution %q of aca-job/%s didn't succeed: %s",
+				execution.Name, name, status)
+		}
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	log "github.com/duglin/dlog"
+	"github.com/spf13/cobra"
+)
+
+func initAcr() {
+	log.VPrintf(3, "Init initAcr")
+	setupAcrCmds()
+	setupAcrResourceDefs()
+	RegisteredParsers = append(RegisteredParsers, AcrFromARMJson)
+}
+
+func setupAcrCmds() {
+	cmd := &cobra.Command{
+		Use:   "acr",
+		Short: "Add an Azure Container Registry",
+		Run:   AddAcrFunc,
+	}
+	AddResourceFlags(cmd, "registry")
+	cmd.Flags().String("sku", "", "'Basic', 'Standard' or 'Premium'")
+	cmd.Flags().Bool("admin-enabled", false, "Enable the admin user")
+	AddCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:   "acr",
+		Short: "Update an Azure Container Registry",
+		Run:   UpdateAcrFunc,
+	}
+	AddResourceFlags(cmd, "registry")
+	cmd.Flags().String("sku", "", "'Basic', 'Standard' or 'Premium'")
+	cmd.Flags().Bool("admin-enabled", false, "Enable the admin user")
+	UpdateCmd.AddCommand(cmd)
+
+	AddShowCmd("acr", "Show details about an Azure Container Registry",
+		"registry")
+}
+
+func setupAcrResourceDefs() {
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.ContainerRegistry/registries",
+		URL:  "https://management.azure.com/subscriptions/${SUBSCRIPTION}/resourceGroups/${RESOURCEGROUP}/providers/Microsoft.ContainerRegistry/registries/${NAME}?api-version=${APIVERSION}",
+		Defaults: map[string]string{
+			"APIVERSION": "2023-07-01",
+			"WAIT":       "true",
+		},
+		Actions: map[string]string{
+			"credentials": "listCredentials",
+		},
+	})
+	ResourceAliases["acr"] = "Microsoft.ContainerRegistry/registries"
+}
+
+// Converts an ACR name, or login server, into its login server
+func AcrServer(nameOrServer string) string {
+	if strings.Contains(nameOrServer, ".") {
+		return nameOrServer
+	}
+	return strings.ToLower(nameOrServer) + ".azurecr.io"
+}
+
+// Returns the name of the ACR for a login server, or "" if it's not an ACR
+func AcrNameFromServer(server string) string {
+	name, found := strings.CutSuffix(strings.ToLower(server), ".azurecr.io")
+	if !found {
+		return ""
+	}
+	return name
+}
+
+type AcrSku struct {
+	Name *string `json:"name,omitempty"`
+}
+
+type AcrProperties struct {
+	AdminUserEnabled *bool `json:"adminUserEnabled,omitempty"`
+}
+
+type Acr struct {
+	ResourceBase
+
+	Location   *string        `json:"location,omitempty"`
+	Sku        *AcrSku        `json:"sku,omitempty"`
+	Properties *AcrProperties `json:"properties,omitempty"`
+}
+
+func (acr *Acr) MarshalJSON() ([]byte, error) {
+	tmpAcr := *acr
+	if WhyMarshal == "ARM" {
+		if tmpAcr.Location == nil {
+			tmpAcr.Location = StringPtr(GetConfigProperty("defaults.Location"))
+		}
+		if tmpAcr.Location == nil || *(tmpAcr.Location) == "" {
+			ErrStop(`Missing "location" for "%s/%s"`, acr.NiceType, acr.Name)
+		}
+		if tmpAcr.Sku == nil || tmpAcr.Sku.Name == nil {
+			tmpAcr.Sku = &AcrSku{Name: StringPtr("Basic")}
+		}
+	}
+	return json.Marshal(tmpAcr)
+}
+
+func (acr *Acr) MustProperties() *AcrProperties {
+	if acr.Properties == nil {
+		acr.Properties = &AcrProperties{}
+	}
+	return acr.Properties
+}
+
+func (acr *Acr) DependsOn() []*ResourceReference {
+	return []*ResourceReference{}
+}
+
+func (acr *Acr) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*ACR(" + acr.Name + ")"
+	form.AddProp("Name", acr.Name)
+	if NotNil(acr.Location) != "" {
+		form.AddProp("Location", NotNil(acr.Location))
+	}
+	form.AddProp("Subscription", acr.Subscription)
+	form.AddProp("ResourceGroup", acr.ResourceGroup)
+	if acr.Sku != nil && acr.Sku.Name != nil {
+		form.AddProp("SKU", *(acr.Sku.Name))
+	}
+	if acr.Properties != nil && acr.Properties.AdminUserEnabled != nil {
+		form.AddProp("Admin User", fmt.Sprintf("%v",
+			*(acr.Properties.AdminUserEnabled)))
+	}
+
+	return form
+}
+
+func (acr *Acr) FromForm(r *ResourceBase, f *Form) {
+	newAcr := &Acr{
+		ResourceBase: acr.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name":
+			// Skip
+		case "Location":
+			newAcr.Location = StringPtr(item.Value)
+		case "Subscription":
+			newAcr.Subscription = item.Value
+		case "ResourceGroup":
+			newAcr.ResourceGroup = item.Value
+		case "SKU":
+			newAcr.Sku = &AcrSku{Name: StringPtr(item.Value)}
+		case "Admin User":
+			newAcr.MustProperties().AdminUserEnabled =
+				BoolPtr(item.Value == "true")
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newAcr, "", "  ")
+
+	r.Object = newAcr
+	r.RawData = data
+}
+
+func (acr *Acr) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(acr, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (acr *Acr) ToJson() string {
+	data, _ := json.MarshalIndent(acr, "", "  ")
+	return string(data)
+}
+
+func (acr *Acr) HideServerFields() {
+	// Azure always returns the admin flag, even if it was never set
+	if acr.Properties != nil && acr.Properties.AdminUserEnabled != nil &&
+		*(acr.Properties.AdminUserEnabled) == false {
+		acr.Properties.AdminUserEnabled = nil
+	}
+	if acr.Properties != nil && (*acr.Properties == AcrProperties{}) {
+		acr.Properties = nil
+	}
+}
+
+func AcrFromARMJson(data []byte) *ResourceBase {
+	acr := &Acr{}
+	return ParseARMResource(data, "Microsoft.ContainerRegistry/registries",
+		"acr", acr, &acr.ResourceBase)
+}
+
+func AddAcrFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddAcrFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddAcrFunc")
+
+	acr := &Acr{}
+	name, _ := cmd.Flags().GetString("name")
+	acr.InitResource(acr, "Microsoft.ContainerRegistry/registries", "acr", name)
+	acr.Location = StringPtr(GetConfigProperty("defaults.Location"))
+
+	acr.ProcessFlags(cmd)
+	acr.SaveAndUp(cmd)
+}
+
+func UpdateAcrFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdateAcrFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdateAcrFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	acr := LoadStageResource("acr", name).Object.(*Acr)
+
+	acr.ProcessFlags(cmd)
+	acr.SaveAndUp(cmd)
+}
+
+func (acr *Acr) ProcessFlags(cmd *cobra.Command) {
+	acr.ProcessResourceFlags(cmd, &acr.Location)
+
+	SetStringProp(acr, cmd.Flags(), "sku", `{"sku":{"name":%s}}`)
+
+	if cmd.Flags().Changed("admin-enabled") {
+		val, _ := cmd.Flags().GetBool("admin-enabled")
+		acr.MustProperties().AdminUserEnabled = BoolPtr(val)
+	}
+}
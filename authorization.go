@@ -0,0 +1,480 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/duglin/dlog"
+	"github.com/spf13/cobra"
+)
+
+func initAuthorization() {
+	log.VPrintf(3, "Init initAuthorization")
+	setupAuthorizationCmds()
+	setupAuthorizationResourceDefs()
+	RegisteredParsers = append(RegisteredParsers, AuthorizationFromARMJson)
+}
+
+func setupAuthorizationCmds() {
+	cmd := &cobra.Command{
+		Use:   "role-assignment",
+		Short: "Add a role assignment to a resource",
+		Run:   AddRoleAssignmentFunc,
+	}
+	AddScopedResourceFlags(cmd, "role assignment (default is generated)")
+	cmd.Flags().String("role", "", "Role name (e.g. 'AcrPull'), or ID")
+	cmd.Flags().String("principal", "", "Principal (TYPE/NAME, or object ID)")
+	cmd.Flags().String("principal-type", "", "e.g. 'ServicePrincipal', 'User', 'Group'")
+	cmd.MarkFlagRequired("scope")
+	cmd.MarkFlagRequired("role")
+	cmd.MarkFlagRequired("principal")
+	AddCmd.AddCommand(cmd)
+
+	AddShowCmd("role-assignment", "Show details about a role assignment",
+		"role assignment")
+
+	// ---
+
+	cmd = &cobra.Command{
+		Use:   "lock",
+		Short: "Add a lock to a resource",
+		Run:   AddLockFunc,
+	}
+	AddScopedResourceFlags(cmd, "lock")
+	cmd.Flags().String("level", "", "'CanNotDelete' (default) or 'ReadOnly'")
+	cmd.Flags().String("notes", "", "Why the lock is there")
+	cmd.MarkFlagRequired("name")
+	cmd.MarkFlagRequired("scope")
+	AddCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:   "lock",
+		Short: "Update a lock",
+		Run:   UpdateLockFunc,
+	}
+	cmd.Flags().StringP("name", "n", "", "Name of lock")
+	cmd.Flags().String("scope", "", "Resource the lock is on, if the name isn't unique")
+	cmd.Flags().String("level", "", "'CanNotDelete' or 'ReadOnly'")
+	cmd.Flags().String("notes", "", "Why the lock is there")
+	cmd.Flags().Bool("up", false, "Provision after update")
+	cmd.MarkFlagRequired("name")
+	UpdateCmd.AddCommand(cmd)
+
+	AddShowCmd("lock", "Show details about a lock", "lock").Flags().String(
+		"scope", "", "Resource the lock is on, if the name isn't unique")
+}
+
+func setupAuthorizationResourceDefs() {
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.Authorization/roleAssignments",
+		Defaults: map[string]string{
+			"APIVERSION": "2022-04-01",
+		},
+	})
+	ResourceAliases["role-assignment"] = "Microsoft.Authorization/roleAssignments"
+
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.Authorization/locks",
+		Defaults: map[string]string{
+			"APIVERSION": "2020-05-01",
+		},
+	})
+	ResourceAliases["lock"] = "Microsoft.Authorization/locks"
+}
+
+// The built-in roles that can be referenced by name
+var BuiltInRoles = map[string]string{
+	"Owner":                         "8e3af657-a8ff-443c-a75c-2fe8c4bcb635",
+	"Contributor":                   "b24988ac-6180-42a0-ab88-20f7382dd24c",
+	"Reader":                        "acdd72a7-3385-48ef-bd42-f606fba81ae7",
+	"AcrPull":                       "7f951dda-4ed3-4680-a7ca-43fe172d538d",
+	"AcrPush":                       "8311e382-0749-4cb8-b61a-304f252e45ec",
+	"Key Vault Secrets User":        "4633458b-17de-408a-b874-0445c86b69e6",
+	"Key Vault Secrets Officer":     "b86a8fe4-44ce-4948-aee5-eccb2c155cd7",
+	"Storage Blob Data Reader":      "2a2b9908-6ea1-4ae2-8e65-a410df84e7d1",
+	"Storage Blob Data Contributor": "ba92f5b4-2d11-453d-a403-e96b0029c9fe",
+	"Storage File Data SMB Share Contributor": "0c867c2a-1d8c-454a-a3db-ab2ea1bdc8bb",
+	"Azure Service Bus Data Receiver":         "4f6d3b9b-027b-4f4c-9142-0e5a2a2247e0",
+	"Azure Service Bus Data Sender":           "69a216fc-b8fb-44d8-bc22-1f3c2cd27a39",
+	"Cognitive Services OpenAI User":          "5e0bd9bd-7b93-4f28-af87-19fc36ad61bd",
+	"Monitoring Metrics Publisher":            "3913510d-42f4-4e42-8a64-420c390055eb",
+}
+
+// Converts a role name, GUID or ID into the role's definition ID
+func RoleDefinitionID(sub string, role string) string {
+	if strings.HasPrefix(role, "/") {
+		return role
+	}
+
+	guid := ""
+	if len(role) == 36 && strings.Count(role, "-") == 4 {
+		guid = role
+	}
+	for name, id := range BuiltInRoles {
+		if strings.EqualFold(name, role) {
+			guid = id
+		}
+	}
+	if guid == "" {
+		names := []string{}
+		for name := range BuiltInRoles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		ErrStop("Unknown role %q, use its ID or one of: %s", role,
+			strings.Join(names, ", "))
+	}
+
+	return "/subscriptions/" + sub +
+		"/providers/Microsoft.Authorization/roleDefinitions/" + guid
+}
+
+// Returns the name of the built-in role, or the ID if it's not one
+func RoleName(roleID string) string {
+	guid := roleID[strings.LastIndex(roleID, "/")+1:]
+	for name, id := range BuiltInRoles {
+		if strings.EqualFold(id, guid) {
+			return name
+		}
+	}
+	return roleID
+}
+
+// The resource types that can be used as a "--principal", and where to find
+// their principal ID
+var PrincipalProps = map[string]struct{ Type, Prop string }{
+	"identity": {"Microsoft.ManagedIdentity/userAssignedIdentities", "properties.principalId"},
+	"aca-app":  {"Microsoft.App/containerApps", "identity.principalId"},
+}
+
+// Converts a "--principal" value, which is an object ID or the TYPE/NAME of
+// a resource with a managed identity, into the value to store in the
+// principalId. For resources it's a ${...} reference that's resolved at ARM
+// time since the ID isn't known until the resource is created.
+func ResolvePrincipal(principal string) string {
+	niceType, name, found := strings.Cut(principal, "/")
+	if !found {
+		return principal
+	}
+
+	pp, ok := PrincipalProps[niceType]
+	if !ok {
+		types := []string{}
+		for t := range PrincipalProps {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		ErrStop("Principal %q must be an ID or one of: %s/NAME", principal,
+			strings.Join(types, "/NAME, "))
+	}
+
+	resRef := &ResourceReference{
+		Subscription:  GetConfigProperty("defaults.Subscription"),
+		ResourceGroup: GetConfigProperty("defaults.ResourceGroup"),
+		Type:          pp.Type,
+		Name:          name,
+	}
+
+	// If it's in the stage then use its sub/rg
+	stage := GetConfigProperty("currentStage")
+	if res, err := ResourceFromFile(stage, ResourceFileName(niceType, name)); err == nil {
+		resRef.Subscription = res.Subscription
+		resRef.ResourceGroup = res.ResourceGroup
+	}
+
+	return resRef.AsSubstitution(pp.Prop)
+}
+
+// The reverse of ResolvePrincipal, used to show it to the user
+func PrincipalName(principalId string) string {
+	for _, ref := range SubstitutionRefs(principalId, "", "") {
+		for niceType, pp := range PrincipalProps {
+			if strings.EqualFold(pp.Type, ref.Type) {
+				return niceType + "/" + ref.Name
+			}
+		}
+	}
+	return principalId
+}
+
+type RoleAssignmentProperties struct {
+	RoleDefinitionId *string `json:"roleDefinitionId,omitempty"`
+	PrincipalId      *string `json:"principalId,omitempty"`
+	PrincipalType    *string `json:"principalType,omitempty"`
+}
+
+type RoleAssignment struct {
+	ResourceBase
+
+	Properties *RoleAssignmentProperties `json:"properties,omitempty"`
+}
+
+func (ra *RoleAssignment) MustProperties() *RoleAssignmentProperties {
+	if ra.Properties == nil {
+		ra.Properties = &RoleAssignmentProperties{}
+	}
+	return ra.Properties
+}
+
+func (ra *RoleAssignment) DependsOn() []*ResourceReference {
+	refs := []*ResourceReference{}
+	if scopeRef := ra.ScopeRef(); scopeRef != nil {
+		refs = append(refs, scopeRef)
+	}
+	if ra.Properties != nil {
+		refs = append(refs, SubstitutionRefs(NotNil(ra.Properties.PrincipalId),
+			ra.Subscription, ra.ResourceGroup)...)
+	}
+	return refs
+}
+
+func (ra *RoleAssignment) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*RoleAssignment(" + ra.Name + ")"
+	form.AddProp("Name", ra.Name)
+	form.AddProp("Scope", ra.Scope)
+	if props := ra.Properties; props != nil {
+		if props.RoleDefinitionId != nil {
+			form.AddProp("Role", RoleName(*(props.RoleDefinitionId)))
+		}
+		if props.PrincipalId != nil {
+			form.AddProp("Principal", PrincipalName(*(props.PrincipalId)))
+		}
+		if props.PrincipalType != nil {
+			form.AddProp("Principal Type", *(props.PrincipalType))
+		}
+	}
+
+	return form
+}
+
+func (ra *RoleAssignment) FromForm(r *ResourceBase, f *Form) {
+	newRa := &RoleAssignment{
+		ResourceBase: ra.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name", "Scope":
+			// Skip
+		case "Role":
+			newRa.MustProperties().RoleDefinitionId = StringPtr(
+				RoleDefinitionID(newRa.Subscription, item.Value))
+		case "Principal":
+			newRa.MustProperties().PrincipalId =
+				StringPtr(ResolvePrincipal(item.Value))
+		case "Principal Type":
+			newRa.MustProperties().PrincipalType = StringPtr(item.Value)
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newRa, "", "  ")
+
+	r.Object = newRa
+	r.RawData = data
+}
+
+func (ra *RoleAssignment) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(ra, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (ra *RoleAssignment) ToJson() string {
+	data, _ := json.MarshalIndent(ra, "", "  ")
+	return string(data)
+}
+
+func (ra *RoleAssignment) HideServerFields() {
+}
+
+type LockProperties struct {
+	Level *string `json:"level,omitempty"`
+	Notes *string `json:"notes,omitempty"`
+}
+
+type Lock struct {
+	ResourceBase
+
+	Properties *LockProperties `json:"properties,omitempty"`
+}
+
+func (lock *Lock) MarshalJSON() ([]byte, error) {
+	tmpLock := *lock
+	if WhyMarshal == "ARM" {
+		if lock.Properties == nil || lock.Properties.Level == nil {
+			// Copy it so we don't touch the original
+			props := LockProperties{}
+			if lock.Properties != nil {
+				props = *(lock.Properties)
+			}
+			props.Level = StringPtr("CanNotDelete")
+			tmpLock.Properties = &props
+		}
+	}
+	return json.Marshal(tmpLock)
+}
+
+func (lock *Lock) MustProperties() *LockProperties {
+	if lock.Properties == nil {
+		lock.Properties = &LockProperties{}
+	}
+	return lock.Properties
+}
+
+func (lock *Lock) DependsOn() []*ResourceReference {
+	refs := []*ResourceReference{}
+	if scopeRef := lock.ScopeRef(); scopeRef != nil {
+		refs = append(refs, scopeRef)
+	}
+	return refs
+}
+
+func (lock *Lock) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*Lock(" + lock.Name + ")"
+	form.AddProp("Name", lock.Name)
+	if lock.Scope != "" {
+		form.AddProp("Scope", lock.Scope)
+	}
+	if props := lock.Properties; props != nil {
+		if props.Level != nil {
+			form.AddProp("Level", *(props.Level))
+		}
+		if props.Notes != nil {
+			form.AddProp("Notes", *(props.Notes))
+		}
+	}
+
+	return form
+}
+
+func (lock *Lock) FromForm(r *ResourceBase, f *Form) {
+	newLock := &Lock{
+		ResourceBase: lock.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name", "Scope":
+			// Skip
+		case "Level":
+			newLock.MustProperties().Level = StringPtr(item.Value)
+		case "Notes":
+			newLock.MustProperties().Notes = StringPtr(item.Value)
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newLock, "", "  ")
+
+	r.Object = newLock
+	r.RawData = data
+}
+
+func (lock *Lock) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(lock, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (lock *Lock) ToJson() string {
+	data, _ := json.MarshalIndent(lock, "", "  ")
+	return string(data)
+}
+
+func (lock *Lock) HideServerFields() {
+}
+
+func AuthorizationFromARMJson(data []byte) *ResourceBase {
+	ra := &RoleAssignment{}
+	if res := ParseARMResource(data, "Microsoft.Authorization/roleAssignments",
+		"role-assignment", ra, &ra.ResourceBase); res != nil {
+		return res
+	}
+
+	lock := &Lock{}
+	return ParseARMResource(data, "Microsoft.Authorization/locks", "lock",
+		lock, &lock.ResourceBase)
+}
+
+func AddRoleAssignmentFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddRoleAssignmentFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddRoleAssignmentFunc")
+
+	scopeRef := ResolveScope(FlagAsString(cmd, "scope"))
+	roleID := RoleDefinitionID(scopeRef.Subscription, FlagAsString(cmd, "role"))
+	principal := FlagAsString(cmd, "principal")
+	principalId := ResolvePrincipal(principal)
+
+	// Role assignment names must be UUIDs, so generate a stable one
+	name := FlagAsString(cmd, "name")
+	if name == "" {
+		name = NameUUID(scopeRef.AsID(), roleID, principalId)
+		fmt.Printf("Added role-assignment/%s\n", name)
+	}
+
+	ra := &RoleAssignment{}
+	ra.InitScopedResource(ra, "Microsoft.Authorization/roleAssignments",
+		"role-assignment", name, scopeRef)
+
+	props := ra.MustProperties()
+	props.RoleDefinitionId = StringPtr(roleID)
+	props.PrincipalId = StringPtr(principalId)
+	if cmd.Flags().Changed("principal-type") {
+		props.PrincipalType = NilStringPtr(FlagAsString(cmd, "principal-type"))
+	} else if principalId != principal {
+		// Managed identities, this avoids replication delay errors
+		props.PrincipalType = StringPtr("ServicePrincipal")
+	}
+
+	ra.SaveAndUp(cmd)
+}
+
+func AddLockFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddLockFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddLockFunc")
+
+	scopeRef := ResolveScope(FlagAsString(cmd, "scope"))
+
+	lock := &Lock{}
+	lock.InitScopedResource(lock, "Microsoft.Authorization/locks", "lock",
+		FlagAsString(cmd, "name"), scopeRef)
+	lock.Filename = ScopedResourceFileName("lock", scopeRef, lock.Name)
+
+	lock.ProcessFlags(cmd)
+	lock.SaveAndUp(cmd)
+}
+
+func UpdateLockFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdateLockFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdateLockFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	res := FindScopedResource("lock", name, FlagAsString(cmd, "scope"))
+	if res == nil {
+		ErrStop("Resource lock/%s not found", name)
+	}
+	lock := res.Object.(*Lock)
+
+	lock.ProcessFlags(cmd)
+	lock.SaveAndUp(cmd)
+}
+
+func (lock *Lock) ProcessFlags(cmd *cobra.Command) {
+	SetStringProp(lock, cmd.Flags(), "level",
+		`{"properties":{"level":%s}}`)
+
+	if cmd.Flags().Changed("notes") {
+		lock.MustProperties().Notes = NilStringPtr(FlagAsString(cmd, "notes"))
+	}
+}
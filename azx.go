@@ -131,6 +131,7 @@ func setupRootCmds() *cobra.Command {
 		Run:   DeprovisionFunc,
 	}
 	downCmd.Flags().BoolP("wait", "w", false, "Wait for resources to vanish")
+	downCmd.Flags().BoolP("all", "a", false, "Include resource groups")
 	RootCmd.AddCommand(downCmd)
 
 	diffCmd := &cobra.Command{
@@ -202,20 +203,31 @@ var ResourceAliases = map[string]string{
 
 type ResourceDef struct {
 	Type     string
-	URL      string
+	URL      string // If "" then it's calculated from the resource's ID
 	Defaults map[string]string
+
+	// ${type/name.prop} values that aren't part of the resource's GET.
+	// Maps the first part of "prop" to the action to POST to, e.g.
+	// "credentials" -> "listCredentials"
+	Actions map[string]string
 }
 
 var ResourceDefs = map[string]*ResourceDef{
-	"ResourceGroup": &ResourceDef{
-		Type: "ResourceGroup",
-		URL:  "https://management.azure.com/subscriptions/${SUBSCRIPTION}/resourcegroups/${NAME}?api-version=${APIVERSION}",
+	"microsoft.resources/subscriptions": &ResourceDef{
+		Type: "Microsoft.Resources/subscriptions",
+		URL:  "https://management.azure.com/subscriptions/${NAME}?api-version=${APIVERSION}",
 		Defaults: map[string]string{
-			"APIVERSION": "2021-04-01",
+			"APIVERSION": "2022-12-01",
 		},
 	},
 }
 
+// Returns a ${...} reference to the tenant ID of the subscription. It's
+// resolved just before the resource is sent to Azure.
+func TenantIdSubstitution(sub string) string {
+	return "${Microsoft.Resources/subscriptions/" + sub + ".tenantId}"
+}
+
 func AddResourceDef(def *ResourceDef) {
 	ResourceDefs[strings.ToLower(def.Type)] = def
 }
@@ -241,38 +253,62 @@ type ResourceReference struct {
 	Name          string
 	Property      string
 
+	// Only set for extension resources (e.g. role assignments), it's the
+	// ID of the resource they're attached to
+	Scope string
+
 	Origin string // ref(string) used to parse/populate values (for errs)
 }
 
 func (rr *ResourceReference) AsID() string {
-	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/%s/%s",
-		rr.Subscription, rr.ResourceGroup, rr.Type, rr.Name)
+	if rr.Scope != "" {
+		return rr.Scope + "/providers/" + rr.TypeAndName()
+	}
+	if IsResourceGroup(rr.Type) {
+		return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s",
+			rr.Subscription, rr.Name)
+	}
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/%s",
+		rr.Subscription, rr.ResourceGroup, rr.TypeAndName())
+}
+
+// Child resources have types like "ns/parent/child" and names like
+// "pName/cName", so they need to be merged: "ns/parent/pName/child/cName"
+func (rr *ResourceReference) TypeAndName() string {
+	types := strings.Split(rr.Type, "/")
+	names := strings.Split(rr.Name, "/")
+	if len(types)-1 != len(names) {
+		return rr.Type + "/" + rr.Name
+	}
+
+	res := types[0]
+	for i, name := range names {
+		res += "/" + types[i+1] + "/" + name
+	}
+	return res
 }
 
 func (rr *ResourceReference) AsURL() string {
-	return fmt.Sprintf("https://management.azure.com/subscriptions/%s/resourceGroups/%s/providers/%s/%s?api-version=%s",
-		rr.Subscription, rr.ResourceGroup, rr.Type, rr.Name, rr.APIVersion)
+	return fmt.Sprintf("https://management.azure.com%s?api-version=%s",
+		rr.AsID(), rr.APIVersion)
+}
+
+// Returns a "${sub:rg:type/name.prop}" string that is replaced with the
+// resource's property's value just before the ARM json is sent to Azure
+func (rr *ResourceReference) AsSubstitution(prop string) string {
+	return fmt.Sprintf("${%s:%s:%s/%s.%s}", rr.Subscription, rr.ResourceGroup,
+		rr.Type, rr.Name, prop)
 }
 
 func (rr *ResourceReference) Populate(ref string) {
 	if strings.HasPrefix(ref, "/subscriptions/") {
-		// subscriptions/xx/resourceGroups/xx/providers/xx/type/name
-		//  0   1       2         3      4     5  6     7
-		ref = strings.TrimLeft(ref, "/")
-		parts := strings.Split(ref, "/")
-
-		if len(parts) != 8 || parts[0] != "subscriptions" ||
-			parts[2] != "resourceGroups" || parts[4] != "providers" {
-
-			ErrStop("Reference %q isn't well formed, should be of "+
-				"the form: /subscriptions/??/resourceGroups/??/providers/??/"+
-				"??/NAME", ref)
-		}
-		rr.Subscription = parts[1]
-		rr.ResourceGroup = parts[3]
-		rr.Type = parts[5] + "/" + parts[6]
-		rr.Name = parts[7]
-		rr.Origin = ref
+		prr := parseIDParts(ref)
+		rr.Subscription = prr.Subscription
+		rr.ResourceGroup = prr.ResourceGroup
+		rr.Type = prr.Type
+		rr.Name = prr.Name
+		rr.Scope = prr.Scope
+		rr.Origin = prr.Origin
 		return
 	}
 
@@ -315,13 +351,45 @@ func ParseResourceReference(ref string) *ResourceReference {
 }
 
 func ParseResourceID(ref string) *ResourceReference {
-	// /subscriptions/xx/resourceGroups/xx/providers/xx/type/name
+	rr := parseIDParts(ref)
+	rr.APIVersion = GetResourceDef(rr.Type).Defaults["APIVERSION"]
+	return rr
+}
+
+func parseIDParts(ref string) *ResourceReference {
+	// Extension resources: SCOPE-ID/providers/xx/type/name
+	lower := strings.ToLower(ref)
+	if i := strings.LastIndex(lower, "/providers/"); i > 0 &&
+		strings.Contains(lower[:i], "/providers/") {
+		scope := "/" + strings.Trim(ref[:i], "/")
+		scopeRR := parseIDParts(scope)
+		rr := parseIDParts("/subscriptions/" + scopeRR.Subscription +
+			"/resourceGroups/" + scopeRR.ResourceGroup + ref[i:])
+		rr.Scope = scope
+		rr.Origin = ref
+		return rr
+	}
+
+	// /subscriptions/xx/resourceGroups/xx/providers/xx/type/name[/type/name]*
 	//         0      1       2         3      4     5  6     7
 	ref = strings.TrimLeft(ref, "/")
 	parts := strings.Split(ref, "/")
 
-	if len(parts) != 8 || parts[0] != "subscriptions" ||
-		parts[2] != "resourceGroups" || parts[4] != "providers" {
+	// /subscriptions/xx/resourceGroups/xx
+	if len(parts) == 4 && parts[0] == "subscriptions" &&
+		strings.EqualFold(parts[2], "resourceGroups") {
+		return &ResourceReference{
+			Subscription:  parts[1],
+			ResourceGroup: parts[3],
+			Type:          ResourceGroupType,
+			Name:          parts[3],
+			Origin:        ref,
+		}
+	}
+
+	if len(parts) < 8 || len(parts)%2 != 0 || parts[0] != "subscriptions" ||
+		!strings.EqualFold(parts[2], "resourceGroups") ||
+		parts[4] != "providers" {
 
 		ErrStop("Reference %q isn't well formed, should be of "+
 			"the form: /subscriptions/??/resourceGroups/??/providers/??/"+
@@ -331,9 +399,14 @@ func ParseResourceID(ref string) *ResourceReference {
 
 	rr.Subscription = parts[1]
 	rr.ResourceGroup = parts[3]
-	rr.Type = parts[5] + "/" + parts[6]
-	rr.Name = parts[7]
-	rr.APIVersion = GetResourceDef(rr.Type).Defaults["APIVERSION"]
+	rr.Type = parts[5]
+	for i := 6; i < len(parts); i += 2 {
+		rr.Type += "/" + parts[i]
+		if rr.Name != "" {
+			rr.Name += "/"
+		}
+		rr.Name += parts[i+1]
+	}
 	rr.Origin = ref
 
 	return rr
@@ -367,10 +440,79 @@ func init() {
 // Sub recursive history
 var history = map[string]bool{}
 
+// ${[[[[sub:]rg:]type[@apiVer]/]]name[.prop]}
+var subsRE = regexp.MustCompile(`\${(?:(?:(?:([^:}]*):)?([^:}]*):)?([^@}]+)?(?:@([^/}]*))?/)?([^\.}]+)(?:\.([^}]+))?}`)
+
+// Returns the resources that the "${type/name.prop}" references in "str"
+// point to. "sub" and "rg" are used when the reference doesn't include them.
+func SubstitutionRefs(str string, sub string, rg string) []*ResourceReference {
+	refs := []*ResourceReference{}
+	for _, match := range subsRE.FindAllStringSubmatch(str, -1) {
+		if match[3] == "" {
+			continue // Simple ${NAME}
+		}
+		res := GetResourceDef(match[3])
+		rr := &ResourceReference{
+			Subscription:  match[1],
+			ResourceGroup: match[2],
+			Type:          res.Type,
+			APIVersion:    match[4],
+			Name:          match[5],
+			Property:      match[6],
+			Origin:        match[0],
+		}
+		if rr.Subscription == "" {
+			rr.Subscription = sub
+		}
+		if rr.ResourceGroup == "" {
+			rr.ResourceGroup = rg
+		}
+		if rr.APIVersion == "" {
+			rr.APIVersion = res.Defaults["APIVERSION"]
+		}
+		refs = append(refs, rr)
+	}
+	return refs
+}
+
+// The fields, per resource type, that can hold a "${NAME}" parameter (see
+// ParamValue). They're json paths, e.g. "properties.secrets[].value", where
+// "[]" is any array element. A "${NAME}" anywhere else is left as is.
+var ParamFields = map[string][]string{}
+
+// A value that is just a "${NAME}" parameter
+var paramRE = regexp.MustCompile(`^\${([A-Za-z_][A-Za-z0-9_]*)}$`)
+
+// Returns the value of a "${NAME}" parameter that isn't one of the built-in
+// props, e.g. a password, so that they're never saved in the stage files.
+// They come from an env var or, if that's not set, from the stage's
+// secrets file (.azx/secrets_STAGE) and then the shared one (.azx/secrets).
+// Those files are NAME=VALUE lines and must not be checked in.
+func ParamValue(name string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+
+	if fi := GetConfigDir(); fi != nil {
+		stage := GetConfigProperty("currentStage")
+		for _, file := range []string{"secrets_" + stage, "secrets"} {
+			data, err := os.ReadFile(path.Join(fi.Name(), file))
+			if err != nil {
+				continue
+			}
+			if value, ok := ParseDotEnv(data)[name]; ok {
+				return value
+			}
+		}
+	}
+
+	ErrStop("Missing a value for \"${%s}\", set the %q env var or add it "+
+		"to .%s/secrets", name, name, APP)
+	return ""
+}
+
 func newDoSubs(str string, props map[string]string) string {
-	// ${[[[[sub:]rg:]type[@apiVer]/]]name[.prop]}
-	re := regexp.MustCompile(`\${(?:(?:(?:(.*):)?(.*):)?([^@}]+)?(?:@([^/}]*))?/)?([^\.}]+)(?:\.([^}]+))?}`)
-	indexes := re.FindAllStringSubmatchIndex(str, -1)
+	indexes := subsRE.FindAllStringSubmatchIndex(str, -1)
 	nextIndex := 0
 	pos := 0
 	result := strings.Builder{}
@@ -411,49 +553,98 @@ func newDoSubs(str string, props map[string]string) string {
 
 			result.WriteString(value)
 		} else {
-			res := GetResourceDef(resType)
-			if apiVer == "" {
-				apiVer = res.Defaults["APIVERSION"]
-				if apiVer == "" {
-					ErrStop("Can't determine apiVersion for %q", resType)
-				}
+			if sub == "" {
+				sub = props["SUBSCRIPTION"]
+			}
+			if rg == "" {
+				rg = props["RESOURCEGROUP"]
 			}
+			result.WriteString(RefValue(sub, rg, resType, apiVer, resName, prop))
+		}
 
-			data, err := downloadResource(props["SUBSCRIPTION"],
-				props["RESOURCEGROUP"], res.Type, resName, apiVer)
-			NoErr(err, "Error downloading resource(%s/%s): %s", res.Type,
-				resName, err)
+		nextIndex++
+	}
 
-			if data == nil {
-				ErrStop("Resource '%s/%s'  was not found", res.Type, resName)
-			}
+	log.VPrintf(4, "<SUB NEW: %s", result.String())
 
-			log.VPrintf(4, "Res json: %s", string(data))
+	return result.String()
+}
 
-			log.VPrintf(4, "Prop: .%s", prop)
-			query, err := gojq.Parse("." + prop)
-			NoErr(err, "Error in prop(%s): %s", prop, err)
+// Returns the value of the "prop" property of a resource, as referenced by
+// a "${sub:rg:type@apiVer/name.prop}" string
+func RefValue(sub, rg, resType, apiVer, resName, prop string) string {
+	res := GetResourceDef(resType)
+	if apiVer == "" {
+		apiVer = res.Defaults["APIVERSION"]
+		if apiVer == "" {
+			ErrStop("Can't determine apiVersion for %q", resType)
+		}
+	}
 
-			daJson := map[string]any{}
-			err = json.Unmarshal(data, &daJson)
-			NoErr(err, "Error in parsing resource: %s", err)
+	var data []byte
+	var err error
 
-			iter := query.Run(daJson)
-			value, ok := iter.Next()
-			if !ok {
-				ErrStop("Can't find value for %q", prop)
-			}
-			log.VPrintf(4, "Value: %s", value)
+	root, rest, _ := strings.Cut(prop, ".")
+	if action := res.Actions[root]; action != "" {
+		data, err = postResourceAction(sub, rg, res.Type, resName,
+			apiVer, action)
+		prop = rest
+	} else {
+		data, err = downloadResource(sub, rg, res.Type, resName, apiVer)
+	}
+	NoErr(err, "Error downloading resource(%s/%s): %s", res.Type,
+		resName, err)
 
-			// result.WriteString(fmt.Sprintf("%s/%s.%s", res.Type, resName, prop))
-			result.WriteString(fmt.Sprintf("%v", value))
-		}
+	if data == nil {
+		ErrStop("Resource '%s/%s'  was not found", res.Type, resName)
+	}
 
-		nextIndex++
+	log.VPrintf(4, "Res json: %s", string(data))
+
+	log.VPrintf(4, "Prop: .%s", prop)
+	query, err := gojq.Parse("." + prop)
+	NoErr(err, "Error in prop(%s): %s", prop, err)
+
+	daJson := map[string]any{}
+	err = json.Unmarshal(data, &daJson)
+	NoErr(err, "Error in parsing resource: %s", err)
+
+	iter := query.Run(daJson)
+	value, ok := iter.Next()
+	if !ok {
+		ErrStop("Can't find value for %q", prop)
 	}
+	log.VPrintf(4, "Value: %s", value)
 
-	log.VPrintf(4, "<SUB NEW: %s", result.String())
+	return fmt.Sprintf("%v", value)
+}
 
+// Replaces the "${type/name.prop}" references in "str" with their values.
+// Unlike newDoSubs, simple "${NAME}"s are left as is and the values aren't
+// checked for more references.
+func ResolveRefs(str string, sub string, rg string) string {
+	result := strings.Builder{}
+	pos := 0
+	for _, index := range subsRE.FindAllStringSubmatchIndex(str, -1) {
+		resType := extract(str, index[6], index[7])
+		if resType == "" {
+			continue // Simple ${NAME}
+		}
+		refSub := extract(str, index[2], index[3])
+		if refSub == "" {
+			refSub = sub
+		}
+		refRg := extract(str, index[4], index[5])
+		if refRg == "" {
+			refRg = rg
+		}
+		result.WriteString(str[pos:index[0]])
+		result.WriteString(RefValue(refSub, refRg, resType,
+			extract(str, index[8], index[9]), extract(str, index[10], index[11]),
+			extract(str, index[12], index[13])))
+		pos = index[1]
+	}
+	result.WriteString(str[pos:])
 	return result.String()
 }
 
@@ -470,13 +661,35 @@ func downloadResource(sub, rg, resType, resName, api string) ([]byte, error) {
 
 	log.VPrintf(2, "Download: %s/%s/%s/%s@%s", sub, rg, resType, resName, api)
 	res := GetResourceDef(resType)
-	props := map[string]string{
-		"SUBSCRIPTION":  sub,
-		"RESOURCEGROUP": rg,
-		"APIVERSION":    api,
-		"NAME":          resName,
+	resURL := ""
+	if res.URL == "" {
+		rr := &ResourceReference{
+			Subscription:  sub,
+			ResourceGroup: rg,
+			Type:          res.Type,
+			Name:          resName,
+			APIVersion:    api,
+		}
+		resURL = rr.AsURL()
+	} else {
+		props := map[string]string{
+			"SUBSCRIPTION":  sub,
+			"RESOURCEGROUP": rg,
+			"APIVERSION":    api,
+			"NAME":          resName,
+		}
+		resURL = newDoSubs(res.URL, props)
 	}
-	resURL := newDoSubs(res.URL, props)
+
+	return downloadURL(resURL)
+}
+
+// GETs a resource by its full URL, which is needed for extension resources
+// since they live under their scope rather than a resource group.
+// Returns nil (and no error) if it doesn't exist
+func downloadURL(resURL string) ([]byte, error) {
+	log.VPrintf(2, ">Enter: downloadURL(%s)", resURL)
+	defer log.VPrintf(2, "<Exit: downloadURL")
 
 	httpRes := doHTTP("GET", resURL, nil)
 	if httpRes.StatusCode == 404 {
@@ -484,12 +697,71 @@ func downloadResource(sub, rg, resType, resName, api string) ([]byte, error) {
 	}
 
 	if httpRes.ErrorMessage != "" {
-		return nil, fmt.Errorf(httpRes.ErrorMessage)
+		return nil, errors.New(httpRes.ErrorMessage)
+	}
+
+	return httpRes.Body, nil
+}
+
+func postResourceAction(sub, rg, resType, resName, api, action string) ([]byte, error) {
+	log.VPrintf(2, ">Enter: postResourceAction(%s/%s/%s/%s/%s?%s)", sub, rg,
+		resType, resName, action, api)
+	defer log.VPrintf(2, "<Exit: postResourceAction")
+
+	rr := &ResourceReference{
+		Subscription:  sub,
+		ResourceGroup: rg,
+		Type:          resType,
+		Name:          resName,
+		APIVersion:    api,
+	}
+	resURL := fmt.Sprintf("https://management.azure.com%s/%s?api-version=%s",
+		rr.AsID(), action, api)
+
+	httpRes := doHTTP("POST", resURL, nil)
+	if httpRes.StatusCode == 404 {
+		return nil, nil
+	}
+
+	if httpRes.ErrorMessage != "" {
+		return nil, errors.New(httpRes.ErrorMessage)
 	}
 
 	return httpRes.Body, nil
 }
 
+// If "data" is a resource of type "resType" then parse it into "obj",
+// whose ResourceBase is "rb", otherwise return nil. Used by the per-type
+// ARMParsers.
+func ParseARMResource(data []byte, resType string, niceType string, obj ARMResource, rb *ResourceBase) *ResourceBase {
+	tmp := struct{ ID string }{}
+	err := json.Unmarshal(data, &tmp)
+	NoErr(err, "Error parsing resource: %s", err)
+
+	resRef := ParseResourceID(tmp.ID)
+	if !strings.EqualFold(resRef.Type, resType) {
+		return nil
+	}
+
+	err = json.Unmarshal(data, obj)
+	NoErr(err, "Error parsing %s: %s", niceType, err)
+
+	// ResourceBase stuff
+	rb.Subscription = resRef.Subscription
+	rb.ResourceGroup = resRef.ResourceGroup
+	rb.Type = resType
+	rb.Name = resRef.Name
+	rb.APIVersion = resRef.APIVersion
+	rb.Scope = resRef.Scope
+	rb.NiceType = niceType
+
+	rb.ID = tmp.ID
+	rb.Object = obj
+	rb.RawData = data
+
+	return rb
+}
+
 func ResourceFromFile(stage string, name string) (*ResourceBase, error) {
 	data, err := ReadStageFile(stage, name)
 	if err != nil {
@@ -527,6 +799,12 @@ func WriteStageFile(stage string, name string, data []byte) error {
 	return os.WriteFile(file, data, 0644)
 }
 
+func DeleteStageFile(stage string, name string) error {
+	fi := GetConfigDir()
+	file := path.Join(fi.Name(), "stage_"+stage, name)
+	return os.Remove(file)
+}
+
 func GenerateConfigFileName(stage string, name string) string {
 	fi := GetConfigDir()
 	return path.Join(fi.Name(), "stage_"+stage, name)
@@ -806,7 +1084,7 @@ func BuildDependencyTree(resources map[string]*ResourceBase, findDeps bool) *Dep
 		controlResources = GetStageResources("")
 	} else {
 		for _, r := range resources {
-			controlResources[r.AsID()] = r
+			controlResources[strings.ToLower(r.AsID())] = r
 		}
 	}
 
@@ -822,7 +1100,7 @@ func BuildDependencyTree(resources map[string]*ResourceBase, findDeps bool) *Dep
 		checkDepList = checkDepList[1:]
 
 		// Skip resource if we already did it
-		if nodes[res.AsID()] != nil {
+		if nodes[strings.ToLower(res.AsID())] != nil {
 			continue
 		}
 
@@ -894,8 +1172,7 @@ func ProvisionFunc(cmd *cobra.Command, args []string) {
 		}
 
 		for _, arg := range args {
-			argTmp := strings.ReplaceAll(arg, "/", "-")
-			res, err := ResourceFromFile(stage, argTmp+".json")
+			res, err := ResourceFromArg(stage, arg)
 			NoErr(err, "Error reading %q: %s", arg, err)
 			resources[res.AsID()] = res
 		}
@@ -907,12 +1184,24 @@ func ProvisionFunc(cmd *cobra.Command, args []string) {
 	if doDep {
 		dTree := BuildDependencyTree(resources, doDep)
 
+		list := []*ResourceBase{}
+		for _, level := range *dTree {
+			list = append(list, level...)
+		}
+		CheckResourceGroups(list)
+
 		for _, level := range *dTree {
 			for _, res := range level {
 				res.Provision()
 			}
 		}
 	} else {
+		list := []*ResourceBase{}
+		for _, res := range resources {
+			list = append(list, res)
+		}
+		CheckResourceGroups(list)
+
 		for _, res := range resources {
 			res.Provision()
 		}
@@ -925,6 +1214,7 @@ func DeprovisionFunc(cmd *cobra.Command, args []string) {
 
 	resources := map[string]*ResourceBase{}
 	wait, _ := cmd.Flags().GetBool("wait")
+	all, _ := cmd.Flags().GetBool("all")
 
 	if len(args) > 0 {
 		stage := GetConfigProperty("currentStage")
@@ -933,18 +1223,35 @@ func DeprovisionFunc(cmd *cobra.Command, args []string) {
 		}
 
 		for _, arg := range args {
-			argTmp := strings.ReplaceAll(arg, "/", "-")
-			res, err := ResourceFromFile(stage, argTmp+".json")
+			res, err := ResourceFromArg(stage, arg)
 			NoErr(err, "Error reading %q: %s", arg, err)
 			resources[res.AsID()] = res
 		}
 	} else {
 		resources = GetStageResources("")
+
+		// Deleting a resource group deletes everything in it, so only
+		// do it when asked to
+		if !all {
+			for id, res := range resources {
+				if IsResourceGroup(res.Type) {
+					delete(resources, id)
+				}
+			}
+		}
 	}
 
 	// TODO Should order the list given based on dependencies
+	// Resource groups go last since they hold everything else
 	for _, res := range resources {
-		res.Deprovision()
+		if !IsResourceGroup(res.Type) {
+			res.Deprovision()
+		}
+	}
+	for _, res := range resources {
+		if IsResourceGroup(res.Type) {
+			res.Deprovision()
+		}
 	}
 
 	// TODO wait on a per level basis
@@ -990,8 +1297,7 @@ func diffOrSync(output string, sync bool, all bool, args []string) {
 
 	if len(args) == 1 {
 		arg := args[0]
-		argTmp := strings.ReplaceAll(arg, "/", "-")
-		res, err := ResourceFromFile(stage, argTmp+".json")
+		res, err := ResourceFromArg(stage, arg)
 		NoErr(err, "Error reading %q: %s", arg, err)
 
 		if output == "pretty" {
@@ -1065,6 +1371,172 @@ func ListFunc(cmd *cobra.Command, args []string) {
 func ResourceAddFunc(cmd *cobra.Command, args []string) {
 }
 
+// Flags that all "add" and "update" resource commands support
+func AddResourceFlags(cmd *cobra.Command, noun string) {
+	cmd.Flags().StringP("name", "n", "", "Name of "+noun)
+	cmd.Flags().StringP("subscription", "s", "", "Subscription ID")
+	cmd.Flags().StringP("resource-group", "g", "", "Resource Group")
+	cmd.Flags().StringP("location", "l", "", "Location")
+	cmd.Flags().Bool("up", false, "Provision after update")
+	cmd.MarkFlagRequired("name")
+}
+
+// Same as AddResourceFlags but for extension resources, whose sub/rg come
+// from the resource they're attached to (the "--scope")
+func AddScopedResourceFlags(cmd *cobra.Command, noun string) {
+	cmd.Flags().StringP("name", "n", "", "Name of "+noun)
+	cmd.Flags().String("scope", "", "Resource to attach to (TYPE/NAME, or ID)")
+	cmd.Flags().Bool("up", false, "Provision after update")
+}
+
+func AddShowCmd(use string, short string, noun string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   use,
+		Short: short,
+		Run:   ShowFunc,
+	}
+	cmd.Flags().StringP("name", "n", "", "Name of "+noun)
+	cmd.Flags().String("from", "iac", "Show data from: iac, rest, azure")
+	cmd.Flags().StringP("output", "o", "pretty", "Format (pretty,json)")
+	cmd.MarkFlagRequired("name")
+	ShowCmd.AddCommand(cmd)
+	return cmd
+}
+
+// Processes the flags added by AddResourceFlags. "location" is nil for
+// resources that don't have one.
+func (r *ResourceBase) ProcessResourceFlags(cmd *cobra.Command, location **string) {
+	if cmd.Flags().Changed("subscription") {
+		sub, _ := cmd.Flags().GetString("subscription")
+		if sub == "" {
+			sub = GetConfigProperty("defaults.Subscription")
+		}
+		r.Subscription = sub
+		r.ID = r.AsID()
+	}
+
+	if cmd.Flags().Changed("resource-group") {
+		rg, _ := cmd.Flags().GetString("resource-group")
+		if rg == "" {
+			rg = GetConfigProperty("defaults.ResourceGroup")
+		}
+		r.ResourceGroup = rg
+		r.ID = r.AsID()
+	}
+
+	if location != nil && cmd.Flags().Changed("location") {
+		loc, _ := cmd.Flags().GetString("location")
+		if loc == "" {
+			loc = GetConfigProperty("defaults.Location")
+		}
+		*location = &loc
+	}
+}
+
+// Sets up the ResourceBase of a brand new resource using the defaults
+func (r *ResourceBase) InitResource(obj ARMResource, resType string, niceType string, name string) {
+	r.Object = obj
+	r.Subscription = GetConfigProperty("defaults.Subscription")
+	r.ResourceGroup = GetConfigProperty("defaults.ResourceGroup")
+	r.Type = resType
+	r.Name = name
+	r.APIVersion = GetResourceDef(resType).Defaults["APIVERSION"]
+	r.NiceType = niceType
+
+	r.Stage = GetConfigProperty("currentStage")
+	r.Filename = ResourceFileName(niceType, name)
+}
+
+func ResourceFileName(niceType string, name string) string {
+	return fmt.Sprintf("%s-%s.json", niceType, strings.ReplaceAll(name, "/", "-"))
+}
+
+// Extension resources, like locks, can have the same name on different
+// scopes so their file names include the scope's type and name, e.g.
+// "lock-containerapps-myapp-NAME.json"
+func ScopedResourceFileName(niceType string, scope *ResourceReference, name string) string {
+	scopeType := strings.ToLower(path.Base(scope.Type))
+	return ResourceFileName(niceType, scopeType+"/"+scope.Name+"/"+name)
+}
+
+// Returns the stage's "niceType" extension resource called "name", or nil.
+// "scope" (see ResolveScope) is only needed if there's more than one.
+func FindScopedResource(niceType string, name string, scope string) *ResourceBase {
+	scopeID := ""
+	if scope != "" {
+		scopeID = ResolveScope(scope).AsID()
+	}
+
+	found := []*ResourceBase{}
+	for _, res := range GetStageResources("") {
+		if res.NiceType != niceType || res.Name != name {
+			continue
+		}
+		if scopeID != "" && !strings.EqualFold(res.Scope, scopeID) {
+			continue
+		}
+		found = append(found, res)
+	}
+
+	if len(found) > 1 {
+		scopes := []string{}
+		for _, res := range found {
+			scopes = append(scopes, res.Scope)
+		}
+		sort.Strings(scopes)
+		ErrStop("There's more than one %s/%s, use '--scope' to pick one "+
+			"of:\n  %s", niceType, name, strings.Join(scopes, "\n  "))
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	return found[0]
+}
+
+// Loads the stage resource "arg" (TYPE/NAME). If there's no such file then
+// it's looked for as an extension resource (see ScopedResourceFileName).
+func ResourceFromArg(stage string, arg string) (*ResourceBase, error) {
+	res, err := ResourceFromFile(stage, strings.ReplaceAll(arg, "/", "-")+".json")
+	if err == nil {
+		return res, nil
+	}
+	if niceType, name, found := strings.Cut(arg, "/"); found {
+		if res := FindScopedResource(niceType, name, ""); res != nil {
+			return res, nil
+		}
+	}
+	return nil, err
+}
+
+// Child resources are named "parent/child". If "name" doesn't include the
+// parent then use "defParent", if there is one.
+func ChildName(name string, defParent string, what string) string {
+	if strings.Contains(name, "/") {
+		return name
+	}
+	if defParent == "" {
+		ErrStop("Name %q must be of the form: %s/NAME", name, what)
+	}
+	return defParent + "/" + name
+}
+
+func LoadStageResource(niceType string, name string) *ResourceBase {
+	stage := GetConfigProperty("currentStage")
+	res, err := ResourceFromFile(stage, ResourceFileName(niceType, name))
+	NoErr(err, "Resource %s/%s not found", niceType, name)
+	return res
+}
+
+// Save the resource and provision it if asked to
+func (r *ResourceBase) SaveAndUp(cmd *cobra.Command) {
+	r.Save()
+
+	p, _ := cmd.Flags().GetBool("up")
+	if p || GetConfigProperty("defaults.up") == "true" {
+		r.Provision()
+	}
+}
+
 type ResourceBase struct {
 	ID string `json:"id,omitempty"`
 
@@ -1074,9 +1546,11 @@ type ResourceBase struct {
 	Name          string `json:"-"`
 	APIVersion    string `json:"-"`
 	NiceType      string `json:"-"`
+	Scope         string `json:"-"` // ID of parent, only for extension types
 
-	Stage    string `json:"-"`
-	Filename string `json:"-"`
+	Stage     string `json:"-"`
+	Filename  string `json:"-"`
+	FromAzure bool   `json:"-"` // Downloaded rather than from the stage
 
 	Object  ARMResource `json:"-"` // Basically "self". Owning ARM Object
 	RawData []byte      `json:"-"`
@@ -1091,6 +1565,18 @@ type ARMResource interface {
 	FromForm(*ResourceBase, *Form) // converts Form to Azure Json
 }
 
+// Resources whose ARM json can hold secret values implement this so those
+// values can be removed before anything is shown to the user
+type SecretHider interface {
+	HideSecrets()
+}
+
+// Resources that can't be provisioned with a single PUT implement this. It's
+// called instead of Put() and has to do all of the steps itself.
+type StepProvisioner interface {
+	ProvisionSteps(r *ResourceBase)
+}
+
 func (r *ResourceBase) AsRef() *ResourceReference {
 	return &ResourceReference{
 		Subscription:  r.Subscription,
@@ -1101,7 +1587,15 @@ func (r *ResourceBase) AsRef() *ResourceReference {
 	}
 }
 
-func (r *ResourceBase) DependsOn() []*ResourceReference { return r.Object.DependsOn() }
+// Everything depends on its resource group, so that if the resource group
+// is in the stage it's created first
+func (r *ResourceBase) DependsOn() []*ResourceReference {
+	deps := r.Object.DependsOn()
+	if !IsResourceGroup(r.Type) && r.ResourceGroup != "" {
+		deps = append(deps, ResourceGroupRef(r.Subscription, r.ResourceGroup))
+	}
+	return deps
+}
 
 func (r *ResourceBase) ToJson() string    { return r.Object.ToJson() }
 func (r *ResourceBase) ToARMJson() string { return r.Object.ToARMJson() }
@@ -1109,6 +1603,63 @@ func (r *ResourceBase) HideServerFields() { r.Object.HideServerFields() }
 func (r *ResourceBase) ToForm() *Form     { return r.Object.ToForm() }
 func (r *ResourceBase) FromForm(f *Form)  { r.Object.FromForm(r, f) }
 
+// Returns the resource's ARM json with its "${type/name.prop}" references
+// replaced by their values. If "params" is set then the "${NAME}" values of
+// the resource's ParamFields are replaced too, otherwise they're left as is
+// (e.g. for diffs, so that we don't need the secrets). Each string in the
+// json is resolved on its own and then json encoded again, so no value can
+// change the json's structure. With "params" this is what is sent to Azure
+// so it can include secrets - never save it or show it.
+func (r *ResourceBase) ResolvedARMJson(params bool) string {
+	var obj any
+	dec := json.NewDecoder(strings.NewReader(r.ToARMJson()))
+	dec.UseNumber()
+	err := dec.Decode(&obj)
+	NoErr(err, "Error parsing the ARM json of %s/%s: %s", r.NiceType, r.Name,
+		err)
+
+	fields := map[string]bool{}
+	for resType, list := range ParamFields {
+		if strings.EqualFold(resType, r.Type) { // Types aren't always cased
+			for _, field := range list {
+				fields[field] = true
+			}
+		}
+	}
+
+	var resolve func(obj any, path string) any
+	resolve = func(obj any, path string) any {
+		switch val := obj.(type) {
+		case map[string]any:
+			for key, v := range val {
+				val[key] = resolve(v, strings.TrimPrefix(path+"."+key, "."))
+			}
+		case []any:
+			for i, v := range val {
+				val[i] = resolve(v, path+"[]")
+			}
+		case string:
+			if match := paramRE.FindStringSubmatch(val); match != nil {
+				if fields[path] && params {
+					return ParamValue(match[1])
+				}
+				return val
+			}
+			return ResolveRefs(val, r.Subscription, r.ResourceGroup)
+		}
+		return obj
+	}
+
+	data, _ := json.MarshalIndent(resolve(obj, ""), "", "  ")
+	return string(data)
+}
+
+func (r *ResourceBase) HideSecrets() {
+	if h, ok := r.Object.(SecretHider); ok {
+		h.HideSecrets()
+	}
+}
+
 func (r *ResourceBase) AsID() string {
 	rr := ResourceReference{
 		Subscription:  r.Subscription,
@@ -1116,6 +1667,7 @@ func (r *ResourceBase) AsID() string {
 		Type:          r.Type,
 		Name:          r.Name,
 		APIVersion:    r.APIVersion,
+		Scope:         r.Scope,
 	}
 	return rr.AsID()
 }
@@ -1127,10 +1679,50 @@ func (r *ResourceBase) AsURL() string {
 		Type:          r.Type,
 		Name:          r.Name,
 		APIVersion:    r.APIVersion,
+		Scope:         r.Scope,
 	}
 	return rr.AsURL()
 }
 
+// Converts a "--scope" value, which is either an ID or the "TYPE/NAME" of
+// a resource in the stage, into a reference to that resource
+func ResolveScope(scope string) *ResourceReference {
+	if strings.HasPrefix(scope, "/subscriptions/") {
+		// Any resource can be a scope, not just the types we know about
+		return parseIDParts(scope)
+	}
+
+	niceType, name, found := strings.Cut(scope, "/")
+	if !found || niceType == "" || name == "" {
+		ErrStop("Scope %q must be an ID or of the form: TYPE/NAME", scope)
+	}
+	stage := GetConfigProperty("currentStage")
+	res, err := ResourceFromFile(stage, ResourceFileName(niceType, name))
+	if err != nil {
+		ErrStop("Scope %q isn't in the stage, use its resource ID instead",
+			scope)
+	}
+	return parseIDParts(res.AsID())
+}
+
+// Sets up the ResourceBase of a new extension resource, it lives in the
+// same sub/rg as the resource it's attached to
+func (r *ResourceBase) InitScopedResource(obj ARMResource, resType string, niceType string, name string, scope *ResourceReference) {
+	r.InitResource(obj, resType, niceType, name)
+	r.Subscription = scope.Subscription
+	r.ResourceGroup = scope.ResourceGroup
+	r.Scope = scope.AsID()
+}
+
+// For extension resources, returns a reference to the resource they're
+// attached to, so they can be ordered after it
+func (r *ResourceBase) ScopeRef() *ResourceReference {
+	if r.Scope == "" {
+		return nil
+	}
+	return parseIDParts(r.Scope)
+}
+
 func (r *ResourceBase) Save() {
 	log.VPrintf(2, ">Enter: Save")
 	defer log.VPrintf(2, "<Enter: Save")
@@ -1173,24 +1765,49 @@ func (r *ResourceBase) Provision() {
 	log.VPrintf(2, ">Enter: RB:Provision (%s)", r.NiceType+"/"+r.Name)
 	defer log.VPrintf(2, "<Exit: RB:Provision")
 
+	fmt.Printf("Provision: %s/%s\n", r.NiceType, r.Name)
+	if sp, ok := r.Object.(StepProvisioner); ok {
+		sp.ProvisionSteps(r)
+	} else {
+		r.Put()
+	}
+}
+
+// Sends the resource's ARM json to Azure and, if its type needs it, waits
+// for it to be done
+func (r *ResourceBase) Put() {
 	data := r.ToARMJson()
 	resURL := r.AsURL()
 	resDef := GetResourceDef(r.Type)
 
-	fmt.Printf("Provision: %s/%s\n", r.NiceType, r.Name)
 	log.VPrintf(2, "URL: %s", resURL)
-	httpRes := doHTTP("PUT", resURL, []byte(data))
+	httpRes := doHTTP("PUT", resURL, []byte(r.ResolvedARMJson(true)))
 	if httpRes.ErrorMessage != "" {
 		ErrStop("Error adding %s/%s: %s\n\n%s", r.NiceType, r.Name,
 			httpRes.ErrorMessage, data)
 	}
 
 	if resDef.Defaults["WAIT"] == "true" {
+		// Most resources show their progress via "provisioningState", the
+		// others say which property to check and which values mean that
+		// it's still busy, and that it worked
+		stateProp := resDef.Defaults["WAITPROP"]
+		if stateProp == "" {
+			stateProp = "provisioningState"
+		}
+		busyStates := strings.Split(resDef.Defaults["WAITBUSY"], ",")
+		if resDef.Defaults["WAITBUSY"] == "" {
+			busyStates = []string{"InProgress"}
+		}
+		doneState := resDef.Defaults["WAITDONE"]
+		if doneState == "" {
+			doneState = "Succeeded"
+		}
+
 		// fmt.Printf("Waiting\n")
 		state := ""
 		for {
-			data, err := downloadResource(r.Subscription, r.ResourceGroup,
-				r.Type, r.Name, r.APIVersion)
+			data, err := downloadURL(resURL)
 			NoErr(err, "Error getting status of %s/%s: %s",
 				r.NiceType, r.Name, err)
 
@@ -1202,14 +1819,18 @@ func (r *ResourceBase) Provision() {
 			NoErr(err, "Error parsing response adding %s/%s: %s\n%s",
 				r.NiceType, r.Name, err, string(data))
 
-			log.VPrintf(2, "State: %s", getData.Properties["provisioningState"])
-			state = getData.Properties["provisioningState"].(string)
-			if state != "InProgress" {
+			log.VPrintf(2, "State: %s", getData.Properties[stateProp])
+			state, _ = getData.Properties[stateProp].(string)
+			busy := false
+			for _, busyState := range busyStates {
+				busy = busy || state == busyState
+			}
+			if !busy {
 				break
 			}
 			time.Sleep(time.Second)
 		}
-		if state != "Succeeded" {
+		if state != doneState {
 			ErrStop("Error provisioning %s/%s", r.NiceType, r.Name)
 		}
 	}
@@ -1248,19 +1869,12 @@ func (r *ResourceBase) Download() ([]byte, error) {
 	log.VPrintf(2, ">Enter: RB:Download (%s)", r.NiceType+"/"+r.Name)
 	defer log.VPrintf(2, "<Exit: RB:Download")
 
-	resURL := r.AsURL()
-
-	log.VPrintf(2, "URL: %s", resURL)
-
-	data, err := downloadResource(r.Subscription, r.ResourceGroup,
-		r.Type, r.Name, r.APIVersion)
-
-	return data, err
+	return downloadURL(r.AsURL())
 }
 
 func (r *ResourceBase) GetARMResource() *ResourceBase {
 	tmp := map[string]json.RawMessage{}
-	json.Unmarshal([]byte(r.ToARMJson()), &tmp)
+	json.Unmarshal([]byte(r.ResolvedARMJson(false)), &tmp)
 	buf, _ := json.Marshal(tmp)
 	res, err := ResourceFromBytes(r.Stage, r.NiceType+"/"+r.Name, buf)
 	if err != nil {
@@ -1286,6 +1900,7 @@ func (r *ResourceBase) GetAzureResource() *ResourceBase {
 	if strings.EqualFold(r.ID, azure.ID) {
 		azure.ID = r.ID
 	}
+	azure.FromAzure = true
 	return azure
 }
 
@@ -1293,7 +1908,11 @@ func (r *ResourceBase) Diff(sync bool, all bool) { // (string, error) {
 	log.VPrintf(2, ">Enter: RB:Diff (%s)", r.NiceType+"/"+r.Name)
 	defer log.VPrintf(2, "<Exit: RB:Diff")
 
-	armForm := r.GetARMResource().ToForm()
+	// Both sides get the same normalizing since the ARM json can have
+	// defaults added to it that HideServerFields() removes from Azure's
+	local := r.GetARMResource()
+	local.HideServerFields()
+	armForm := local.ToForm()
 	originalArmForm := armForm.Clone()
 
 	azure := r.GetAzureResource()
@@ -1333,7 +1952,7 @@ func (r *ResourceBase) JsonDiff() (string, error) {
 
 	// Save it as ARM Json and then covert it back into a ResourceBase
 	tmp := map[string]json.RawMessage{}
-	json.Unmarshal([]byte(r.ToARMJson()), &tmp)
+	json.Unmarshal([]byte(r.ResolvedARMJson(false)), &tmp)
 	buf, _ := json.Marshal(tmp)
 	res, err := ResourceFromBytes(r.Stage, r.NiceType+"/"+r.Name, buf)
 	if err != nil {
@@ -1354,8 +1973,12 @@ func (r *ResourceBase) JsonDiff() (string, error) {
 	if strings.EqualFold(res.ID, azure.ID) {
 		azure.ID = res.ID
 	}
+	res.HideServerFields()
 	azure.HideServerFields()
 
+	res.HideSecrets()
+	azure.HideSecrets()
+
 	srcJson, _ := json.MarshalIndent(res.Object, "", "  ")
 	tgtJson, _ := json.MarshalIndent(azure.Object, "", "  ")
 
@@ -1495,7 +2118,7 @@ func doHTTP(verb string, URL string, data []byte) *HTTPResponse {
 				msg = e
 			}
 		} else {
-			fmt.Println("%s\n%s", err, string(body))
+			fmt.Printf("%s\n%s\n", err, string(body))
 			// Can't pretty print, so just dump it
 			msg = fmt.Sprintf("Error: %s\n%s", res.Status, string(str))
 		}
@@ -1517,7 +2140,16 @@ func ShowFunc(cmd *cobra.Command, args []string) {
 	var err error
 	from, _ := cmd.Flags().GetString("from")
 
-	fileName := fmt.Sprintf("%s-%s.json", cmd.CalledAs(), name)
+	fileName := ResourceFileName(cmd.CalledAs(), name)
+	if cmd.Flags().Lookup("scope") != nil {
+		// Extension resources, their file names include their scope
+		res := FindScopedResource(cmd.CalledAs(), name,
+			FlagAsString(cmd, "scope"))
+		if res == nil {
+			ErrStop("Resource %s/%s not found", cmd.CalledAs(), name)
+		}
+		fileName = res.Filename
+	}
 	data, err = ReadStageFile(stage, fileName)
 	NoErr(err, "Error reading resource file \"%s/%s\": %s", cmd.CalledAs(),
 		name, err)
@@ -1555,6 +2187,7 @@ func ShowFunc(cmd *cobra.Command, args []string) {
 
 	// Must be "pretty"
 	res, err = ResourceFromBytes(stage, name, data)
+	res.FromAzure = (from == "azure")
 	form := res.ToForm()
 	// form.Dump()
 	fmt.Printf("%s", form.ToString())
@@ -1563,7 +2196,21 @@ func ShowFunc(cmd *cobra.Command, args []string) {
 func main() {
 	RootCmd = setupRootCmds()
 	initAca()
+	initAcaJob()
 	initRedis()
+	initAcr()
+	initStorage()
+	initAcaEnv()
+	initKeyVault()
+	initLogAnalytics()
+	initIdentity()
+	initAuthorization()
+	initDiagnostics()
+	initResourceGroup()
+	initServiceBus()
+	initPostgres()
+	initOpenAI()
+	initDns()
 
 	if err := RootCmd.Execute(); err != nil {
 		ErrStop(err.Error())
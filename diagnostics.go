@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	log "github.com/duglin/dlog"
+	"github.com/spf13/cobra"
+)
+
+func initDiagnostics() {
+	log.VPrintf(3, "Init initDiagnostics")
+	setupDiagnosticsCmds()
+	setupDiagnosticsResourceDefs()
+	RegisteredParsers = append(RegisteredParsers, DiagnosticsFromARMJson)
+}
+
+func setupDiagnosticsCmds() {
+	cmd := &cobra.Command{
+		Use:   "diagnostic-setting",
+		Short: "Add a diagnostic setting to a resource",
+		Run:   AddDiagnosticSettingFunc,
+	}
+	AddScopedResourceFlags(cmd, "diagnostic setting")
+	cmd.Flags().String("workspace", "", "Log Analytics workspace to send to")
+	cmd.MarkFlagRequired("name")
+	cmd.MarkFlagRequired("scope")
+	cmd.MarkFlagRequired("workspace")
+	AddCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:   "diagnostic-setting",
+		Short: "Update a diagnostic setting",
+		Run:   UpdateDiagnosticSettingFunc,
+	}
+	cmd.Flags().StringP("name", "n", "", "Name of diagnostic setting")
+	cmd.Flags().String("scope", "", "Resource the setting is on, if the name isn't unique")
+	cmd.Flags().String("workspace", "", "Log Analytics workspace to send to")
+	cmd.Flags().Bool("up", false, "Provision after update")
+	cmd.MarkFlagRequired("name")
+	UpdateCmd.AddCommand(cmd)
+
+	AddShowCmd("diagnostic-setting", "Show details about a diagnostic setting",
+		"diagnostic setting").Flags().String("scope", "",
+		"Resource the setting is on, if the name isn't unique")
+}
+
+func setupDiagnosticsResourceDefs() {
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.Insights/diagnosticSettings",
+		Defaults: map[string]string{
+			"APIVERSION": "2021-05-01-preview",
+		},
+	})
+	ResourceAliases["diagnostic-setting"] = "Microsoft.Insights/diagnosticSettings"
+}
+
+type DiagnosticLog struct {
+	Category      *string `json:"category,omitempty"`
+	CategoryGroup *string `json:"categoryGroup,omitempty"`
+	Enabled       *bool   `json:"enabled,omitempty"`
+}
+
+type DiagnosticMetric struct {
+	Category *string `json:"category,omitempty"`
+	Enabled  *bool   `json:"enabled,omitempty"`
+}
+
+type DiagnosticSettingProperties struct {
+	WorkspaceId *string             `json:"workspaceId,omitempty"`
+	Logs        []*DiagnosticLog    `json:"logs,omitempty"`
+	Metrics     []*DiagnosticMetric `json:"metrics,omitempty"`
+}
+
+type DiagnosticSetting struct {
+	ResourceBase
+
+	Properties *DiagnosticSettingProperties `json:"properties,omitempty"`
+}
+
+func (ds *DiagnosticSetting) MarshalJSON() ([]byte, error) {
+	tmpDs := *ds
+	if WhyMarshal == "ARM" {
+		// Copy it so we don't touch the original
+		props := DiagnosticSettingProperties{}
+		if ds.Properties != nil {
+			props = *(ds.Properties)
+		}
+		tmpDs.Properties = &props
+
+		if wsRef := ds.ResolveWorkspace(); wsRef != nil {
+			props.WorkspaceId = StringPtr(wsRef.AsID())
+		}
+		if len(props.Logs) == 0 && len(props.Metrics) == 0 {
+			props.Logs = []*DiagnosticLog{{
+				CategoryGroup: StringPtr("allLogs"),
+				Enabled:       BoolPtr(true),
+			}}
+			props.Metrics = []*DiagnosticMetric{{
+				Category: StringPtr("AllMetrics"),
+				Enabled:  BoolPtr(true),
+			}}
+		}
+	}
+	return json.Marshal(tmpDs)
+}
+
+func (ds *DiagnosticSetting) MustProperties() *DiagnosticSettingProperties {
+	if ds.Properties == nil {
+		ds.Properties = &DiagnosticSettingProperties{}
+	}
+	return ds.Properties
+}
+
+// The workspace is stored by name (or ID), ARM wants the ID
+func (ds *DiagnosticSetting) ResolveWorkspace() *ResourceReference {
+	if ds.Properties == nil || NotNil(ds.Properties.WorkspaceId) == "" {
+		return nil
+	}
+	ws := *(ds.Properties.WorkspaceId)
+	wsRef := LogAnalyticsRef(ws, ds.Subscription, ds.ResourceGroup)
+	wsRef.Populate(ws)
+	return wsRef
+}
+
+func (ds *DiagnosticSetting) DependsOn() []*ResourceReference {
+	refs := []*ResourceReference{}
+	if scopeRef := ds.ScopeRef(); scopeRef != nil {
+		refs = append(refs, scopeRef)
+	}
+	if wsRef := ds.ResolveWorkspace(); wsRef != nil {
+		refs = append(refs, wsRef)
+	}
+	return refs
+}
+
+func (ds *DiagnosticSetting) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*DiagnosticSetting(" + ds.Name + ")"
+	form.AddProp("Name", ds.Name)
+	form.AddProp("Scope", ds.Scope)
+	if props := ds.Properties; props != nil {
+		if props.WorkspaceId != nil {
+			form.AddProp("Workspace", *(props.WorkspaceId))
+		}
+		if len(props.Logs) > 0 {
+			nf := form.AddArray("Logs", "")
+			for _, l := range props.Logs {
+				name := NotNil(l.Category)
+				if l.CategoryGroup != nil {
+					name = "group:" + *(l.CategoryGroup)
+				}
+				nf.AddProp(name, fmt.Sprintf("%v", l.Enabled != nil && *l.Enabled))
+			}
+		}
+		if len(props.Metrics) > 0 {
+			nf := form.AddArray("Metrics", "")
+			for _, m := range props.Metrics {
+				nf.AddProp(NotNil(m.Category),
+					fmt.Sprintf("%v", m.Enabled != nil && *m.Enabled))
+			}
+		}
+	}
+
+	return form
+}
+
+func (ds *DiagnosticSetting) FromForm(r *ResourceBase, f *Form) {
+	newDs := &DiagnosticSetting{
+		ResourceBase: ds.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name", "Scope":
+			// Skip
+		case "Workspace":
+			newDs.MustProperties().WorkspaceId = StringPtr(item.Value)
+		case "Logs":
+			for _, l := range item.Items {
+				dl := &DiagnosticLog{Enabled: BoolPtr(l.Value == "true")}
+				if group, found := strings.CutPrefix(l.Title, "group:"); found {
+					dl.CategoryGroup = StringPtr(group)
+				} else {
+					dl.Category = StringPtr(l.Title)
+				}
+				newDs.MustProperties().Logs =
+					append(newDs.MustProperties().Logs, dl)
+			}
+		case "Metrics":
+			for _, m := range item.Items {
+				newDs.MustProperties().Metrics =
+					append(newDs.MustProperties().Metrics, &DiagnosticMetric{
+						Category: StringPtr(m.Title),
+						Enabled:  BoolPtr(m.Value == "true"),
+					})
+			}
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newDs, "", "  ")
+
+	r.Object = newDs
+	r.RawData = data
+}
+
+func (ds *DiagnosticSetting) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(ds, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (ds *DiagnosticSetting) ToJson() string {
+	data, _ := json.MarshalIndent(ds, "", "  ")
+	return string(data)
+}
+
+func (ds *DiagnosticSetting) HideServerFields() {
+}
+
+func DiagnosticsFromARMJson(data []byte) *ResourceBase {
+	ds := &DiagnosticSetting{}
+	return ParseARMResource(data, "Microsoft.Insights/diagnosticSettings",
+		"diagnostic-setting", ds, &ds.ResourceBase)
+}
+
+func AddDiagnosticSettingFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddDiagnosticSettingFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddDiagnosticSettingFunc")
+
+	scopeRef := ResolveScope(FlagAsString(cmd, "scope"))
+
+	ds := &DiagnosticSetting{}
+	ds.InitScopedResource(ds, "Microsoft.Insights/diagnosticSettings",
+		"diagnostic-setting", FlagAsString(cmd, "name"), scopeRef)
+	ds.Filename = ScopedResourceFileName("diagnostic-setting", scopeRef,
+		ds.Name)
+
+	ds.ProcessFlags(cmd)
+	ds.SaveAndUp(cmd)
+}
+
+func UpdateDiagnosticSettingFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdateDiagnosticSettingFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdateDiagnosticSettingFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	res := FindScopedResource("diagnostic-setting", name,
+		FlagAsString(cmd, "scope"))
+	if res == nil {
+		ErrStop("Resource diagnostic-setting/%s not found", name)
+	}
+	ds := res.Object.(*DiagnosticSetting)
+
+	ds.ProcessFlags(cmd)
+	ds.SaveAndUp(cmd)
+}
+
+func (ds *DiagnosticSetting) ProcessFlags(cmd *cobra.Command) {
+	if cmd.Flags().Changed("workspace") {
+		ds.MustProperties().WorkspaceId =
+			NilStringPtr(FlagAsString(cmd, "workspace"))
+	}
+}
@@ -0,0 +1,521 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/duglin/dlog"
+	"github.com/spf13/cobra"
+)
+
+func initDns() {
+	log.VPrintf(3, "Init initDns")
+	setupDnsCmds()
+	setupDnsResourceDefs()
+	RegisteredParsers = append(RegisteredParsers, DnsFromARMJson)
+}
+
+func setupDnsCmds() {
+	cmd := &cobra.Command{
+		Use:   "dns-zone",
+		Short: "Add a public DNS zone",
+		Run:   AddDnsZoneFunc,
+	}
+	addDnsZoneFlags(cmd)
+	AddCmd.AddCommand(cmd)
+
+	AddShowCmd("dns-zone", "Show details about a DNS zone", "zone (e.g. example.com)")
+
+	// ---
+
+	cmd = &cobra.Command{
+		Use:   "dns-cname",
+		Short: "Add a CNAME record to a DNS zone",
+		Run:   AddDnsRecordFunc,
+	}
+	addDnsRecordFlags(cmd, "cname")
+	cmd.MarkFlagRequired("target")
+	AddCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:   "dns-cname",
+		Short: "Update a CNAME record of a DNS zone",
+		Run:   UpdateDnsRecordFunc,
+	}
+	addDnsRecordFlags(cmd, "cname")
+	UpdateCmd.AddCommand(cmd)
+
+	AddShowCmd("dns-cname", "Show details about a DNS CNAME record",
+		"record (ZONE/NAME)")
+
+	cmd = &cobra.Command{
+		Use:   "dns-txt",
+		Short: "Add a TXT record to a DNS zone",
+		Run:   AddDnsRecordFunc,
+	}
+	addDnsRecordFlags(cmd, "txt")
+	cmd.MarkFlagRequired("value")
+	AddCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:   "dns-txt",
+		Short: "Update a TXT record of a DNS zone",
+		Run:   UpdateDnsRecordFunc,
+	}
+	addDnsRecordFlags(cmd, "txt")
+	UpdateCmd.AddCommand(cmd)
+
+	AddShowCmd("dns-txt", "Show details about a DNS TXT record",
+		"record (ZONE/NAME)")
+}
+
+// DNS zones are global so there's no "--location" flag
+func addDnsZoneFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("name", "n", "", "Name of zone (e.g. example.com)")
+	cmd.Flags().StringP("subscription", "s", "", "Subscription ID")
+	cmd.Flags().StringP("resource-group", "g", "", "Resource Group")
+	cmd.Flags().Bool("up", false, "Provision after update")
+	cmd.MarkFlagRequired("name")
+}
+
+func addDnsRecordFlags(cmd *cobra.Command, kind string) {
+	cmd.Flags().StringP("name", "n", "", "Name of record (ZONE/NAME)")
+	cmd.Flags().StringP("subscription", "s", "", "Subscription ID")
+	cmd.Flags().StringP("resource-group", "g", "", "Resource Group")
+	cmd.Flags().Bool("up", false, "Provision after update")
+	cmd.Flags().String("ttl", "", "Time to live, in seconds (default: 3600)")
+	if kind == "cname" {
+		cmd.Flags().String("target", "", "Host name the record points to")
+	} else {
+		cmd.Flags().StringArray("value", nil, "Value of the record")
+	}
+	cmd.MarkFlagRequired("name")
+}
+
+func setupDnsResourceDefs() {
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.Network/dnsZones",
+		URL:  "https://management.azure.com/subscriptions/${SUBSCRIPTION}/resourceGroups/${RESOURCEGROUP}/providers/Microsoft.Network/dnsZones/${NAME}?api-version=${APIVERSION}",
+		Defaults: map[string]string{
+			"APIVERSION": "2018-05-01",
+		},
+	})
+	ResourceAliases["dns-zone"] = "Microsoft.Network/dnsZones"
+
+	for _, kind := range []string{"CNAME", "TXT"} {
+		AddResourceDef(&ResourceDef{
+			Type: "Microsoft.Network/dnsZones/" + kind,
+			Defaults: map[string]string{
+				"APIVERSION": "2018-05-01",
+			},
+		})
+		ResourceAliases["dns-"+strings.ToLower(kind)] =
+			"Microsoft.Network/dnsZones/" + kind
+	}
+}
+
+// Returns the DNS zone, in the stage, that "domain" is in. If more than one
+// matches then the most specific one wins. "rel" is the domain's name
+// relative to the zone, which is "" if it's the zone's apex.
+func FindDnsZone(domain string) (zone *ResourceBase, rel string) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	for _, res := range GetStageResources("") {
+		if res.NiceType != "dns-zone" {
+			continue
+		}
+		name := strings.ToLower(res.Name)
+		if domain != name && !strings.HasSuffix(domain, "."+name) {
+			continue
+		}
+		if zone == nil || len(name) > len(zone.Name) {
+			zone = res
+			rel = strings.TrimSuffix(strings.TrimSuffix(domain, name), ".")
+		}
+	}
+	return zone, rel
+}
+
+// Returns references to the records, in the stage, that are needed to
+// verify the ownership of "domain" for an ACA custom domain
+func DnsRecordRefs(domain string) []*ResourceReference {
+	refs := []*ResourceReference{}
+	zone, rel := FindDnsZone(domain)
+	if zone == nil || rel == "" {
+		return refs
+	}
+
+	resources := GetStageResources("")
+	for _, rr := range []*ResourceReference{
+		DnsRecordRef(zone, "CNAME", rel),
+		DnsRecordRef(zone, "TXT", "asuid."+rel),
+	} {
+		if resources[strings.ToLower(rr.AsID())] != nil {
+			refs = append(refs, rr)
+		}
+	}
+	return refs
+}
+
+func DnsRecordRef(zone *ResourceBase, kind string, name string) *ResourceReference {
+	resType := "Microsoft.Network/dnsZones/" + kind
+	return &ResourceReference{
+		Subscription:  zone.Subscription,
+		ResourceGroup: zone.ResourceGroup,
+		Type:          resType,
+		APIVersion:    GetResourceDef(resType).Defaults["APIVERSION"],
+		Name:          zone.Name + "/" + name,
+		Origin:        zone.Name + "/" + name,
+	}
+}
+
+type DnsZone struct {
+	ResourceBase
+
+	Location *string `json:"location,omitempty"`
+}
+
+func (zone *DnsZone) MarshalJSON() ([]byte, error) {
+	tmpZone := *zone
+	if WhyMarshal == "ARM" {
+		tmpZone.Location = StringPtr("global")
+	}
+	return json.Marshal(tmpZone)
+}
+
+func (zone *DnsZone) DependsOn() []*ResourceReference {
+	return []*ResourceReference{}
+}
+
+func (zone *DnsZone) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*DNS-Zone(" + zone.Name + ")"
+	form.AddProp("Name", zone.Name)
+	form.AddProp("Subscription", zone.Subscription)
+	form.AddProp("ResourceGroup", zone.ResourceGroup)
+
+	return form
+}
+
+func (zone *DnsZone) FromForm(r *ResourceBase, f *Form) {
+	newZone := &DnsZone{
+		ResourceBase: zone.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name":
+			// Skip
+		case "Subscription":
+			newZone.Subscription = item.Value
+		case "ResourceGroup":
+			newZone.ResourceGroup = item.Value
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newZone, "", "  ")
+
+	r.Object = newZone
+	r.RawData = data
+}
+
+func (zone *DnsZone) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(zone, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (zone *DnsZone) ToJson() string {
+	data, _ := json.MarshalIndent(zone, "", "  ")
+	return string(data)
+}
+
+func (zone *DnsZone) HideServerFields() {
+	// We always send "global", diffs do this to both sides
+	if strings.EqualFold(NotNil(zone.Location), "global") {
+		zone.Location = nil
+	}
+}
+
+func DnsFromARMJson(data []byte) *ResourceBase {
+	zone := &DnsZone{}
+	if res := ParseARMResource(data, "Microsoft.Network/dnsZones",
+		"dns-zone", zone, &zone.ResourceBase); res != nil {
+		return res
+	}
+
+	for _, kind := range []string{"CNAME", "TXT"} {
+		rec := &DnsRecord{}
+		if res := ParseARMResource(data, "Microsoft.Network/dnsZones/"+kind,
+			"dns-"+strings.ToLower(kind), rec, &rec.ResourceBase); res != nil {
+			return res
+		}
+	}
+
+	return nil
+}
+
+func AddDnsZoneFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddDnsZoneFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddDnsZoneFunc")
+
+	zone := &DnsZone{}
+	name, _ := cmd.Flags().GetString("name")
+	zone.InitResource(zone, "Microsoft.Network/dnsZones", "dns-zone",
+		strings.ToLower(strings.TrimSuffix(name, ".")))
+
+	zone.ProcessResourceFlags(cmd, nil)
+	zone.SaveAndUp(cmd)
+}
+
+// ---
+
+type DnsCnameRecord struct {
+	Cname *string `json:"cname,omitempty"`
+}
+
+type DnsTxtRecord struct {
+	Value []string `json:"value,omitempty"`
+}
+
+type DnsRecordProperties struct {
+	TTL         *int            `json:"TTL,omitempty"`
+	CNAMERecord *DnsCnameRecord `json:"CNAMERecord,omitempty"`
+	TXTRecords  []*DnsTxtRecord `json:"TXTRecords,omitempty"`
+}
+
+// CNAME and TXT record sets, the NiceType says which one it is
+type DnsRecord struct {
+	ResourceBase
+
+	Properties *DnsRecordProperties `json:"properties,omitempty"`
+}
+
+func (rec *DnsRecord) MarshalJSON() ([]byte, error) {
+	tmpRec := *rec
+	if WhyMarshal == "ARM" {
+		// Copy it so we don't touch the original
+		props := DnsRecordProperties{}
+		if rec.Properties != nil {
+			props = *(rec.Properties)
+		}
+		tmpRec.Properties = &props
+
+		if props.TTL == nil {
+			props.TTL = IntPtr(3600)
+		}
+	}
+	return json.Marshal(tmpRec)
+}
+
+func (rec *DnsRecord) ZoneName() string {
+	zone, _, _ := strings.Cut(rec.Name, "/")
+	return zone
+}
+
+func (rec *DnsRecord) RecordName() string {
+	_, name, _ := strings.Cut(rec.Name, "/")
+	return name
+}
+
+func (rec *DnsRecord) MustProperties() *DnsRecordProperties {
+	if rec.Properties == nil {
+		rec.Properties = &DnsRecordProperties{}
+	}
+	return rec.Properties
+}
+
+// The values of the record, e.g. the CNAME's target
+func (rec *DnsRecord) Values() []string {
+	values := []string{}
+	if props := rec.Properties; props != nil {
+		if props.CNAMERecord != nil && props.CNAMERecord.Cname != nil {
+			values = append(values, *(props.CNAMERecord.Cname))
+		}
+		for _, txt := range props.TXTRecords {
+			values = append(values, txt.Value...)
+		}
+	}
+	return values
+}
+
+func (rec *DnsRecord) DependsOn() []*ResourceReference {
+	refs := []*ResourceReference{{
+		Subscription:  rec.Subscription,
+		ResourceGroup: rec.ResourceGroup,
+		Type:          "Microsoft.Network/dnsZones",
+		APIVersion:    GetResourceDef("Microsoft.Network/dnsZones").Defaults["APIVERSION"],
+		Name:          rec.ZoneName(),
+	}}
+
+	// Values can reference other resources, e.g. ${type/name.prop}
+	for _, value := range rec.Values() {
+		refs = append(refs, SubstitutionRefs(value, rec.Subscription,
+			rec.ResourceGroup)...)
+	}
+	return refs
+}
+
+func (rec *DnsRecord) ToForm() *Form {
+	kind, _ := strings.CutPrefix(rec.NiceType, "dns-")
+
+	form := NewForm()
+	form.Title = "*DNS-" + strings.ToUpper(kind) + "(" + rec.Name + ")"
+	form.AddProp("Name", rec.RecordName())
+	form.AddProp("Zone", rec.ZoneName())
+	form.AddProp("Subscription", rec.Subscription)
+	form.AddProp("ResourceGroup", rec.ResourceGroup)
+	if props := rec.Properties; props != nil {
+		if props.TTL != nil {
+			form.AddProp("TTL", fmt.Sprintf("%d", *(props.TTL)))
+		}
+		if props.CNAMERecord != nil && props.CNAMERecord.Cname != nil {
+			form.AddProp("Target", *(props.CNAMERecord.Cname))
+		}
+		if len(props.TXTRecords) > 0 {
+			form.AddProp("Values", QuoteStrings(rec.Values()))
+		}
+	}
+
+	return form
+}
+
+func (rec *DnsRecord) FromForm(r *ResourceBase, f *Form) {
+	newRec := &DnsRecord{
+		ResourceBase: rec.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name", "Zone":
+			// Skip
+		case "Subscription":
+			newRec.Subscription = item.Value
+		case "ResourceGroup":
+			newRec.ResourceGroup = item.Value
+		case "TTL":
+			i, _ := strconv.Atoi(item.Value)
+			newRec.MustProperties().TTL = &i
+		case "Target":
+			newRec.MustProperties().CNAMERecord =
+				&DnsCnameRecord{Cname: StringPtr(item.Value)}
+		case "Values":
+			for _, value := range ParseQuotedString(item.Value) {
+				newRec.MustProperties().TXTRecords =
+					append(newRec.MustProperties().TXTRecords,
+						&DnsTxtRecord{Value: []string{value}})
+			}
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newRec, "", "  ")
+
+	r.Object = newRec
+	r.RawData = data
+}
+
+func (rec *DnsRecord) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(rec, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (rec *DnsRecord) ToJson() string {
+	data, _ := json.MarshalIndent(rec, "", "  ")
+	return string(data)
+}
+
+func (rec *DnsRecord) HideServerFields() {
+}
+
+// Creates the record, in the current stage, in "zone". "kind" is "CNAME" or
+// "TXT" and "value" is the CNAME's target or the TXT's value.
+func NewDnsRecord(zone *ResourceBase, kind string, name string, value string) *DnsRecord {
+	rec := &DnsRecord{}
+	rec.InitResource(rec, "Microsoft.Network/dnsZones/"+kind,
+		"dns-"+strings.ToLower(kind), zone.Name+"/"+name)
+	rec.Subscription = zone.Subscription
+	rec.ResourceGroup = zone.ResourceGroup
+
+	if kind == "CNAME" {
+		rec.MustProperties().CNAMERecord = &DnsCnameRecord{Cname: StringPtr(value)}
+	} else {
+		rec.MustProperties().TXTRecords = []*DnsTxtRecord{{Value: []string{value}}}
+	}
+	return rec
+}
+
+func AddDnsRecordFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddDnsRecordFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddDnsRecordFunc")
+
+	kind, _ := strings.CutPrefix(cmd.CalledAs(), "dns-")
+	name, _ := cmd.Flags().GetString("name")
+	name = ChildName(name, "", "ZONE")
+
+	rec := &DnsRecord{}
+	rec.InitResource(rec, "Microsoft.Network/dnsZones/"+strings.ToUpper(kind),
+		cmd.CalledAs(), name)
+
+	// Default to the zone's sub/rg if it's in the stage
+	stage := GetConfigProperty("currentStage")
+	if zone, err := ResourceFromFile(stage, ResourceFileName("dns-zone", rec.ZoneName())); err == nil {
+		rec.Subscription = zone.Subscription
+		rec.ResourceGroup = zone.ResourceGroup
+	}
+
+	rec.ProcessFlags(cmd)
+	rec.SaveAndUp(cmd)
+}
+
+func UpdateDnsRecordFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdateDnsRecordFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdateDnsRecordFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	name = ChildName(name, "", "ZONE")
+	rec := LoadStageResource(cmd.CalledAs(), name).Object.(*DnsRecord)
+
+	rec.ProcessFlags(cmd)
+	rec.SaveAndUp(cmd)
+}
+
+func (rec *DnsRecord) ProcessFlags(cmd *cobra.Command) {
+	rec.ProcessResourceFlags(cmd, nil)
+
+	if cmd.Flags().Changed("ttl") {
+		val := FlagAsString(cmd, "ttl")
+		if val == "" {
+			rec.MustProperties().TTL = nil
+		} else {
+			i, err := strconv.Atoi(val)
+			NoErr(err, "Bad --ttl value %q: %s", val, err)
+			rec.MustProperties().TTL = &i
+		}
+	}
+
+	if cmd.Flags().Lookup("target") != nil && cmd.Flags().Changed("target") {
+		rec.MustProperties().CNAMERecord = &DnsCnameRecord{
+			Cname: StringPtr(FlagAsString(cmd, "target")),
+		}
+	}
+
+	if cmd.Flags().Lookup("value") != nil && cmd.Flags().Changed("value") {
+		values, _ := cmd.Flags().GetStringArray("value")
+		rec.MustProperties().TXTRecords = nil
+		for _, value := range values {
+			rec.MustProperties().TXTRecords =
+				append(rec.MustProperties().TXTRecords,
+					&DnsTxtRecord{Value: []string{value}})
+		}
+	}
+}
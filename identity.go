@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	log "github.com/duglin/dlog"
+	"github.com/spf13/cobra"
+)
+
+func initIdentity() {
+	log.VPrintf(3, "Init initIdentity")
+	setupIdentityCmds()
+	setupIdentityResourceDefs()
+	RegisteredParsers = append(RegisteredParsers, IdentityFromARMJson)
+}
+
+func setupIdentityCmds() {
+	cmd := &cobra.Command{
+		Use:   "identity",
+		Short: "Add a user-assigned managed identity",
+		Run:   AddIdentityFunc,
+	}
+	AddResourceFlags(cmd, "identity")
+	AddCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:   "identity",
+		Short: "Update a user-assigned managed identity",
+		Run:   UpdateIdentityFunc,
+	}
+	AddResourceFlags(cmd, "identity")
+	UpdateCmd.AddCommand(cmd)
+
+	AddShowCmd("identity", "Show details about a user-assigned managed identity",
+		"identity")
+}
+
+func setupIdentityResourceDefs() {
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.ManagedIdentity/userAssignedIdentities",
+		URL:  "https://management.azure.com/subscriptions/${SUBSCRIPTION}/resourceGroups/${RESOURCEGROUP}/providers/Microsoft.ManagedIdentity/userAssignedIdentities/${NAME}?api-version=${APIVERSION}",
+		Defaults: map[string]string{
+			"APIVERSION": "2023-01-31",
+		},
+	})
+	ResourceAliases["identity"] = "Microsoft.ManagedIdentity/userAssignedIdentities"
+}
+
+// Returns a reference to a user-assigned identity, which can be a name or
+// an ID. If it's in the stage then its sub/rg are used.
+func IdentityRef(identity string) *ResourceReference {
+	resRef := &ResourceReference{
+		Subscription:  GetConfigProperty("defaults.Subscription"),
+		ResourceGroup: GetConfigProperty("defaults.ResourceGroup"),
+		Type:          "Microsoft.ManagedIdentity/userAssignedIdentities",
+		APIVersion:    GetResourceDef("Microsoft.ManagedIdentity/userAssignedIdentities").Defaults["APIVERSION"],
+		Origin:        identity,
+	}
+
+	if strings.HasPrefix(identity, "/subscriptions/") {
+		resRef.Populate(identity)
+		return resRef
+	}
+
+	resRef.Name = identity
+	stage := GetConfigProperty("currentStage")
+	if res, err := ResourceFromFile(stage, ResourceFileName("identity", identity)); err == nil {
+		resRef.Subscription = res.Subscription
+		resRef.ResourceGroup = res.ResourceGroup
+	}
+
+	return resRef
+}
+
+// Converts an identity as used by ACA ("system", or a user-assigned
+// identity's name or ID) into the value ARM expects
+func IdentityARMValue(identity string) string {
+	if identity == "" || strings.EqualFold(identity, "system") {
+		return identity
+	}
+	return IdentityRef(identity).AsID()
+}
+
+type Identity struct {
+	ResourceBase
+
+	Location *string `json:"location,omitempty"`
+}
+
+func (id *Identity) MarshalJSON() ([]byte, error) {
+	tmpId := *id
+	if WhyMarshal == "ARM" {
+		if tmpId.Location == nil {
+			tmpId.Location = StringPtr(GetConfigProperty("defaults.Location"))
+		}
+		if tmpId.Location == nil || *(tmpId.Location) == "" {
+			ErrStop(`Missing "location" for "%s/%s"`, id.NiceType, id.Name)
+		}
+	}
+	return json.Marshal(tmpId)
+}
+
+func (id *Identity) DependsOn() []*ResourceReference {
+	return []*ResourceReference{}
+}
+
+func (id *Identity) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*Identity(" + id.Name + ")"
+	form.AddProp("Name", id.Name)
+	if NotNil(id.Location) != "" {
+		form.AddProp("Location", NotNil(id.Location))
+	}
+	form.AddProp("Subscription", id.Subscription)
+	form.AddProp("ResourceGroup", id.ResourceGroup)
+
+	return form
+}
+
+func (id *Identity) FromForm(r *ResourceBase, f *Form) {
+	newId := &Identity{
+		ResourceBase: id.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name":
+			// Skip
+		case "Location":
+			newId.Location = StringPtr(item.Value)
+		case "Subscription":
+			newId.Subscription = item.Value
+		case "ResourceGroup":
+			newId.ResourceGroup = item.Value
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newId, "", "  ")
+
+	r.Object = newId
+	r.RawData = data
+}
+
+func (id *Identity) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(id, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (id *Identity) ToJson() string {
+	data, _ := json.MarshalIndent(id, "", "  ")
+	return string(data)
+}
+
+func (id *Identity) HideServerFields() {
+}
+
+func IdentityFromARMJson(data []byte) *ResourceBase {
+	id := &Identity{}
+	return ParseARMResource(data,
+		"Microsoft.ManagedIdentity/userAssignedIdentities", "identity",
+		id, &id.ResourceBase)
+}
+
+func AddIdentityFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddIdentityFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddIdentityFunc")
+
+	id := &Identity{}
+	name, _ := cmd.Flags().GetString("name")
+	id.InitResource(id, "Microsoft.ManagedIdentity/userAssignedIdentities",
+		"identity", name)
+	id.Location = StringPtr(GetConfigProperty("defaults.Location"))
+
+	id.ProcessFlags(cmd)
+	id.SaveAndUp(cmd)
+}
+
+func UpdateIdentityFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdateIdentityFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdateIdentityFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	id := LoadStageResource("identity", name).Object.(*Identity)
+
+	id.ProcessFlags(cmd)
+	id.SaveAndUp(cmd)
+}
+
+func (id *Identity) ProcessFlags(cmd *cobra.Command) {
+	id.ProcessResourceFlags(cmd, &id.Location)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	log "github.com/duglin/dlog"
+	"github.com/spf13/cobra"
+)
+
+func initKeyVault() {
+	log.VPrintf(3, "Init initKeyVault")
+	setupKeyVaultCmds()
+	setupKeyVaultResourceDefs()
+	RegisteredParsers = append(RegisteredParsers, KeyVaultFromARMJson)
+}
+
+func setupKeyVaultCmds() {
+	cmd := &cobra.Command{
+		Use:   "keyvault",
+		Short: "Add an Azure Key Vault",
+		Run:   AddKeyVaultFunc,
+	}
+	AddResourceFlags(cmd, "vault")
+	cmd.Flags().String("sku", "", "'standard' or 'premium'")
+	cmd.Flags().Bool("purge-protection", false, "Enable purge protection")
+	AddCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:   "keyvault",
+		Short: "Update an Azure Key Vault",
+		Run:   UpdateKeyVaultFunc,
+	}
+	AddResourceFlags(cmd, "vault")
+	cmd.Flags().String("sku", "", "'standard' or 'premium'")
+	cmd.Flags().Bool("purge-protection", false, "Enable purge protection")
+	UpdateCmd.AddCommand(cmd)
+
+	AddShowCmd("keyvault", "Show details about an Azure Key Vault", "vault")
+}
+
+func setupKeyVaultResourceDefs() {
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.KeyVault/vaults",
+		URL:  "https://management.azure.com/subscriptions/${SUBSCRIPTION}/resourceGroups/${RESOURCEGROUP}/providers/Microsoft.KeyVault/vaults/${NAME}?api-version=${APIVERSION}",
+		Defaults: map[string]string{
+			"APIVERSION": "2023-07-01",
+		},
+	})
+	ResourceAliases["keyvault"] = "Microsoft.KeyVault/vaults"
+}
+
+// "kv", "mysecret" -> "https://kv.vault.azure.net/secrets/mysecret"
+func KeyVaultSecretURL(vault string, secret string) string {
+	return "https://" + vault + ".vault.azure.net/secrets/" + secret
+}
+
+// Returns the name of the vault for a secret URL, or "" if it's not one
+func KeyVaultNameFromURL(secretURL string) string {
+	u, err := url.Parse(secretURL)
+	if err != nil {
+		return ""
+	}
+	host := u.Hostname()
+	suffix := ".vault.azure.net"
+	if !strings.HasSuffix(strings.ToLower(host), suffix) {
+		return ""
+	}
+	return host[:len(host)-len(suffix)]
+}
+
+type KeyVaultSku struct {
+	Family *string `json:"family,omitempty"`
+	Name   *string `json:"name,omitempty"`
+}
+
+type KeyVaultProperties struct {
+	TenantId                *string      `json:"tenantId,omitempty"`
+	Sku                     *KeyVaultSku `json:"sku,omitempty"`
+	EnableRbacAuthorization *bool        `json:"enableRbacAuthorization,omitempty"`
+	EnablePurgeProtection   *bool        `json:"enablePurgeProtection,omitempty"`
+}
+
+type KeyVault struct {
+	ResourceBase
+
+	Location   *string             `json:"location,omitempty"`
+	Properties *KeyVaultProperties `json:"properties,omitempty"`
+}
+
+func (kv *KeyVault) MarshalJSON() ([]byte, error) {
+	tmpKv := *kv
+	if WhyMarshal == "ARM" {
+		if tmpKv.Location == nil {
+			tmpKv.Location = StringPtr(GetConfigProperty("defaults.Location"))
+		}
+		if tmpKv.Location == nil || *(tmpKv.Location) == "" {
+			ErrStop(`Missing "location" for "%s/%s"`, kv.NiceType, kv.Name)
+		}
+
+		// Copy it so we don't touch the original
+		props := KeyVaultProperties{}
+		if kv.Properties != nil {
+			props = *(kv.Properties)
+		}
+		tmpKv.Properties = &props
+
+		if props.TenantId == nil {
+			props.TenantId = StringPtr(TenantIdSubstitution(kv.Subscription))
+		}
+		if props.Sku == nil || props.Sku.Name == nil {
+			props.Sku = &KeyVaultSku{Name: StringPtr("standard")}
+		}
+		if props.Sku.Family == nil {
+			props.Sku = &KeyVaultSku{
+				Family: StringPtr("A"),
+				Name:   props.Sku.Name,
+			}
+		}
+		// Access is always via RBAC, never access policies
+		props.EnableRbacAuthorization = BoolPtr(true)
+	}
+	return json.Marshal(tmpKv)
+}
+
+func (kv *KeyVault) MustProperties() *KeyVaultProperties {
+	if kv.Properties == nil {
+		kv.Properties = &KeyVaultProperties{}
+	}
+	return kv.Properties
+}
+
+func (kv *KeyVault) DependsOn() []*ResourceReference {
+	return []*ResourceReference{}
+}
+
+func (kv *KeyVault) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*KeyVault(" + kv.Name + ")"
+	form.AddProp("Name", kv.Name)
+	if NotNil(kv.Location) != "" {
+		form.AddProp("Location", NotNil(kv.Location))
+	}
+	form.AddProp("Subscription", kv.Subscription)
+	form.AddProp("ResourceGroup", kv.ResourceGroup)
+	if props := kv.Properties; props != nil {
+		if props.Sku != nil && props.Sku.Name != nil {
+			form.AddProp("SKU", *(props.Sku.Name))
+		}
+		if props.EnablePurgeProtection != nil {
+			form.AddProp("Purge Protection", fmt.Sprintf("%v",
+				*(props.EnablePurgeProtection)))
+		}
+		// Always true in the ARM json, shown so diffs catch it being off
+		if props.EnableRbacAuthorization != nil {
+			form.AddProp("RBAC Authorization", fmt.Sprintf("%v",
+				*(props.EnableRbacAuthorization)))
+		}
+	}
+
+	return form
+}
+
+func (kv *KeyVault) FromForm(r *ResourceBase, f *Form) {
+	newKv := &KeyVault{
+		ResourceBase: kv.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name":
+			// Skip
+		case "Location":
+			newKv.Location = StringPtr(item.Value)
+		case "Subscription":
+			newKv.Subscription = item.Value
+		case "ResourceGroup":
+			newKv.ResourceGroup = item.Value
+		case "SKU":
+			newKv.MustProperties().Sku = &KeyVaultSku{
+				Name: StringPtr(item.Value),
+			}
+		case "Purge Protection":
+			newKv.MustProperties().EnablePurgeProtection =
+				BoolPtr(item.Value == "true")
+		case "RBAC Authorization":
+			newKv.MustProperties().EnableRbacAuthorization =
+				BoolPtr(item.Value == "true")
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newKv, "", "  ")
+
+	r.Object = newKv
+	r.RawData = data
+}
+
+func (kv *KeyVault) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(kv, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (kv *KeyVault) ToJson() string {
+	data, _ := json.MarshalIndent(kv, "", "  ")
+	return string(data)
+}
+
+func (kv *KeyVault) HideServerFields() {
+	if props := kv.Properties; props != nil {
+		// We never store these, they're always set at ARM time
+		props.TenantId = nil
+		if props.Sku != nil {
+			props.Sku.Family = nil
+		}
+		if props.EnablePurgeProtection != nil &&
+			*(props.EnablePurgeProtection) == false {
+			props.EnablePurgeProtection = nil
+		}
+	}
+}
+
+func KeyVaultFromARMJson(data []byte) *ResourceBase {
+	kv := &KeyVault{}
+	return ParseARMResource(data, "Microsoft.KeyVault/vaults", "keyvault",
+		kv, &kv.ResourceBase)
+}
+
+func AddKeyVaultFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddKeyVaultFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddKeyVaultFunc")
+
+	kv := &KeyVault{}
+	name, _ := cmd.Flags().GetString("name")
+	kv.InitResource(kv, "Microsoft.KeyVault/vaults", "keyvault", name)
+	kv.Location = StringPtr(GetConfigProperty("defaults.Location"))
+
+	kv.ProcessFlags(cmd)
+	kv.SaveAndUp(cmd)
+}
+
+func UpdateKeyVaultFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdateKeyVaultFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdateKeyVaultFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	kv := LoadStageResource("keyvault", name).Object.(*KeyVault)
+
+	kv.ProcessFlags(cmd)
+	kv.SaveAndUp(cmd)
+}
+
+func (kv *KeyVault) ProcessFlags(cmd *cobra.Command) {
+	kv.ProcessResourceFlags(cmd, &kv.Location)
+
+	SetStringProp(kv, cmd.Flags(), "sku",
+		`{"properties":{"sku":{"name":%s}}}`)
+
+	if cmd.Flags().Changed("purge-protection") {
+		val, _ := cmd.Flags().GetBool("purge-protection")
+		kv.MustProperties().EnablePurgeProtection = BoolPtr(val)
+	}
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	log "github.com/duglin/dlog"
+	"github.com/spf13/cobra"
+)
+
+func initLogAnalytics() {
+	log.VPrintf(3, "Init initLogAnalytics")
+	setupLogAnalyticsCmds()
+	setupLogAnalyticsResourceDefs()
+	RegisteredParsers = append(RegisteredParsers, LogAnalyticsFromARMJson)
+}
+
+func setupLogAnalyticsCmds() {
+	cmd := &cobra.Command{
+		Use:   "log-analytics",
+		Short: "Add a Log Analytics workspace",
+		Run:   AddLogAnalyticsFunc,
+	}
+	AddResourceFlags(cmd, "workspace")
+	cmd.Flags().String("sku", "", "e.g. 'PerGB2018'")
+	cmd.Flags().String("retention", "", "Number of days to keep the data")
+	AddCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:   "log-analytics",
+		Short: "Update a Log Analytics workspace",
+		Run:   UpdateLogAnalyticsFunc,
+	}
+	AddResourceFlags(cmd, "workspace")
+	cmd.Flags().String("sku", "", "e.g. 'PerGB2018'")
+	cmd.Flags().String("retention", "", "Number of days to keep the data")
+	UpdateCmd.AddCommand(cmd)
+
+	AddShowCmd("log-analytics", "Show details about a Log Analytics workspace",
+		"workspace")
+
+	// ---
+
+	cmd = &cobra.Command{
+		Use:   "app-insights",
+		Short: "Add an Application Insights component",
+		Run:   AddAppInsightsFunc,
+	}
+	AddResourceFlags(cmd, "component")
+	cmd.Flags().String("workspace", "", "Log Analytics workspace to store data in")
+	AddCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:   "app-insights",
+		Short: "Update an Application Insights component",
+		Run:   UpdateAppInsightsFunc,
+	}
+	AddResourceFlags(cmd, "component")
+	cmd.Flags().String("workspace", "", "Log Analytics workspace to store data in")
+	UpdateCmd.AddCommand(cmd)
+
+	AddShowCmd("app-insights",
+		"Show details about an Application Insights component", "component")
+}
+
+func setupLogAnalyticsResourceDefs() {
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.OperationalInsights/workspaces",
+		URL:  "https://management.azure.com/subscriptions/${SUBSCRIPTION}/resourceGroups/${RESOURCEGROUP}/providers/Microsoft.OperationalInsights/workspaces/${NAME}?api-version=${APIVERSION}",
+		Defaults: map[string]string{
+			"APIVERSION": "2022-10-01",
+			"WAIT":       "true",
+		},
+		Actions: map[string]string{
+			"sharedKeys": "sharedKeys",
+		},
+	})
+	ResourceAliases["log-analytics"] = "Microsoft.OperationalInsights/workspaces"
+
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.Insights/components",
+		URL:  "https://management.azure.com/subscriptions/${SUBSCRIPTION}/resourceGroups/${RESOURCEGROUP}/providers/Microsoft.Insights/components/${NAME}?api-version=${APIVERSION}",
+		Defaults: map[string]string{
+			"APIVERSION": "2020-02-02",
+		},
+	})
+	ResourceAliases["app-insights"] = "Microsoft.Insights/components"
+}
+
+// Returns a reference to the named workspace. If it's in the stage then its
+// sub/rg are used, otherwise the passed-in ones are.
+func LogAnalyticsRef(name string, sub string, rg string) *ResourceReference {
+	resRef := &ResourceReference{
+		Subscription:  sub,
+		ResourceGroup: rg,
+		Type:          "Microsoft.OperationalInsights/workspaces",
+		APIVersion:    GetResourceDef("Microsoft.OperationalInsights/workspaces").Defaults["APIVERSION"],
+		Name:          name,
+		Origin:        name,
+	}
+
+	stage := GetConfigProperty("currentStage")
+	if res, err := ResourceFromFile(stage, ResourceFileName("log-analytics", name)); err == nil {
+		resRef.Subscription = res.Subscription
+		resRef.ResourceGroup = res.ResourceGroup
+	}
+
+	return resRef
+}
+
+// Same as LogAnalyticsRef but for an Application Insights component
+func AppInsightsRef(name string, sub string, rg string) *ResourceReference {
+	resRef := &ResourceReference{
+		Subscription:  sub,
+		ResourceGroup: rg,
+		Type:          "Microsoft.Insights/components",
+		APIVersion:    GetResourceDef("Microsoft.Insights/components").Defaults["APIVERSION"],
+		Name:          name,
+		Origin:        name,
+	}
+
+	stage := GetConfigProperty("currentStage")
+	if res, err := ResourceFromFile(stage, ResourceFileName("app-insights", name)); err == nil {
+		resRef.Subscription = res.Subscription
+		resRef.ResourceGroup = res.ResourceGroup
+	}
+
+	return resRef
+}
+
+type LogAnalyticsSku struct {
+	Name *string `json:"name,omitempty"`
+}
+
+type LogAnalyticsProperties struct {
+	Sku             *LogAnalyticsSku `json:"sku,omitempty"`
+	RetentionInDays *int             `json:"retentionInDays,omitempty"`
+}
+
+type LogAnalytics struct {
+	ResourceBase
+
+	Location   *string                 `json:"location,omitempty"`
+	Properties *LogAnalyticsProperties `json:"properties,omitempty"`
+}
+
+func (la *LogAnalytics) MarshalJSON() ([]byte, error) {
+	tmpLa := *la
+	if WhyMarshal == "ARM" {
+		if tmpLa.Location == nil {
+			tmpLa.Location = StringPtr(GetConfigProperty("defaults.Location"))
+		}
+		if tmpLa.Location == nil || *(tmpLa.Location) == "" {
+			ErrStop(`Missing "location" for "%s/%s"`, la.NiceType, la.Name)
+		}
+	}
+	return json.Marshal(tmpLa)
+}
+
+func (la *LogAnalytics) MustProperties() *LogAnalyticsProperties {
+	if la.Properties == nil {
+		la.Properties = &LogAnalyticsProperties{}
+	}
+	return la.Properties
+}
+
+func (la *LogAnalytics) DependsOn() []*ResourceReference {
+	return []*ResourceReference{}
+}
+
+func (la *LogAnalytics) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*LogAnalytics(" + la.Name + ")"
+	form.AddProp("Name", la.Name)
+	if NotNil(la.Location) != "" {
+		form.AddProp("Location", NotNil(la.Location))
+	}
+	form.AddProp("Subscription", la.Subscription)
+	form.AddProp("ResourceGroup", la.ResourceGroup)
+	if props := la.Properties; props != nil {
+		if props.Sku != nil && props.Sku.Name != nil {
+			form.AddProp("SKU", *(props.Sku.Name))
+		}
+		if props.RetentionInDays != nil {
+			form.AddProp("Retention Days", fmt.Sprintf("%d",
+				*(props.RetentionInDays)))
+		}
+	}
+
+	return form
+}
+
+func (la *LogAnalytics) FromForm(r *ResourceBase, f *Form) {
+	newLa := &LogAnalytics{
+		ResourceBase: la.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name":
+			// Skip
+		case "Location":
+			newLa.Location = StringPtr(item.Value)
+		case "Subscription":
+			newLa.Subscription = item.Value
+		case "ResourceGroup":
+			newLa.ResourceGroup = item.Value
+		case "SKU":
+			newLa.MustProperties().Sku = &LogAnalyticsSku{
+				Name: StringPtr(item.Value),
+			}
+		case "Retention Days":
+			days, _ := strconv.Atoi(item.Value)
+			newLa.MustProperties().RetentionInDays = IntPtr(days)
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newLa, "", "  ")
+
+	r.Object = newLa
+	r.RawData = data
+}
+
+func (la *LogAnalytics) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(la, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (la *LogAnalytics) ToJson() string {
+	data, _ := json.MarshalIndent(la, "", "  ")
+	return string(data)
+}
+
+func (la *LogAnalytics) HideServerFields() {
+}
+
+type AppInsightsProperties struct {
+	ApplicationType     *string `json:"Application_Type,omitempty"`
+	WorkspaceResourceId *string `json:"WorkspaceResourceId,omitempty"`
+}
+
+type AppInsights struct {
+	ResourceBase
+
+	Location   *string                `json:"location,omitempty"`
+	Kind       *string                `json:"kind,omitempty"`
+	Properties *AppInsightsProperties `json:"properties,omitempty"`
+}
+
+func (ai *AppInsights) MarshalJSON() ([]byte, error) {
+	tmpAi := *ai
+	if WhyMarshal == "ARM" {
+		if tmpAi.Location == nil {
+			tmpAi.Location = StringPtr(GetConfigProperty("defaults.Location"))
+		}
+		if tmpAi.Location == nil || *(tmpAi.Location) == "" {
+			ErrStop(`Missing "location" for "%s/%s"`, ai.NiceType, ai.Name)
+		}
+		if tmpAi.Kind == nil {
+			tmpAi.Kind = StringPtr("web")
+		}
+
+		// Copy it so we don't touch the original
+		props := AppInsightsProperties{}
+		if ai.Properties != nil {
+			props = *(ai.Properties)
+		}
+		tmpAi.Properties = &props
+
+		if props.ApplicationType == nil {
+			props.ApplicationType = StringPtr("web")
+		}
+		if ws := NotNil(props.WorkspaceResourceId); ws != "" {
+			wsRef := LogAnalyticsRef(ws, ai.Subscription, ai.ResourceGroup)
+			wsRef.Populate(ws)
+			props.WorkspaceResourceId = StringPtr(wsRef.AsID())
+		}
+	}
+	return json.Marshal(tmpAi)
+}
+
+func (ai *AppInsights) MustProperties() *AppInsightsProperties {
+	if ai.Properties == nil {
+		ai.Properties = &AppInsightsProperties{}
+	}
+	return ai.Properties
+}
+
+func (ai *AppInsights) DependsOn() []*ResourceReference {
+	refs := []*ResourceReference{}
+
+	if ws := NotNil(ai.MustProperties().WorkspaceResourceId); ws != "" {
+		wsRef := LogAnalyticsRef(ws, ai.Subscription, ai.ResourceGroup)
+		wsRef.Populate(ws)
+		refs = append(refs, wsRef)
+	}
+
+	return refs
+}
+
+func (ai *AppInsights) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*AppInsights(" + ai.Name + ")"
+	form.AddProp("Name", ai.Name)
+	if NotNil(ai.Location) != "" {
+		form.AddProp("Location", NotNil(ai.Location))
+	}
+	form.AddProp("Subscription", ai.Subscription)
+	form.AddProp("ResourceGroup", ai.ResourceGroup)
+	if props := ai.Properties; props != nil && props.WorkspaceResourceId != nil {
+		form.AddProp("Workspace", *(props.WorkspaceResourceId))
+	}
+
+	return form
+}
+
+func (ai *AppInsights) FromForm(r *ResourceBase, f *Form) {
+	newAi := &AppInsights{
+		ResourceBase: ai.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name":
+			// Skip
+		case "Location":
+			newAi.Location = StringPtr(item.Value)
+		case "Subscription":
+			newAi.Subscription = item.Value
+		case "ResourceGroup":
+			newAi.ResourceGroup = item.Value
+		case "Workspace":
+			newAi.MustProperties().WorkspaceResourceId = StringPtr(item.Value)
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newAi, "", "  ")
+
+	r.Object = newAi
+	r.RawData = data
+}
+
+func (ai *AppInsights) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(ai, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (ai *AppInsights) ToJson() string {
+	data, _ := json.MarshalIndent(ai, "", "  ")
+	return string(data)
+}
+
+func (ai *AppInsights) HideServerFields() {
+	// Always set at ARM time
+	ai.Kind = nil
+	if ai.Properties != nil {
+		ai.Properties.ApplicationType = nil
+		if *ai.Properties == (AppInsightsProperties{}) {
+			ai.Properties = nil
+		}
+	}
+}
+
+func LogAnalyticsFromARMJson(data []byte) *ResourceBase {
+	la := &LogAnalytics{}
+	if res := ParseARMResource(data, "Microsoft.OperationalInsights/workspaces",
+		"log-analytics", la, &la.ResourceBase); res != nil {
+		return res
+	}
+
+	ai := &AppInsights{}
+	return ParseARMResource(data, "Microsoft.Insights/components",
+		"app-insights", ai, &ai.ResourceBase)
+}
+
+func AddLogAnalyticsFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddLogAnalyticsFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddLogAnalyticsFunc")
+
+	la := &LogAnalytics{}
+	name, _ := cmd.Flags().GetString("name")
+	la.InitResource(la, "Microsoft.OperationalInsights/workspaces",
+		"log-analytics", name)
+	la.Location = StringPtr(GetConfigProperty("defaults.Location"))
+
+	la.ProcessFlags(cmd)
+	la.SaveAndUp(cmd)
+}
+
+func UpdateLogAnalyticsFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdateLogAnalyticsFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdateLogAnalyticsFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	la := LoadStageResource("log-analytics", name).Object.(*LogAnalytics)
+
+	la.ProcessFlags(cmd)
+	la.SaveAndUp(cmd)
+}
+
+func (la *LogAnalytics) ProcessFlags(cmd *cobra.Command) {
+	la.ProcessResourceFlags(cmd, &la.Location)
+
+	SetStringProp(la, cmd.Flags(), "sku",
+		`{"properties":{"sku":{"name":%s}}}`)
+
+	if cmd.Flags().Changed("retention") {
+		val := FlagAsString(cmd, "retention")
+		if val == "" {
+			la.MustProperties().RetentionInDays = nil
+		} else {
+			days, err := strconv.Atoi(val)
+			NoErr(err, "Retention must be a number of days: %s", val)
+			la.MustProperties().RetentionInDays = IntPtr(days)
+		}
+	}
+}
+
+func AddAppInsightsFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddAppInsightsFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddAppInsightsFunc")
+
+	ai := &AppInsights{}
+	name, _ := cmd.Flags().GetString("name")
+	ai.InitResource(ai, "Microsoft.Insights/components", "app-insights", name)
+	ai.Location = StringPtr(GetConfigProperty("defaults.Location"))
+
+	ai.ProcessFlags(cmd)
+	ai.SaveAndUp(cmd)
+}
+
+func UpdateAppInsightsFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdateAppInsightsFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdateAppInsightsFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	ai := LoadStageResource("app-insights", name).Object.(*AppInsights)
+
+	ai.ProcessFlags(cmd)
+	ai.SaveAndUp(cmd)
+}
+
+func (ai *AppInsights) ProcessFlags(cmd *cobra.Command) {
+	ai.ProcessResourceFlags(cmd, &ai.Location)
+
+	if cmd.Flags().Changed("workspace") {
+		ai.MustProperties().WorkspaceResourceId =
+			NilStringPtr(FlagAsString(cmd, "workspace"))
+	}
+}
@@ -0,0 +1,626 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/duglin/dlog"
+	"github.com/spf13/cobra"
+)
+
+func initOpenAI() {
+	log.VPrintf(3, "Init initOpenAI")
+	setupOpenAICmds()
+	setupOpenAIResourceDefs()
+	RegisteredParsers = append(RegisteredParsers, OpenAIFromARMJson)
+	BindHandlers["openai"] = BindOpenAI
+	BindEnvVars["openai"] = []string{"AZURE_OPENAI_ENDPOINT"}
+}
+
+func setupOpenAICmds() {
+	cmd := &cobra.Command{
+		Use:   "openai",
+		Short: "Add an Azure OpenAI account",
+		Run:   AddOpenAIFunc,
+	}
+	addOpenAIFlags(cmd)
+	AddCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:   "openai",
+		Short: "Update an Azure OpenAI account",
+		Run:   UpdateOpenAIFunc,
+	}
+	addOpenAIFlags(cmd)
+	UpdateCmd.AddCommand(cmd)
+
+	AddShowCmd("openai", "Show details about an Azure OpenAI account",
+		"account")
+
+	// ---
+
+	cmd = &cobra.Command{
+		Use:   "openai-deployment",
+		Short: "Add a model deployment to an Azure OpenAI account",
+		Run:   AddOpenAIDeploymentFunc,
+	}
+	addOpenAIDeploymentFlags(cmd)
+	cmd.MarkFlagRequired("model")
+	AddCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:   "openai-deployment",
+		Short: "Update a model deployment of an Azure OpenAI account",
+		Run:   UpdateOpenAIDeploymentFunc,
+	}
+	addOpenAIDeploymentFlags(cmd)
+	UpdateCmd.AddCommand(cmd)
+
+	AddShowCmd("openai-deployment", "Show details about an Azure OpenAI "+
+		"model deployment", "deployment (ACCOUNT/NAME)")
+}
+
+func addOpenAIFlags(cmd *cobra.Command) {
+	AddResourceFlags(cmd, "account")
+	cmd.Flags().String("sku", "", "SKU (default: S0)")
+	cmd.Flags().String("subdomain", "", "Custom subdomain of the endpoint "+
+		"(default: NAME)")
+	cmd.Flags().Bool("disable-local-auth", false,
+		"Only allow Entra ID auth, no API keys")
+}
+
+func addOpenAIDeploymentFlags(cmd *cobra.Command) {
+	AddResourceFlags(cmd, "deployment (ACCOUNT/NAME)")
+	cmd.Flags().String("model", "", "Model name (e.g. gpt-4o)")
+	cmd.Flags().String("model-version", "", "Model version (e.g. 2024-08-06)")
+	cmd.Flags().String("sku", "", "'Standard' (default), 'GlobalStandard', ...")
+	cmd.Flags().String("capacity", "", "Capacity, in thousands of tokens per "+
+		"minute (default: 1)")
+}
+
+func setupOpenAIResourceDefs() {
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.CognitiveServices/accounts",
+		URL:  "https://management.azure.com/subscriptions/${SUBSCRIPTION}/resourceGroups/${RESOURCEGROUP}/providers/Microsoft.CognitiveServices/accounts/${NAME}?api-version=${APIVERSION}",
+		Defaults: map[string]string{
+			"APIVERSION": "2023-05-01",
+			"WAIT":       "true",
+			"WAITBUSY":   "Accepted,Creating,ResolvingDNS",
+		},
+	})
+	ResourceAliases["openai"] = "Microsoft.CognitiveServices/accounts"
+
+	// Only one deployment per account can be changed at a time, so wait
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.CognitiveServices/accounts/deployments",
+		Defaults: map[string]string{
+			"APIVERSION": "2023-05-01",
+			"WAIT":       "true",
+			"WAITBUSY":   "Accepted,Creating,Updating",
+		},
+	})
+	ResourceAliases["openai-deployment"] =
+		"Microsoft.CognitiveServices/accounts/deployments"
+}
+
+// Returns a reference to an Azure OpenAI account. If it's in the stage then
+// its sub/rg are used.
+func OpenAIRef(name string, sub string, rg string) *ResourceReference {
+	resRef := &ResourceReference{
+		Subscription:  sub,
+		ResourceGroup: rg,
+		Type:          "Microsoft.CognitiveServices/accounts",
+		APIVersion:    GetResourceDef("Microsoft.CognitiveServices/accounts").Defaults["APIVERSION"],
+		Name:          name,
+		Origin:        name,
+	}
+
+	stage := GetConfigProperty("currentStage")
+	if res, err := ResourceFromFile(stage, ResourceFileName("openai", name)); err == nil {
+		resRef.Subscription = res.Subscription
+		resRef.ResourceGroup = res.ResourceGroup
+	}
+
+	return resRef
+}
+
+type OpenAISku struct {
+	Name *string `json:"name,omitempty"`
+}
+
+type OpenAIProperties struct {
+	CustomSubDomainName *string `json:"customSubDomainName,omitempty"`
+	DisableLocalAuth    *bool   `json:"disableLocalAuth,omitempty"`
+}
+
+type OpenAI struct {
+	ResourceBase
+
+	Kind       *string           `json:"kind,omitempty"`
+	Location   *string           `json:"location,omitempty"`
+	Sku        *OpenAISku        `json:"sku,omitempty"`
+	Properties *OpenAIProperties `json:"properties,omitempty"`
+}
+
+func (oai *OpenAI) MarshalJSON() ([]byte, error) {
+	tmpOai := *oai
+	if WhyMarshal == "ARM" {
+		if tmpOai.Location == nil {
+			tmpOai.Location = StringPtr(GetConfigProperty("defaults.Location"))
+		}
+		if tmpOai.Location == nil || *(tmpOai.Location) == "" {
+			ErrStop(`Missing "location" for "%s/%s"`, oai.NiceType, oai.Name)
+		}
+
+		tmpOai.Kind = StringPtr("OpenAI")
+		if tmpOai.Sku == nil {
+			tmpOai.Sku = &OpenAISku{Name: StringPtr("S0")}
+		}
+
+		// Copy it so we don't touch the original.
+		// Entra ID auth needs a custom subdomain.
+		props := OpenAIProperties{}
+		if oai.Properties != nil {
+			props = *(oai.Properties)
+		}
+		if props.CustomSubDomainName == nil {
+			props.CustomSubDomainName = StringPtr(oai.Name)
+		}
+		tmpOai.Properties = &props
+	}
+	return json.Marshal(tmpOai)
+}
+
+func (oai *OpenAI) MustProperties() *OpenAIProperties {
+	if oai.Properties == nil {
+		oai.Properties = &OpenAIProperties{}
+	}
+	return oai.Properties
+}
+
+func (oai *OpenAI) DependsOn() []*ResourceReference {
+	return []*ResourceReference{}
+}
+
+func (oai *OpenAI) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*OpenAI(" + oai.Name + ")"
+	form.AddProp("Name", oai.Name)
+	if NotNil(oai.Location) != "" {
+		form.AddProp("Location", NotNil(oai.Location))
+	}
+	form.AddProp("Subscription", oai.Subscription)
+	form.AddProp("ResourceGroup", oai.ResourceGroup)
+	if oai.Sku != nil && oai.Sku.Name != nil {
+		form.AddProp("SKU", *(oai.Sku.Name))
+	}
+	if props := oai.Properties; props != nil {
+		if props.CustomSubDomainName != nil {
+			form.AddProp("Subdomain", *(props.CustomSubDomainName))
+		}
+		if props.DisableLocalAuth != nil {
+			form.AddProp("Disable Local Auth",
+				fmt.Sprintf("%v", *(props.DisableLocalAuth)))
+		}
+	}
+
+	return form
+}
+
+func (oai *OpenAI) FromForm(r *ResourceBase, f *Form) {
+	newOai := &OpenAI{
+		ResourceBase: oai.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name":
+			// Skip
+		case "Location":
+			newOai.Location = StringPtr(item.Value)
+		case "Subscription":
+			newOai.Subscription = item.Value
+		case "ResourceGroup":
+			newOai.ResourceGroup = item.Value
+		case "SKU":
+			newOai.Sku = &OpenAISku{Name: StringPtr(item.Value)}
+		case "Subdomain":
+			newOai.MustProperties().CustomSubDomainName = StringPtr(item.Value)
+		case "Disable Local Auth":
+			newOai.MustProperties().DisableLocalAuth =
+				BoolPtr(item.Value == "true")
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newOai, "", "  ")
+
+	r.Object = newOai
+	r.RawData = data
+}
+
+func (oai *OpenAI) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(oai, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (oai *OpenAI) ToJson() string {
+	data, _ := json.MarshalIndent(oai, "", "  ")
+	return string(data)
+}
+
+func (oai *OpenAI) HideServerFields() {
+	// We always send "OpenAI" as the kind so only keep any other kind
+	if strings.EqualFold(NotNil(oai.Kind), "OpenAI") {
+		oai.Kind = nil
+	}
+}
+
+func OpenAIFromARMJson(data []byte) *ResourceBase {
+	oai := &OpenAI{}
+	if res := ParseARMResource(data, "Microsoft.CognitiveServices/accounts",
+		"openai", oai, &oai.ResourceBase); res != nil {
+		return res
+	}
+
+	dep := &OpenAIDeployment{}
+	return ParseARMResource(data,
+		"Microsoft.CognitiveServices/accounts/deployments",
+		"openai-deployment", dep, &dep.ResourceBase)
+}
+
+func AddOpenAIFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddOpenAIFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddOpenAIFunc")
+
+	oai := &OpenAI{}
+	name, _ := cmd.Flags().GetString("name")
+	oai.InitResource(oai, "Microsoft.CognitiveServices/accounts", "openai",
+		name)
+	oai.Location = StringPtr(GetConfigProperty("defaults.Location"))
+
+	oai.ProcessFlags(cmd)
+	oai.SaveAndUp(cmd)
+}
+
+func UpdateOpenAIFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdateOpenAIFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdateOpenAIFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	oai := LoadStageResource("openai", name).Object.(*OpenAI)
+
+	oai.ProcessFlags(cmd)
+	oai.SaveAndUp(cmd)
+}
+
+func (oai *OpenAI) ProcessFlags(cmd *cobra.Command) {
+	oai.ProcessResourceFlags(cmd, &oai.Location)
+
+	if cmd.Flags().Changed("sku") {
+		sku := FlagAsString(cmd, "sku")
+		if sku == "" {
+			oai.Sku = nil
+		} else {
+			oai.Sku = &OpenAISku{Name: StringPtr(sku)}
+		}
+	}
+
+	if cmd.Flags().Changed("subdomain") {
+		oai.MustProperties().CustomSubDomainName =
+			NilStringPtr(FlagAsString(cmd, "subdomain"))
+	}
+
+	if cmd.Flags().Changed("disable-local-auth") {
+		disable, _ := cmd.Flags().GetBool("disable-local-auth")
+		oai.MustProperties().DisableLocalAuth = BoolPtr(disable)
+	}
+
+	if oai.Properties != nil && *(oai.Properties) == (OpenAIProperties{}) {
+		oai.Properties = nil
+	}
+}
+
+// Processes "--bind openai/ACCOUNT" (and "--unbind"). The app gets the
+// account's endpoint in AZURE_OPENAI_ENDPOINT and its system identity is
+// given the "Cognitive Services OpenAI User" role on the account, via a
+// role-assignment resource in the stage.
+func BindOpenAI(holder AcaContainerHolder, name string, bind bool) {
+	app, ok := holder.(*AcaApp)
+	if !ok {
+		ErrStop("Only aca-apps can be bound to \"openai/%s\"", name)
+	}
+
+	oaiRef := OpenAIRef(name, app.Subscription, app.ResourceGroup)
+	endpoint := oaiRef.AsSubstitution("properties.endpoint")
+	container := MainContainer(app.MustContainers())
+
+	appRef := &ResourceReference{
+		Subscription:  app.Subscription,
+		ResourceGroup: app.ResourceGroup,
+		Type:          app.Type,
+		Name:          app.Name,
+	}
+	principalId := appRef.AsSubstitution(PrincipalProps["aca-app"].Prop)
+	roleID := RoleDefinitionID(oaiRef.Subscription,
+		"Cognitive Services OpenAI User")
+	raName := NameUUID(oaiRef.AsID(), roleID, principalId)
+	stage := GetConfigProperty("currentStage")
+
+	if !bind {
+		env := container.FindEnv("AZURE_OPENAI_ENDPOINT")
+		if env == nil || NotNil(env.Value) != endpoint {
+			ErrStop("Binding \"openai/%s\" was not found", name)
+		}
+		container.RemoveEnv("AZURE_OPENAI_ENDPOINT")
+
+		// Only the stage file is removed. Once it's gone 'down' can't find
+		// the assignment, so tell the user how to remove it from Azure
+		file := ResourceFileName("role-assignment", raName)
+		ra, err := ResourceFromFile(stage, file)
+		if err == nil && DeleteStageFile(stage, file) == nil {
+			fmt.Printf("Removed role-assignment/%s\n", raName)
+			fmt.Printf("If it was provisioned, remove it from Azure with:\n"+
+				"  az role assignment delete --ids %s\n", ra.ID)
+		}
+		return
+	}
+
+	container.SetEnvVar(&AcaAppEnv{
+		Name:  StringPtr("AZURE_OPENAI_ENDPOINT"),
+		Value: StringPtr(endpoint),
+	})
+
+	app.AddIdentity("system")
+
+	ra := &RoleAssignment{}
+	ra.InitScopedResource(ra, "Microsoft.Authorization/roleAssignments",
+		"role-assignment", raName, oaiRef)
+	props := ra.MustProperties()
+	props.RoleDefinitionId = StringPtr(roleID)
+	props.PrincipalId = StringPtr(principalId)
+	props.PrincipalType = StringPtr("ServicePrincipal")
+	ra.Save()
+	fmt.Printf("Added role-assignment/%s\n", raName)
+}
+
+// ---
+
+type OpenAIDeploymentSku struct {
+	Name     *string `json:"name,omitempty"`
+	Capacity *int    `json:"capacity,omitempty"`
+}
+
+type OpenAIDeploymentModel struct {
+	Format  *string `json:"format,omitempty"`
+	Name    *string `json:"name,omitempty"`
+	Version *string `json:"version,omitempty"`
+}
+
+type OpenAIDeploymentProperties struct {
+	Model *OpenAIDeploymentModel `json:"model,omitempty"`
+}
+
+type OpenAIDeployment struct {
+	ResourceBase
+
+	Sku        *OpenAIDeploymentSku        `json:"sku,omitempty"`
+	Properties *OpenAIDeploymentProperties `json:"properties,omitempty"`
+}
+
+func (dep *OpenAIDeployment) MarshalJSON() ([]byte, error) {
+	tmpDep := *dep
+	if WhyMarshal == "ARM" {
+		// Copy them so we don't touch the originals
+		sku := OpenAIDeploymentSku{}
+		if dep.Sku != nil {
+			sku = *(dep.Sku)
+		}
+		if sku.Name == nil {
+			sku.Name = StringPtr("Standard")
+		}
+		if sku.Capacity == nil {
+			sku.Capacity = IntPtr(1)
+		}
+		tmpDep.Sku = &sku
+
+		model := OpenAIDeploymentModel{}
+		if dep.Properties != nil && dep.Properties.Model != nil {
+			model = *(dep.Properties.Model)
+		}
+		if model.Name == nil {
+			ErrStop(`Missing "model" for "%s/%s"`, dep.NiceType, dep.Name)
+		}
+		if model.Format == nil {
+			model.Format = StringPtr("OpenAI")
+		}
+		tmpDep.Properties = &OpenAIDeploymentProperties{Model: &model}
+	}
+	return json.Marshal(tmpDep)
+}
+
+func (dep *OpenAIDeployment) AccountName() string {
+	acct, _, _ := strings.Cut(dep.Name, "/")
+	return acct
+}
+
+func (dep *OpenAIDeployment) DeploymentName() string {
+	_, name, _ := strings.Cut(dep.Name, "/")
+	return name
+}
+
+func (dep *OpenAIDeployment) MustSku() *OpenAIDeploymentSku {
+	if dep.Sku == nil {
+		dep.Sku = &OpenAIDeploymentSku{}
+	}
+	return dep.Sku
+}
+
+func (dep *OpenAIDeployment) MustModel() *OpenAIDeploymentModel {
+	if dep.Properties == nil {
+		dep.Properties = &OpenAIDeploymentProperties{}
+	}
+	if dep.Properties.Model == nil {
+		dep.Properties.Model = &OpenAIDeploymentModel{}
+	}
+	return dep.Properties.Model
+}
+
+func (dep *OpenAIDeployment) DependsOn() []*ResourceReference {
+	return []*ResourceReference{
+		OpenAIRef(dep.AccountName(), dep.Subscription, dep.ResourceGroup),
+	}
+}
+
+func (dep *OpenAIDeployment) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*OpenAI-Deployment(" + dep.Name + ")"
+	form.AddProp("Name", dep.DeploymentName())
+	form.AddProp("Account", dep.AccountName())
+	form.AddProp("Subscription", dep.Subscription)
+	form.AddProp("ResourceGroup", dep.ResourceGroup)
+	if props := dep.Properties; props != nil && props.Model != nil {
+		if props.Model.Name != nil {
+			form.AddProp("Model", *(props.Model.Name))
+		}
+		if props.Model.Version != nil {
+			form.AddProp("Model Version", *(props.Model.Version))
+		}
+		if props.Model.Format != nil {
+			form.AddProp("Model Format", *(props.Model.Format))
+		}
+	}
+	if sku := dep.Sku; sku != nil {
+		if sku.Name != nil {
+			form.AddProp("SKU", *(sku.Name))
+		}
+		if sku.Capacity != nil {
+			form.AddProp("Capacity", fmt.Sprintf("%d", *(sku.Capacity)))
+		}
+	}
+
+	return form
+}
+
+func (dep *OpenAIDeployment) FromForm(r *ResourceBase, f *Form) {
+	newDep := &OpenAIDeployment{
+		ResourceBase: dep.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name", "Account":
+			// Skip
+		case "Subscription":
+			newDep.Subscription = item.Value
+		case "ResourceGroup":
+			newDep.ResourceGroup = item.Value
+		case "Model":
+			newDep.MustModel().Name = StringPtr(item.Value)
+		case "Model Version":
+			newDep.MustModel().Version = StringPtr(item.Value)
+		case "Model Format":
+			newDep.MustModel().Format = StringPtr(item.Value)
+		case "SKU":
+			newDep.MustSku().Name = StringPtr(item.Value)
+		case "Capacity":
+			i, _ := strconv.Atoi(item.Value)
+			newDep.MustSku().Capacity = &i
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newDep, "", "  ")
+
+	r.Object = newDep
+	r.RawData = data
+}
+
+func (dep *OpenAIDeployment) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(dep, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (dep *OpenAIDeployment) ToJson() string {
+	data, _ := json.MarshalIndent(dep, "", "  ")
+	return string(data)
+}
+
+func (dep *OpenAIDeployment) HideServerFields() {
+}
+
+func AddOpenAIDeploymentFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddOpenAIDeploymentFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddOpenAIDeploymentFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	name = ChildName(name, "", "ACCOUNT")
+
+	dep := &OpenAIDeployment{}
+	dep.InitResource(dep, "Microsoft.CognitiveServices/accounts/deployments",
+		"openai-deployment", name)
+
+	// Default to the account's sub/rg if it's in the stage
+	oaiRef := OpenAIRef(dep.AccountName(), dep.Subscription, dep.ResourceGroup)
+	dep.Subscription = oaiRef.Subscription
+	dep.ResourceGroup = oaiRef.ResourceGroup
+
+	dep.ProcessFlags(cmd)
+	dep.SaveAndUp(cmd)
+}
+
+func UpdateOpenAIDeploymentFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdateOpenAIDeploymentFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdateOpenAIDeploymentFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	name = ChildName(name, "", "ACCOUNT")
+	dep := LoadStageResource("openai-deployment", name).
+		Object.(*OpenAIDeployment)
+
+	dep.ProcessFlags(cmd)
+	dep.SaveAndUp(cmd)
+}
+
+func (dep *OpenAIDeployment) ProcessFlags(cmd *cobra.Command) {
+	dep.ProcessResourceFlags(cmd, nil)
+
+	if cmd.Flags().Changed("model") {
+		model := FlagAsString(cmd, "model")
+		if model == "" {
+			ErrStop("The --model flag can't be empty")
+		}
+		dep.MustModel().Name = StringPtr(model)
+	}
+	if cmd.Flags().Changed("model-version") {
+		dep.MustModel().Version =
+			NilStringPtr(FlagAsString(cmd, "model-version"))
+	}
+
+	if cmd.Flags().Changed("sku") {
+		dep.MustSku().Name = NilStringPtr(FlagAsString(cmd, "sku"))
+	}
+	if cmd.Flags().Changed("capacity") {
+		val := FlagAsString(cmd, "capacity")
+		if val == "" {
+			dep.MustSku().Capacity = nil
+		} else {
+			i, err := strconv.Atoi(val)
+			NoErr(err, "Bad --capacity value %q: %s", val, err)
+			dep.MustSku().Capacity = &i
+		}
+	}
+	if dep.Sku != nil && *(dep.Sku) == (OpenAIDeploymentSku{}) {
+		dep.Sku = nil
+	}
+}
@@ -0,0 +1,845 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/duglin/dlog"
+	"github.com/spf13/cobra"
+)
+
+// Azure Database for PostgreSQL flexible servers. Not to be confused with
+// the "aca-postgres" dev service.
+
+func initPostgres() {
+	log.VPrintf(3, "Init initPostgres")
+	setupPostgresCmds()
+	setupPostgresResourceDefs()
+	RegisteredParsers = append(RegisteredParsers, PostgresFromARMJson)
+	BindHandlers["postgres"] = BindPostgres
+	BindEnvVars["postgres"] = PostgresEnvVars
+	ParamFields["Microsoft.DBforPostgreSQL/flexibleServers"] = []string{
+		"properties.administratorLoginPassword"}
+}
+
+func setupPostgresCmds() {
+	cmd := &cobra.Command{
+		Use:   "postgres",
+		Short: "Add an Azure Database for PostgreSQL flexible server",
+		Run:   AddPostgresFunc,
+	}
+	addPostgresFlags(cmd)
+	AddCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:   "postgres",
+		Short: "Update an Azure Database for PostgreSQL flexible server",
+		Run:   UpdatePostgresFunc,
+	}
+	addPostgresFlags(cmd)
+	UpdateCmd.AddCommand(cmd)
+
+	AddShowCmd("postgres", "Show details about a PostgreSQL flexible server",
+		"server")
+
+	// ---
+
+	cmd = &cobra.Command{
+		Use:   "postgres-db",
+		Short: "Add a database to a PostgreSQL flexible server",
+		Run:   AddPostgresDbFunc,
+	}
+	addPostgresDbFlags(cmd)
+	AddCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:   "postgres-db",
+		Short: "Update a database on a PostgreSQL flexible server",
+		Run:   UpdatePostgresDbFunc,
+	}
+	addPostgresDbFlags(cmd)
+	UpdateCmd.AddCommand(cmd)
+
+	AddShowCmd("postgres-db", "Show details about a PostgreSQL database",
+		"database (SERVER/NAME)")
+
+	// ---
+
+	cmd = &cobra.Command{
+		Use:   "postgres-firewall-rule",
+		Short: "Add a firewall rule to a PostgreSQL flexible server",
+		Run:   AddPostgresFirewallRuleFunc,
+	}
+	addPostgresFirewallRuleFlags(cmd)
+	AddCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:   "postgres-firewall-rule",
+		Short: "Update a firewall rule on a PostgreSQL flexible server",
+		Run:   UpdatePostgresFirewallRuleFunc,
+	}
+	addPostgresFirewallRuleFlags(cmd)
+	UpdateCmd.AddCommand(cmd)
+
+	AddShowCmd("postgres-firewall-rule",
+		"Show details about a PostgreSQL firewall rule", "rule (SERVER/NAME)")
+}
+
+func addPostgresFlags(cmd *cobra.Command) {
+	AddResourceFlags(cmd, "server")
+	cmd.Flags().String("sku", "", "SKU (e.g. Standard_B1ms, Standard_D2s_v3)")
+	cmd.Flags().String("version", "", "PostgreSQL version (e.g. 16)")
+	cmd.Flags().String("storage", "", "Storage size in GB")
+	cmd.Flags().String("admin-login", "", "Name of the admin user")
+	cmd.Flags().String("admin-password-param", "",
+		"Env var holding the admin password (default: NAME_ADMIN_PASSWORD)")
+}
+
+func addPostgresDbFlags(cmd *cobra.Command) {
+	AddResourceFlags(cmd, "database (SERVER/NAME)")
+	cmd.Flags().String("charset", "", "Character set (e.g. UTF8)")
+	cmd.Flags().String("collation", "", "Collation (e.g. en_US.utf8)")
+}
+
+func addPostgresFirewallRuleFlags(cmd *cobra.Command) {
+	AddResourceFlags(cmd, "rule (SERVER/NAME)")
+	cmd.Flags().String("start-ip", "", "First IP address allowed")
+	cmd.Flags().String("end-ip", "", "Last IP address allowed "+
+		"(default: --start-ip). Use 0.0.0.0 for both to allow Azure services")
+}
+
+func setupPostgresResourceDefs() {
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.DBforPostgreSQL/flexibleServers",
+		URL:  "https://management.azure.com/subscriptions/${SUBSCRIPTION}/resourceGroups/${RESOURCEGROUP}/providers/Microsoft.DBforPostgreSQL/flexibleServers/${NAME}?api-version=${APIVERSION}",
+		Defaults: map[string]string{
+			"APIVERSION": "2022-12-01",
+			"WAIT":       "true",
+			"WAITPROP":   "state",
+			"WAITBUSY":   "Provisioning,Starting,Updating",
+			"WAITDONE":   "Ready",
+		},
+	})
+	ResourceAliases["postgres"] = "Microsoft.DBforPostgreSQL/flexibleServers"
+
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.DBforPostgreSQL/flexibleServers/databases",
+		Defaults: map[string]string{
+			"APIVERSION": "2022-12-01",
+		},
+	})
+	ResourceAliases["postgres-db"] =
+		"Microsoft.DBforPostgreSQL/flexibleServers/databases"
+
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.DBforPostgreSQL/flexibleServers/firewallRules",
+		Defaults: map[string]string{
+			"APIVERSION": "2022-12-01",
+		},
+	})
+	ResourceAliases["postgres-firewall-rule"] =
+		"Microsoft.DBforPostgreSQL/flexibleServers/firewallRules"
+}
+
+// Returns a reference to a PostgreSQL server. If it's in the stage then
+// its sub/rg are used.
+func PostgresRef(name string, sub string, rg string) *ResourceReference {
+	resRef := &ResourceReference{
+		Subscription:  sub,
+		ResourceGroup: rg,
+		Type:          "Microsoft.DBforPostgreSQL/flexibleServers",
+		APIVersion:    GetResourceDef("Microsoft.DBforPostgreSQL/flexibleServers").Defaults["APIVERSION"],
+		Name:          name,
+		Origin:        name,
+	}
+
+	stage := GetConfigProperty("currentStage")
+	if res, err := ResourceFromFile(stage, ResourceFileName("postgres", name)); err == nil {
+		resRef.Subscription = res.Subscription
+		resRef.ResourceGroup = res.ResourceGroup
+	}
+
+	return resRef
+}
+
+// The tier is implied by the SKU's VM series
+func PostgresTier(sku string) string {
+	switch {
+	case strings.HasPrefix(sku, "Standard_B"):
+		return "Burstable"
+	case strings.HasPrefix(sku, "Standard_E"):
+		return "MemoryOptimized"
+	}
+	return "GeneralPurpose"
+}
+
+// The env vars that a postgres bind sets
+var PostgresEnvVars = []string{"PGHOST", "PGPORT", "PGDATABASE", "PGUSER",
+	"PGPASSWORD", "PGSSLMODE"}
+
+// The default name of the env var holding the admin password of "server"
+func PostgresPasswordParam(server string) string {
+	return strings.ToUpper(strings.ReplaceAll(server, "-", "_")) +
+		"_ADMIN_PASSWORD"
+}
+
+type PostgresSku struct {
+	Name *string `json:"name,omitempty"`
+	Tier *string `json:"tier,omitempty"`
+}
+
+type PostgresStorage struct {
+	StorageSizeGB *int `json:"storageSizeGB,omitempty"`
+}
+
+type PostgresProperties struct {
+	Version            *string `json:"version,omitempty"`
+	AdministratorLogin *string `json:"administratorLogin,omitempty"`
+
+	// Never the password itself, just a "${PARAM}" reference to it
+	AdministratorLoginPassword *string          `json:"administratorLoginPassword,omitempty"`
+	Storage                    *PostgresStorage `json:"storage,omitempty"`
+}
+
+type Postgres struct {
+	ResourceBase
+
+	Location   *string             `json:"location,omitempty"`
+	Sku        *PostgresSku        `json:"sku,omitempty"`
+	Properties *PostgresProperties `json:"properties,omitempty"`
+}
+
+func (pg *Postgres) MarshalJSON() ([]byte, error) {
+	tmpPg := *pg
+	if WhyMarshal == "ARM" {
+		if tmpPg.Location == nil {
+			tmpPg.Location = StringPtr(GetConfigProperty("defaults.Location"))
+		}
+		if tmpPg.Location == nil || *(tmpPg.Location) == "" {
+			ErrStop(`Missing "location" for "%s/%s"`, pg.NiceType, pg.Name)
+		}
+
+		sku := PostgresSku{Name: StringPtr("Standard_B1ms")}
+		if pg.Sku != nil && pg.Sku.Name != nil {
+			sku = *(pg.Sku)
+		}
+		if sku.Tier == nil {
+			sku.Tier = StringPtr(PostgresTier(*sku.Name))
+		}
+		tmpPg.Sku = &sku
+
+		// Copy it so we don't touch the original
+		props := PostgresProperties{}
+		if pg.Properties != nil {
+			props = *(pg.Properties)
+		}
+		tmpPg.Properties = &props
+
+		if props.Version == nil {
+			props.Version = StringPtr("16")
+		}
+		if props.AdministratorLogin == nil {
+			props.AdministratorLogin = StringPtr("azxadmin")
+		}
+		props.AdministratorLoginPassword = StringPtr("${" + pg.PasswordParam() + "}")
+		if props.Storage == nil || props.Storage.StorageSizeGB == nil {
+			props.Storage = &PostgresStorage{StorageSizeGB: IntPtr(32)}
+		}
+	}
+	return json.Marshal(tmpPg)
+}
+
+func (pg *Postgres) MustProperties() *PostgresProperties {
+	if pg.Properties == nil {
+		pg.Properties = &PostgresProperties{}
+	}
+	return pg.Properties
+}
+
+// Name of the env var that holds the admin password
+func (pg *Postgres) PasswordParam() string {
+	if pg.Properties != nil {
+		match := paramRE.FindStringSubmatch(NotNil(pg.Properties.AdministratorLoginPassword))
+		if match != nil {
+			return match[1]
+		}
+	}
+	return PostgresPasswordParam(pg.Name)
+}
+
+func (pg *Postgres) DependsOn() []*ResourceReference {
+	return []*ResourceReference{}
+}
+
+func (pg *Postgres) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*Postgres(" + pg.Name + ")"
+	form.AddProp("Name", pg.Name)
+	if NotNil(pg.Location) != "" {
+		form.AddProp("Location", NotNil(pg.Location))
+	}
+	form.AddProp("Subscription", pg.Subscription)
+	form.AddProp("ResourceGroup", pg.ResourceGroup)
+	if pg.Sku != nil && pg.Sku.Name != nil {
+		form.AddProp("SKU", *(pg.Sku.Name))
+	}
+	if props := pg.Properties; props != nil {
+		if props.Version != nil {
+			form.AddProp("Version", *(props.Version))
+		}
+		if props.Storage != nil && props.Storage.StorageSizeGB != nil {
+			form.AddProp("Storage", fmt.Sprintf("%d", *(props.Storage.StorageSizeGB)))
+		}
+		if props.AdministratorLogin != nil {
+			form.AddProp("Admin Login", *(props.AdministratorLogin))
+		}
+		// Only show it when it's a reference, never a resolved password
+		pwd := NotNil(props.AdministratorLoginPassword)
+		if paramRE.MatchString(pwd) {
+			form.AddProp("Admin Password", pwd)
+		}
+	}
+
+	return form
+}
+
+func (pg *Postgres) FromForm(r *ResourceBase, f *Form) {
+	newPg := &Postgres{
+		ResourceBase: pg.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name":
+			// Skip
+		case "Location":
+			newPg.Location = StringPtr(item.Value)
+		case "Subscription":
+			newPg.Subscription = item.Value
+		case "ResourceGroup":
+			newPg.ResourceGroup = item.Value
+		case "SKU":
+			newPg.Sku = &PostgresSku{Name: StringPtr(item.Value)}
+		case "Version":
+			newPg.MustProperties().Version = StringPtr(item.Value)
+		case "Storage":
+			i, _ := strconv.Atoi(item.Value)
+			newPg.MustProperties().Storage = &PostgresStorage{StorageSizeGB: &i}
+		case "Admin Login":
+			newPg.MustProperties().AdministratorLogin = StringPtr(item.Value)
+		case "Admin Password":
+			newPg.MustProperties().AdministratorLoginPassword =
+				StringPtr(item.Value)
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newPg, "", "  ")
+
+	r.Object = newPg
+	r.RawData = data
+}
+
+func (pg *Postgres) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(pg, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (pg *Postgres) ToJson() string {
+	data, _ := json.MarshalIndent(pg, "", "  ")
+	return string(data)
+}
+
+func (pg *Postgres) HideServerFields() {
+	// Azure never returns the password, so don't compare our reference to it
+	if pg.Properties != nil {
+		pg.Properties.AdministratorLoginPassword = nil
+	}
+
+	// Azure always returns the tier, we only keep it if it's not the default
+	if pg.Sku != nil && NotNil(pg.Sku.Tier) == PostgresTier(NotNil(pg.Sku.Name)) {
+		pg.Sku.Tier = nil
+	}
+}
+
+func (pg *Postgres) HideSecrets() {
+	if pg.Properties != nil {
+		pg.Properties.AdministratorLoginPassword = nil
+	}
+}
+
+func PostgresFromARMJson(data []byte) *ResourceBase {
+	pg := &Postgres{}
+	if res := ParseARMResource(data, "Microsoft.DBforPostgreSQL/flexibleServers",
+		"postgres", pg, &pg.ResourceBase); res != nil {
+		return res
+	}
+
+	db := &PostgresDb{}
+	if res := ParseARMResource(data,
+		"Microsoft.DBforPostgreSQL/flexibleServers/databases",
+		"postgres-db", db, &db.ResourceBase); res != nil {
+		return res
+	}
+
+	fr := &PostgresFirewallRule{}
+	return ParseARMResource(data,
+		"Microsoft.DBforPostgreSQL/flexibleServers/firewallRules",
+		"postgres-firewall-rule", fr, &fr.ResourceBase)
+}
+
+func AddPostgresFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddPostgresFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddPostgresFunc")
+
+	pg := &Postgres{}
+	name, _ := cmd.Flags().GetString("name")
+	pg.InitResource(pg, "Microsoft.DBforPostgreSQL/flexibleServers",
+		"postgres", name)
+	pg.Location = StringPtr(GetConfigProperty("defaults.Location"))
+
+	pg.ProcessFlags(cmd)
+	pg.SaveAndUp(cmd)
+}
+
+func UpdatePostgresFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdatePostgresFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdatePostgresFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	pg := LoadStageResource("postgres", name).Object.(*Postgres)
+
+	pg.ProcessFlags(cmd)
+	pg.SaveAndUp(cmd)
+}
+
+func (pg *Postgres) ProcessFlags(cmd *cobra.Command) {
+	pg.ProcessResourceFlags(cmd, &pg.Location)
+
+	if cmd.Flags().Changed("sku") {
+		sku := FlagAsString(cmd, "sku")
+		if sku == "" {
+			pg.Sku = nil
+		} else {
+			pg.Sku = &PostgresSku{Name: StringPtr(sku)}
+		}
+	}
+
+	if cmd.Flags().Changed("version") {
+		pg.MustProperties().Version = NilStringPtr(FlagAsString(cmd, "version"))
+	}
+
+	if cmd.Flags().Changed("storage") {
+		val := FlagAsString(cmd, "storage")
+		if val == "" {
+			pg.MustProperties().Storage = nil
+		} else {
+			i, err := strconv.Atoi(val)
+			NoErr(err, "Bad --storage value %q: %s", val, err)
+			pg.MustProperties().Storage = &PostgresStorage{StorageSizeGB: &i}
+		}
+	}
+
+	if cmd.Flags().Changed("admin-login") {
+		pg.MustProperties().AdministratorLogin =
+			NilStringPtr(FlagAsString(cmd, "admin-login"))
+	}
+
+	if cmd.Flags().Changed("admin-password-param") {
+		param := FlagAsString(cmd, "admin-password-param")
+		if param == "" {
+			pg.MustProperties().AdministratorLoginPassword = nil
+		} else {
+			pwd := "${" + param + "}"
+			if !paramRE.MatchString(pwd) {
+				ErrStop("Bad --admin-password-param value %q, must be the "+
+					"name of an env var", param)
+			}
+			pg.MustProperties().AdministratorLoginPassword = StringPtr(pwd)
+		}
+	}
+
+	if pg.Properties != nil && *(pg.Properties) == (PostgresProperties{}) {
+		pg.Properties = nil
+	}
+}
+
+// Processes "--bind postgres/SERVER[/DB]" (and "--unbind"). The container
+// gets the standard libpq env vars (PGHOST, PGDATABASE, ...) with the admin
+// password in a secret whose value is the server's "${PARAM}" reference.
+// Since the env vars are fixed names a container can only be bound to one
+// database.
+func BindPostgres(holder AcaContainerHolder, name string, bind bool) {
+	server, db, found := strings.Cut(name, "/")
+	if !found {
+		db = "postgres"
+	}
+	if server == "" || db == "" {
+		ErrStop("Bad postgres binding %q, must be of the form: "+
+			"postgres/SERVER[/DATABASE]", name)
+	}
+
+	container := MainContainer(holder.MustContainers())
+	secretName := strings.ToLower(server) + "-pg-password"
+	pgRef := PostgresRef(server, "", "")
+	host := pgRef.AsSubstitution("properties.fullyQualifiedDomainName")
+
+	if !bind {
+		env := container.FindEnv("PGHOST")
+		if env == nil || NotNil(env.Value) != host {
+			ErrStop("Binding \"postgres/%s\" was not found", name)
+		}
+		for _, env := range PostgresEnvVars {
+			container.RemoveEnv(env)
+		}
+		RemoveSecret(holder.MustSecrets(), secretName)
+		return
+	}
+
+	param := PostgresPasswordParam(server)
+	stage := GetConfigProperty("currentStage")
+	if res, err := ResourceFromFile(stage, ResourceFileName("postgres", server)); err == nil {
+		param = res.Object.(*Postgres).PasswordParam()
+	}
+
+	SetSecret(holder.MustSecrets(), &AcaAppSecret{
+		Name:  StringPtr(secretName),
+		Value: StringPtr("${" + param + "}"),
+	})
+
+	for _, env := range []*AcaAppEnv{
+		{Name: StringPtr("PGHOST"), Value: StringPtr(host)},
+		{Name: StringPtr("PGPORT"), Value: StringPtr("5432")},
+		{Name: StringPtr("PGDATABASE"), Value: StringPtr(db)},
+		{Name: StringPtr("PGUSER"), Value: StringPtr(
+			pgRef.AsSubstitution("properties.administratorLogin"))},
+		{Name: StringPtr("PGPASSWORD"), SecretRef: StringPtr(secretName)},
+		{Name: StringPtr("PGSSLMODE"), Value: StringPtr("require")},
+	} {
+		container.SetEnvVar(env)
+	}
+}
+
+// ---
+
+type PostgresDbProperties struct {
+	Charset   *string `json:"charset,omitempty"`
+	Collation *string `json:"collation,omitempty"`
+}
+
+type PostgresDb struct {
+	ResourceBase
+
+	Properties *PostgresDbProperties `json:"properties,omitempty"`
+}
+
+func (db *PostgresDb) ServerName() string {
+	server, _, _ := strings.Cut(db.Name, "/")
+	return server
+}
+
+func (db *PostgresDb) DbName() string {
+	_, name, _ := strings.Cut(db.Name, "/")
+	return name
+}
+
+func (db *PostgresDb) MustProperties() *PostgresDbProperties {
+	if db.Properties == nil {
+		db.Properties = &PostgresDbProperties{}
+	}
+	return db.Properties
+}
+
+func (db *PostgresDb) DependsOn() []*ResourceReference {
+	return []*ResourceReference{
+		PostgresRef(db.ServerName(), db.Subscription, db.ResourceGroup),
+	}
+}
+
+func (db *PostgresDb) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*Postgres-DB(" + db.Name + ")"
+	form.AddProp("Name", db.DbName())
+	form.AddProp("Server", db.ServerName())
+	form.AddProp("Subscription", db.Subscription)
+	form.AddProp("ResourceGroup", db.ResourceGroup)
+	if props := db.Properties; props != nil {
+		if props.Charset != nil {
+			form.AddProp("Charset", *(props.Charset))
+		}
+		if props.Collation != nil {
+			form.AddProp("Collation", *(props.Collation))
+		}
+	}
+
+	return form
+}
+
+func (db *PostgresDb) FromForm(r *ResourceBase, f *Form) {
+	newDb := &PostgresDb{
+		ResourceBase: db.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name", "Server":
+			// Skip
+		case "Subscription":
+			newDb.Subscription = item.Value
+		case "ResourceGroup":
+			newDb.ResourceGroup = item.Value
+		case "Charset":
+			newDb.MustProperties().Charset = StringPtr(item.Value)
+		case "Collation":
+			newDb.MustProperties().Collation = StringPtr(item.Value)
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newDb, "", "  ")
+
+	r.Object = newDb
+	r.RawData = data
+}
+
+func (db *PostgresDb) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(db, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (db *PostgresDb) ToJson() string {
+	data, _ := json.MarshalIndent(db, "", "  ")
+	return string(data)
+}
+
+func (db *PostgresDb) HideServerFields() {
+}
+
+func AddPostgresDbFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddPostgresDbFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddPostgresDbFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	name = ChildName(name, "", "SERVER")
+
+	db := &PostgresDb{}
+	db.InitResource(db, "Microsoft.DBforPostgreSQL/flexibleServers/databases",
+		"postgres-db", name)
+
+	// Default to the server's sub/rg if it's in the stage
+	pgRef := PostgresRef(db.ServerName(), db.Subscription, db.ResourceGroup)
+	db.Subscription = pgRef.Subscription
+	db.ResourceGroup = pgRef.ResourceGroup
+
+	db.ProcessFlags(cmd)
+	db.SaveAndUp(cmd)
+}
+
+func UpdatePostgresDbFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdatePostgresDbFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdatePostgresDbFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	name = ChildName(name, "", "SERVER")
+	db := LoadStageResource("postgres-db", name).Object.(*PostgresDb)
+
+	db.ProcessFlags(cmd)
+	db.SaveAndUp(cmd)
+}
+
+func (db *PostgresDb) ProcessFlags(cmd *cobra.Command) {
+	db.ProcessResourceFlags(cmd, nil)
+
+	if cmd.Flags().Changed("charset") {
+		db.MustProperties().Charset = NilStringPtr(FlagAsString(cmd, "charset"))
+	}
+	if cmd.Flags().Changed("collation") {
+		db.MustProperties().Collation =
+			NilStringPtr(FlagAsString(cmd, "collation"))
+	}
+	if db.Properties != nil && *(db.Properties) == (PostgresDbProperties{}) {
+		db.Properties = nil
+	}
+}
+
+// ---
+
+type PostgresFirewallRuleProperties struct {
+	StartIpAddress *string `json:"startIpAddress,omitempty"`
+	EndIpAddress   *string `json:"endIpAddress,omitempty"`
+}
+
+type PostgresFirewallRule struct {
+	ResourceBase
+
+	Properties *PostgresFirewallRuleProperties `json:"properties,omitempty"`
+}
+
+func (fr *PostgresFirewallRule) MarshalJSON() ([]byte, error) {
+	tmpFr := *fr
+	if WhyMarshal == "ARM" {
+		// Copy it so we don't touch the original
+		props := PostgresFirewallRuleProperties{}
+		if fr.Properties != nil {
+			props = *(fr.Properties)
+		}
+		tmpFr.Properties = &props
+
+		if props.StartIpAddress == nil {
+			ErrStop(`Missing "start-ip" for "%s/%s"`, fr.NiceType, fr.Name)
+		}
+		if props.EndIpAddress == nil {
+			props.EndIpAddress = props.StartIpAddress
+		}
+	}
+	return json.Marshal(tmpFr)
+}
+
+func (fr *PostgresFirewallRule) ServerName() string {
+	server, _, _ := strings.Cut(fr.Name, "/")
+	return server
+}
+
+func (fr *PostgresFirewallRule) RuleName() string {
+	_, name, _ := strings.Cut(fr.Name, "/")
+	return name
+}
+
+func (fr *PostgresFirewallRule) MustProperties() *PostgresFirewallRuleProperties {
+	if fr.Properties == nil {
+		fr.Properties = &PostgresFirewallRuleProperties{}
+	}
+	return fr.Properties
+}
+
+func (fr *PostgresFirewallRule) DependsOn() []*ResourceReference {
+	return []*ResourceReference{
+		PostgresRef(fr.ServerName(), fr.Subscription, fr.ResourceGroup),
+	}
+}
+
+func (fr *PostgresFirewallRule) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*Postgres-Firewall-Rule(" + fr.Name + ")"
+	form.AddProp("Name", fr.RuleName())
+	form.AddProp("Server", fr.ServerName())
+	form.AddProp("Subscription", fr.Subscription)
+	form.AddProp("ResourceGroup", fr.ResourceGroup)
+	if props := fr.Properties; props != nil {
+		if props.StartIpAddress != nil {
+			form.AddProp("Start IP", *(props.StartIpAddress))
+		}
+		if props.EndIpAddress != nil {
+			form.AddProp("End IP", *(props.EndIpAddress))
+		}
+	}
+
+	return form
+}
+
+func (fr *PostgresFirewallRule) FromForm(r *ResourceBase, f *Form) {
+	newFr := &PostgresFirewallRule{
+		ResourceBase: fr.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name", "Server":
+			// Skip
+		case "Subscription":
+			newFr.Subscription = item.Value
+		case "ResourceGroup":
+			newFr.ResourceGroup = item.Value
+		case "Start IP":
+			newFr.MustProperties().StartIpAddress = StringPtr(item.Value)
+		case "End IP":
+			newFr.MustProperties().EndIpAddress = StringPtr(item.Value)
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newFr, "", "  ")
+
+	r.Object = newFr
+	r.RawData = data
+}
+
+func (fr *PostgresFirewallRule) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(fr, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (fr *PostgresFirewallRule) ToJson() string {
+	data, _ := json.MarshalIndent(fr, "", "  ")
+	return string(data)
+}
+
+func (fr *PostgresFirewallRule) HideServerFields() {
+}
+
+func AddPostgresFirewallRuleFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddPostgresFirewallRuleFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddPostgresFirewallRuleFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	name = ChildName(name, "", "SERVER")
+
+	fr := &PostgresFirewallRule{}
+	fr.InitResource(fr, "Microsoft.DBforPostgreSQL/flexibleServers/firewallRules",
+		"postgres-firewall-rule", name)
+
+	// Default to the server's sub/rg if it's in the stage
+	pgRef := PostgresRef(fr.ServerName(), fr.Subscription, fr.ResourceGroup)
+	fr.Subscription = pgRef.Subscription
+	fr.ResourceGroup = pgRef.ResourceGroup
+
+	fr.ProcessFlags(cmd)
+	if fr.Properties == nil || fr.Properties.StartIpAddress == nil {
+		ErrStop("Missing the --start-ip flag")
+	}
+	fr.SaveAndUp(cmd)
+}
+
+func UpdatePostgresFirewallRuleFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdatePostgresFirewallRuleFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdatePostgresFirewallRuleFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	name = ChildName(name, "", "SERVER")
+	fr := LoadStageResource("postgres-firewall-rule", name).
+		Object.(*PostgresFirewallRule)
+
+	fr.ProcessFlags(cmd)
+	fr.SaveAndUp(cmd)
+}
+
+func (fr *PostgresFirewallRule) ProcessFlags(cmd *cobra.Command) {
+	fr.ProcessResourceFlags(cmd, nil)
+
+	if cmd.Flags().Changed("start-ip") {
+		fr.MustProperties().StartIpAddress =
+			NilStringPtr(FlagAsString(cmd, "start-ip"))
+	}
+	if cmd.Flags().Changed("end-ip") {
+		fr.MustProperties().EndIpAddress =
+			NilStringPtr(FlagAsString(cmd, "end-ip"))
+	}
+	if fr.Properties != nil &&
+		*(fr.Properties) == (PostgresFirewallRuleProperties{}) {
+		fr.Properties = nil
+	}
+}
@@ -8,9 +8,12 @@ import (
 )
 
 func initRedis() {
+	// There's no redis resource yet, but Dapr components can use an
+	// existing Azure Cache for Redis via its "${...}" references
+	setupRedisResourceDefs()
+	DaprComponentSources["redis"] = DaprFromRedis
 	return
 	setupRedisCmds()
-	setupRedisResourceDefs()
 	RegisteredParsers = append(RegisteredParsers, RedisFromARMJson)
 }
 
@@ -41,6 +44,9 @@ func setupRedisResourceDefs() {
 		Defaults: map[string]string{
 			"APIVERSION": "2023-04-01",
 		},
+		Actions: map[string]string{
+			"keys": "listKeys",
+		},
 	})
 
 }
@@ -51,8 +57,8 @@ type RedisAppConfiguration struct {
 		TargetPort    *int  `json:"targetPort,omitempty"`
 		CustomDomains []*struct {
 			Name          *string `json:"name,omitempty"`
-			BindingType   *string `json:bindingType,omitempty"`
-			CertificateId *string `json:certificateId,omitempty"`
+			BindingType   *string `json:"bindingType,omitempty"`
+			CertificateId *string `json:"certificateId,omitempty"`
 		} `json:"customDomains,omitempty"`
 		Traffic []*struct {
 		} `json:"traffic,omitempty"`
@@ -164,3 +170,29 @@ func (r  *Redis) Save() {
 
 func (r *Redis) HideServerFields() {
 }
+
+// Points the Dapr component at an Azure Cache for Redis, in the component's
+// resource group, over its TLS port
+func DaprFromRedis(comp *DaprComponent, name string) {
+	redisRef := &ResourceReference{
+		Subscription:  comp.Subscription,
+		ResourceGroup: comp.ResourceGroup,
+		Type:          "Microsoft.Cache/redis",
+		APIVersion:    GetResourceDef("Microsoft.Cache/redis").Defaults["APIVERSION"],
+		Name:          name,
+		Origin:        name,
+	}
+	props := comp.MustProperties()
+	if props.ComponentType == nil {
+		props.ComponentType = StringPtr("state.redis")
+	}
+
+	SetSecret(&props.Secrets, &AcaAppSecret{
+		Name:  StringPtr("redis-password"),
+		Value: StringPtr(redisRef.AsSubstitution("keys.primaryKey")),
+	})
+	comp.SetMetadata("redisHost",
+		redisRef.AsSubstitution("properties.hostName")+":6380", "")
+	comp.SetMetadata("redisPassword", "", "redis-password")
+	comp.SetMetadata("enableTLS", "true", "")
+}
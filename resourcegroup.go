@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/duglin/dlog"
+	"github.com/spf13/cobra"
+)
+
+const ResourceGroupType = "Microsoft.Resources/resourceGroups"
+
+func initResourceGroup() {
+	log.VPrintf(3, "Init initResourceGroup")
+	setupResourceGroupCmds()
+	setupResourceGroupResourceDefs()
+	RegisteredParsers = append(RegisteredParsers, ResourceGroupFromARMJson)
+}
+
+func setupResourceGroupCmds() {
+	cmd := &cobra.Command{
+		Use:   "resource-group",
+		Short: "Add a resource group",
+		Run:   AddResourceGroupFunc,
+	}
+	addResourceGroupFlags(cmd)
+	AddCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:   "resource-group",
+		Short: "Update a resource group",
+		Run:   UpdateResourceGroupFunc,
+	}
+	addResourceGroupFlags(cmd)
+	UpdateCmd.AddCommand(cmd)
+
+	AddShowCmd("resource-group", "Show details about a resource group",
+		"resource group")
+}
+
+// Resource groups aren't in a resource group so there's no "-g" flag
+func addResourceGroupFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("name", "n", "", "Name of resource group")
+	cmd.Flags().StringP("subscription", "s", "", "Subscription ID")
+	cmd.Flags().StringP("location", "l", "", "Location")
+	cmd.Flags().StringArray("tag", nil, "Tag (KEY=VALUE, or KEY to remove)")
+	cmd.Flags().Bool("up", false, "Provision after update")
+	cmd.MarkFlagRequired("name")
+}
+
+func setupResourceGroupResourceDefs() {
+	AddResourceDef(&ResourceDef{
+		Type: ResourceGroupType,
+		URL:  "https://management.azure.com/subscriptions/${SUBSCRIPTION}/resourcegroups/${NAME}?api-version=${APIVERSION}",
+		Defaults: map[string]string{
+			"APIVERSION": "2021-04-01",
+		},
+	})
+	ResourceAliases["resource-group"] = ResourceGroupType
+	ResourceAliases["ResourceGroup"] = ResourceGroupType
+}
+
+// Returns a reference to the "rg" resource group in "sub"
+func ResourceGroupRef(sub string, rg string) *ResourceReference {
+	return &ResourceReference{
+		Subscription:  sub,
+		ResourceGroup: rg,
+		Type:          ResourceGroupType,
+		Name:          rg,
+		APIVersion:    GetResourceDef(ResourceGroupType).Defaults["APIVERSION"],
+		Origin:        rg,
+	}
+}
+
+func IsResourceGroup(resType string) bool {
+	return strings.EqualFold(resType, ResourceGroupType)
+}
+
+// Prints the resource groups used by "resources" that aren't in the stage,
+// since "up" can't create them they must already exist in Azure
+func CheckResourceGroups(resources []*ResourceBase) {
+	stageRes := GetStageResources("")
+
+	missing := map[string][]string{} // RG ID -> "type/name" of users
+	for _, res := range resources {
+		if IsResourceGroup(res.Type) {
+			continue
+		}
+		rgID := ResourceGroupRef(res.Subscription, res.ResourceGroup).AsID()
+		if stageRes[strings.ToLower(rgID)] == nil {
+			missing[rgID] = append(missing[rgID], res.NiceType+"/"+res.Name)
+		}
+	}
+
+	ids := []string{}
+	for id, _ := range missing {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		users := missing[id]
+		sort.Strings(users)
+		rr := parseIDParts(id)
+		fmt.Printf("Preflight: resource group %q (subscription %q) isn't in "+
+			"the stage, it must already exist. Used by: %s\n",
+			rr.Name, rr.Subscription, strings.Join(users, ", "))
+	}
+}
+
+type ResourceGroup struct {
+	ResourceBase
+
+	Location *string           `json:"location,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+func (rg *ResourceGroup) MarshalJSON() ([]byte, error) {
+	tmpRg := *rg
+	if WhyMarshal == "ARM" {
+		if tmpRg.Location == nil {
+			tmpRg.Location = StringPtr(GetConfigProperty("defaults.Location"))
+		}
+		if tmpRg.Location == nil || *(tmpRg.Location) == "" {
+			ErrStop(`Missing "location" for "%s/%s"`, rg.NiceType, rg.Name)
+		}
+	}
+	return json.Marshal(tmpRg)
+}
+
+func (rg *ResourceGroup) DependsOn() []*ResourceReference {
+	return []*ResourceReference{}
+}
+
+func (rg *ResourceGroup) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*ResourceGroup(" + rg.Name + ")"
+	form.AddProp("Name", rg.Name)
+	if NotNil(rg.Location) != "" {
+		form.AddProp("Location", NotNil(rg.Location))
+	}
+	form.AddProp("Subscription", rg.Subscription)
+
+	if len(rg.Tags) > 0 {
+		keys := []string{}
+		for k := range rg.Tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		nf := form.AddSection("Tags", "")
+		for _, k := range keys {
+			nf.AddProp(k, rg.Tags[k])
+		}
+	}
+
+	return form
+}
+
+func (rg *ResourceGroup) FromForm(r *ResourceBase, f *Form) {
+	newRg := &ResourceGroup{
+		ResourceBase: rg.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name":
+			// Skip
+		case "Location":
+			newRg.Location = StringPtr(item.Value)
+		case "Subscription":
+			newRg.Subscription = item.Value
+		case "Tags":
+			newRg.Tags = map[string]string{}
+			for _, tag := range item.Items {
+				newRg.Tags[tag.Title] = tag.Value
+			}
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newRg, "", "  ")
+
+	r.Object = newRg
+	r.RawData = data
+}
+
+func (rg *ResourceGroup) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(rg, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (rg *ResourceGroup) ToJson() string {
+	data, _ := json.MarshalIndent(rg, "", "  ")
+	return string(data)
+}
+
+func (rg *ResourceGroup) HideServerFields() {
+}
+
+func ResourceGroupFromARMJson(data []byte) *ResourceBase {
+	rg := &ResourceGroup{}
+	return ParseARMResource(data, ResourceGroupType, "resource-group",
+		rg, &rg.ResourceBase)
+}
+
+func AddResourceGroupFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddResourceGroupFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddResourceGroupFunc")
+
+	rg := &ResourceGroup{}
+	name, _ := cmd.Flags().GetString("name")
+	rg.InitResource(rg, ResourceGroupType, "resource-group", name)
+	rg.ResourceGroup = name
+	rg.Location = StringPtr(GetConfigProperty("defaults.Location"))
+
+	// If default isn't set, use this one
+	if GetConfigProperty("defaults.ResourceGroup") == "" {
+		SetConfigProperty("defaults.ResourceGroup", name, false)
+	}
+
+	rg.ProcessFlags(cmd)
+	rg.SaveAndUp(cmd)
+}
+
+func UpdateResourceGroupFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdateResourceGroupFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdateResourceGroupFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	rg := LoadStageResource("resource-group", name).Object.(*ResourceGroup)
+
+	rg.ProcessFlags(cmd)
+	rg.SaveAndUp(cmd)
+}
+
+func (rg *ResourceGroup) ProcessFlags(cmd *cobra.Command) {
+	rg.ProcessResourceFlags(cmd, &rg.Location)
+
+	tags, _ := cmd.Flags().GetStringArray("tag")
+	for _, tag := range tags {
+		key, value, found := strings.Cut(tag, "=")
+		if key == "" {
+			ErrStop("Tag %q must be of the form: KEY=VALUE", tag)
+		}
+		if !found {
+			delete(rg.Tags, key)
+			continue
+		}
+		if rg.Tags == nil {
+			rg.Tags = map[string]string{}
+		}
+		rg.Tags[key] = value
+	}
+	if len(rg.Tags) == 0 {
+		rg.Tags = nil
+	}
+}
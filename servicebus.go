@@ -0,0 +1,494 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/duglin/dlog"
+	"github.com/spf13/cobra"
+)
+
+func initServiceBus() {
+	log.VPrintf(3, "Init initServiceBus")
+	setupServiceBusCmds()
+	setupServiceBusResourceDefs()
+	RegisteredParsers = append(RegisteredParsers, ServiceBusFromARMJson)
+	DaprComponentSources["servicebus"] = DaprFromServiceBus
+}
+
+func setupServiceBusCmds() {
+	cmd := &cobra.Command{
+		Use:   "servicebus",
+		Short: "Add an Azure Service Bus namespace",
+		Run:   AddServiceBusFunc,
+	}
+	AddResourceFlags(cmd, "namespace")
+	cmd.Flags().String("sku", "", "'Basic', 'Standard' or 'Premium'")
+	AddCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:   "servicebus",
+		Short: "Update an Azure Service Bus namespace",
+		Run:   UpdateServiceBusFunc,
+	}
+	AddResourceFlags(cmd, "namespace")
+	cmd.Flags().String("sku", "", "'Basic', 'Standard' or 'Premium'")
+	UpdateCmd.AddCommand(cmd)
+
+	AddShowCmd("servicebus", "Show details about an Azure Service Bus namespace",
+		"namespace")
+
+	// ---
+
+	for _, entity := range []string{"queue", "topic"} {
+		noun := "servicebus-" + entity
+		what := "an Azure Service Bus " + entity
+
+		cmd = &cobra.Command{
+			Use:   noun,
+			Short: "Add " + what,
+			Run:   AddServiceBusEntityFunc,
+		}
+		AddResourceFlags(cmd, entity+" (NAMESPACE/NAME)")
+		cmd.Flags().String("max-size", "", "Max size of the "+entity+" in MB")
+		if entity == "queue" {
+			cmd.Flags().String("max-delivery-count", "",
+				"# of deliveries before a message is dead-lettered")
+		}
+		AddCmd.AddCommand(cmd)
+
+		cmd = &cobra.Command{
+			Use:   noun,
+			Short: "Update " + what,
+			Run:   UpdateServiceBusEntityFunc,
+		}
+		AddResourceFlags(cmd, entity+" (NAMESPACE/NAME)")
+		cmd.Flags().String("max-size", "", "Max size of the "+entity+" in MB")
+		if entity == "queue" {
+			cmd.Flags().String("max-delivery-count", "",
+				"# of deliveries before a message is dead-lettered")
+		}
+		UpdateCmd.AddCommand(cmd)
+
+		AddShowCmd(noun, "Show details about "+what,
+			entity+" (NAMESPACE/NAME)")
+	}
+}
+
+func setupServiceBusResourceDefs() {
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.ServiceBus/namespaces",
+		URL:  "https://management.azure.com/subscriptions/${SUBSCRIPTION}/resourceGroups/${RESOURCEGROUP}/providers/Microsoft.ServiceBus/namespaces/${NAME}?api-version=${APIVERSION}",
+		Defaults: map[string]string{
+			"APIVERSION": "2021-11-01",
+			"WAIT":       "true",
+		},
+		Actions: map[string]string{
+			"keys": "authorizationRules/RootManageSharedAccessKey/listKeys",
+		},
+	})
+	ResourceAliases["servicebus"] = "Microsoft.ServiceBus/namespaces"
+
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.ServiceBus/namespaces/queues",
+		Defaults: map[string]string{
+			"APIVERSION": "2021-11-01",
+		},
+	})
+	ResourceAliases["servicebus-queue"] = "Microsoft.ServiceBus/namespaces/queues"
+
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.ServiceBus/namespaces/topics",
+		Defaults: map[string]string{
+			"APIVERSION": "2021-11-01",
+		},
+	})
+	ResourceAliases["servicebus-topic"] = "Microsoft.ServiceBus/namespaces/topics"
+}
+
+// Points the Dapr component at the namespace, via its connection string.
+// Pub/sub defaults to using topics.
+func DaprFromServiceBus(comp *DaprComponent, name string) {
+	nsRef := ServiceBusRef(name, comp.Subscription, comp.ResourceGroup)
+	props := comp.MustProperties()
+	if props.ComponentType == nil {
+		props.ComponentType = StringPtr("pubsub.azure.servicebus.topics")
+	}
+
+	SetSecret(&props.Secrets, &AcaAppSecret{
+		Name:  StringPtr("connection-string"),
+		Value: StringPtr(nsRef.AsSubstitution("keys.primaryConnectionString")),
+	})
+	comp.SetMetadata("connectionString", "", "connection-string")
+}
+
+// Returns a reference to a Service Bus namespace. If it's in the stage then
+// its sub/rg are used.
+func ServiceBusRef(name string, sub string, rg string) *ResourceReference {
+	resRef := &ResourceReference{
+		Subscription:  sub,
+		ResourceGroup: rg,
+		Type:          "Microsoft.ServiceBus/namespaces",
+		APIVersion:    GetResourceDef("Microsoft.ServiceBus/namespaces").Defaults["APIVERSION"],
+		Name:          name,
+		Origin:        name,
+	}
+
+	stage := GetConfigProperty("currentStage")
+	if res, err := ResourceFromFile(stage, ResourceFileName("servicebus", name)); err == nil {
+		resRef.Subscription = res.Subscription
+		resRef.ResourceGroup = res.ResourceGroup
+	}
+
+	return resRef
+}
+
+type ServiceBusSku struct {
+	Name *string `json:"name,omitempty"`
+	Tier *string `json:"tier,omitempty"`
+}
+
+type ServiceBus struct {
+	ResourceBase
+
+	Location *string        `json:"location,omitempty"`
+	Sku      *ServiceBusSku `json:"sku,omitempty"`
+}
+
+func (sb *ServiceBus) MarshalJSON() ([]byte, error) {
+	tmpSb := *sb
+	if WhyMarshal == "ARM" {
+		if tmpSb.Location == nil {
+			tmpSb.Location = StringPtr(GetConfigProperty("defaults.Location"))
+		}
+		if tmpSb.Location == nil || *(tmpSb.Location) == "" {
+			ErrStop(`Missing "location" for "%s/%s"`, sb.NiceType, sb.Name)
+		}
+
+		// Topics need at least "Standard"
+		sku := ServiceBusSku{Name: StringPtr("Standard")}
+		if sb.Sku != nil && sb.Sku.Name != nil {
+			sku = *(sb.Sku)
+		}
+		if sku.Tier == nil {
+			sku.Tier = sku.Name
+		}
+		tmpSb.Sku = &sku
+	}
+	return json.Marshal(tmpSb)
+}
+
+func (sb *ServiceBus) DependsOn() []*ResourceReference {
+	return []*ResourceReference{}
+}
+
+func (sb *ServiceBus) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*ServiceBus(" + sb.Name + ")"
+	form.AddProp("Name", sb.Name)
+	if NotNil(sb.Location) != "" {
+		form.AddProp("Location", NotNil(sb.Location))
+	}
+	form.AddProp("Subscription", sb.Subscription)
+	form.AddProp("ResourceGroup", sb.ResourceGroup)
+	if sb.Sku != nil && sb.Sku.Name != nil {
+		form.AddProp("SKU", *(sb.Sku.Name))
+	}
+
+	return form
+}
+
+func (sb *ServiceBus) FromForm(r *ResourceBase, f *Form) {
+	newSb := &ServiceBus{
+		ResourceBase: sb.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name":
+			// Skip
+		case "Location":
+			newSb.Location = StringPtr(item.Value)
+		case "Subscription":
+			newSb.Subscription = item.Value
+		case "ResourceGroup":
+			newSb.ResourceGroup = item.Value
+		case "SKU":
+			newSb.Sku = &ServiceBusSku{Name: StringPtr(item.Value)}
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newSb, "", "  ")
+
+	r.Object = newSb
+	r.RawData = data
+}
+
+func (sb *ServiceBus) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(sb, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (sb *ServiceBus) ToJson() string {
+	data, _ := json.MarshalIndent(sb, "", "  ")
+	return string(data)
+}
+
+func (sb *ServiceBus) HideServerFields() {
+	// Azure always returns the tier, and we always send it, so we only keep
+	// it if it's not the default
+	if sb.Sku != nil && NotNil(sb.Sku.Tier) == NotNil(sb.Sku.Name) {
+		sb.Sku.Tier = nil
+	}
+}
+
+func AddServiceBusFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddServiceBusFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddServiceBusFunc")
+
+	sb := &ServiceBus{}
+	name, _ := cmd.Flags().GetString("name")
+	sb.InitResource(sb, "Microsoft.ServiceBus/namespaces", "servicebus", name)
+	sb.Location = StringPtr(GetConfigProperty("defaults.Location"))
+
+	sb.ProcessFlags(cmd)
+	sb.SaveAndUp(cmd)
+}
+
+func UpdateServiceBusFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdateServiceBusFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdateServiceBusFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	sb := LoadStageResource("servicebus", name).Object.(*ServiceBus)
+
+	sb.ProcessFlags(cmd)
+	sb.SaveAndUp(cmd)
+}
+
+func (sb *ServiceBus) ProcessFlags(cmd *cobra.Command) {
+	sb.ProcessResourceFlags(cmd, &sb.Location)
+
+	if cmd.Flags().Changed("sku") {
+		sku := FlagAsString(cmd, "sku")
+		if sku == "" {
+			sb.Sku = nil
+		} else {
+			sb.Sku = &ServiceBusSku{Name: StringPtr(sku)}
+		}
+	}
+}
+
+// ---
+
+// Queues and topics, the NiceType says which one it is
+type ServiceBusEntityProperties struct {
+	MaxSizeInMegabytes *int `json:"maxSizeInMegabytes,omitempty"`
+	MaxDeliveryCount   *int `json:"maxDeliveryCount,omitempty"`
+}
+
+type ServiceBusEntity struct {
+	ResourceBase
+
+	Properties *ServiceBusEntityProperties `json:"properties,omitempty"`
+}
+
+func (sbe *ServiceBusEntity) NamespaceName() string {
+	ns, _, _ := strings.Cut(sbe.Name, "/")
+	return ns
+}
+
+func (sbe *ServiceBusEntity) EntityName() string {
+	_, name, _ := strings.Cut(sbe.Name, "/")
+	return name
+}
+
+func (sbe *ServiceBusEntity) MustProperties() *ServiceBusEntityProperties {
+	if sbe.Properties == nil {
+		sbe.Properties = &ServiceBusEntityProperties{}
+	}
+	return sbe.Properties
+}
+
+func (sbe *ServiceBusEntity) DependsOn() []*ResourceReference {
+	return []*ResourceReference{{
+		Subscription:  sbe.Subscription,
+		ResourceGroup: sbe.ResourceGroup,
+		Type:          "Microsoft.ServiceBus/namespaces",
+		APIVersion:    GetResourceDef("Microsoft.ServiceBus/namespaces").Defaults["APIVERSION"],
+		Name:          sbe.NamespaceName(),
+	}}
+}
+
+func (sbe *ServiceBusEntity) ToForm() *Form {
+	entity, _ := strings.CutPrefix(sbe.NiceType, "servicebus-")
+
+	form := NewForm()
+	form.Title = "*ServiceBus-" + AcaServiceTitle(entity) + "(" + sbe.Name + ")"
+	form.AddProp("Name", sbe.EntityName())
+	form.AddProp("Namespace", sbe.NamespaceName())
+	form.AddProp("Subscription", sbe.Subscription)
+	form.AddProp("ResourceGroup", sbe.ResourceGroup)
+	if props := sbe.Properties; props != nil {
+		if props.MaxSizeInMegabytes != nil {
+			form.AddProp("Max Size", fmt.Sprintf("%d", *(props.MaxSizeInMegabytes)))
+		}
+		if props.MaxDeliveryCount != nil {
+			form.AddProp("Max Delivery Count",
+				fmt.Sprintf("%d", *(props.MaxDeliveryCount)))
+		}
+	}
+
+	return form
+}
+
+func (sbe *ServiceBusEntity) FromForm(r *ResourceBase, f *Form) {
+	newSbe := &ServiceBusEntity{
+		ResourceBase: sbe.ResourceBase,
+	}
+
+	atoi := func(item *Form) *int {
+		i, err := strconv.Atoi(item.Value)
+		NoErr(err, "%q must be an integer: %s", item.Title, item.Value)
+		return &i
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name", "Namespace":
+			// Skip
+		case "Subscription":
+			newSbe.Subscription = item.Value
+		case "ResourceGroup":
+			newSbe.ResourceGroup = item.Value
+		case "Max Size":
+			newSbe.MustProperties().MaxSizeInMegabytes = atoi(item)
+		case "Max Delivery Count":
+			newSbe.MustProperties().MaxDeliveryCount = atoi(item)
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newSbe, "", "  ")
+
+	r.Object = newSbe
+	r.RawData = data
+}
+
+func (sbe *ServiceBusEntity) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(sbe, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (sbe *ServiceBusEntity) ToJson() string {
+	data, _ := json.MarshalIndent(sbe, "", "  ")
+	return string(data)
+}
+
+func (sbe *ServiceBusEntity) HideServerFields() {
+	// Azure always returns these, so we only keep them if they're not the
+	// defaults (1024MB and 10)
+	props := sbe.Properties
+	if props == nil {
+		return
+	}
+	if size := props.MaxSizeInMegabytes; size != nil && *size == 1024 {
+		props.MaxSizeInMegabytes = nil
+	}
+	if count := props.MaxDeliveryCount; count != nil && *count == 10 {
+		props.MaxDeliveryCount = nil
+	}
+	if *props == (ServiceBusEntityProperties{}) {
+		sbe.Properties = nil
+	}
+}
+
+func ServiceBusFromARMJson(data []byte) *ResourceBase {
+	sb := &ServiceBus{}
+	if res := ParseARMResource(data, "Microsoft.ServiceBus/namespaces",
+		"servicebus", sb, &sb.ResourceBase); res != nil {
+		return res
+	}
+
+	for _, entity := range []string{"queue", "topic"} {
+		sbe := &ServiceBusEntity{}
+		if res := ParseARMResource(data,
+			"Microsoft.ServiceBus/namespaces/"+entity+"s",
+			"servicebus-"+entity, sbe, &sbe.ResourceBase); res != nil {
+			return res
+		}
+	}
+
+	return nil
+}
+
+func AddServiceBusEntityFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddServiceBusEntityFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddServiceBusEntityFunc")
+
+	entity, _ := strings.CutPrefix(cmd.CalledAs(), "servicebus-")
+	name, _ := cmd.Flags().GetString("name")
+	name = ChildName(name, "", "NAMESPACE")
+
+	sbe := &ServiceBusEntity{}
+	sbe.InitResource(sbe, "Microsoft.ServiceBus/namespaces/"+entity+"s",
+		cmd.CalledAs(), name)
+
+	// Default to the namespace's sub/rg if it's in the stage
+	nsRef := ServiceBusRef(sbe.NamespaceName(), sbe.Subscription,
+		sbe.ResourceGroup)
+	sbe.Subscription = nsRef.Subscription
+	sbe.ResourceGroup = nsRef.ResourceGroup
+
+	sbe.ProcessFlags(cmd)
+	sbe.SaveAndUp(cmd)
+}
+
+func UpdateServiceBusEntityFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdateServiceBusEntityFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdateServiceBusEntityFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	name = ChildName(name, "", "NAMESPACE")
+	sbe := LoadStageResource(cmd.CalledAs(), name).Object.(*ServiceBusEntity)
+
+	sbe.ProcessFlags(cmd)
+	sbe.SaveAndUp(cmd)
+}
+
+func (sbe *ServiceBusEntity) ProcessFlags(cmd *cobra.Command) {
+	sbe.ProcessResourceFlags(cmd, nil)
+
+	setInt := func(flag string, prop **int) {
+		if !cmd.Flags().Changed(flag) {
+			return
+		}
+		val := FlagAsString(cmd, flag)
+		if val == "" {
+			*prop = nil
+			return
+		}
+		i, err := strconv.Atoi(val)
+		NoErr(err, "Bad --%s value %q: %s", flag, val, err)
+		*prop = &i
+	}
+
+	setInt("max-size", &sbe.MustProperties().MaxSizeInMegabytes)
+	if cmd.Flags().Lookup("max-delivery-count") != nil {
+		setInt("max-delivery-count", &sbe.MustProperties().MaxDeliveryCount)
+	}
+	if *(sbe.Properties) == (ServiceBusEntityProperties{}) {
+		sbe.Properties = nil
+	}
+}
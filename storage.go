@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/duglin/dlog"
+	"github.com/spf13/cobra"
+)
+
+func initStorage() {
+	log.VPrintf(3, "Init initStorage")
+	setupStorageCmds()
+	setupStorageResourceDefs()
+	RegisteredParsers = append(RegisteredParsers, StorageFromARMJson)
+	DaprComponentSources["storage"] = DaprFromStorage
+}
+
+func setupStorageCmds() {
+	cmd := &cobra.Command{
+		Use:   "storage",
+		Short: "Add an Azure Storage Account",
+		Run:   AddStorageFunc,
+	}
+	AddResourceFlags(cmd, "storage account")
+	cmd.Flags().String("sku", "", "e.g. 'Standard_LRS', 'Premium_LRS'")
+	cmd.Flags().String("kind", "", "e.g. 'StorageV2', 'FileStorage'")
+	AddCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:   "storage",
+		Short: "Update an Azure Storage Account",
+		Run:   UpdateStorageFunc,
+	}
+	AddResourceFlags(cmd, "storage account")
+	cmd.Flags().String("sku", "", "e.g. 'Standard_LRS', 'Premium_LRS'")
+	cmd.Flags().String("kind", "", "e.g. 'StorageV2', 'FileStorage'")
+	UpdateCmd.AddCommand(cmd)
+
+	AddShowCmd("storage", "Show details about an Azure Storage Account",
+		"storage account")
+
+	// ---
+
+	cmd = &cobra.Command{
+		Use:   "storage-share",
+		Short: "Add an Azure Files share to a Storage Account",
+		Run:   AddStorageShareFunc,
+	}
+	AddResourceFlags(cmd, "share (ACCOUNT/SHARE)")
+	cmd.Flags().String("quota", "", "Max size of the share in GiB")
+	AddCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:   "storage-share",
+		Short: "Update an Azure Files share",
+		Run:   UpdateStorageShareFunc,
+	}
+	AddResourceFlags(cmd, "share (ACCOUNT/SHARE)")
+	cmd.Flags().String("quota", "", "Max size of the share in GiB")
+	UpdateCmd.AddCommand(cmd)
+
+	AddShowCmd("storage-share", "Show details about an Azure Files share",
+		"share (ACCOUNT/SHARE)")
+}
+
+func setupStorageResourceDefs() {
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.Storage/storageAccounts",
+		URL:  "https://management.azure.com/subscriptions/${SUBSCRIPTION}/resourceGroups/${RESOURCEGROUP}/providers/Microsoft.Storage/storageAccounts/${NAME}?api-version=${APIVERSION}",
+		Defaults: map[string]string{
+			"APIVERSION": "2023-01-01",
+			"WAIT":       "true",
+		},
+		Actions: map[string]string{
+			"keys": "listKeys",
+		},
+	})
+	ResourceAliases["storage"] = "Microsoft.Storage/storageAccounts"
+
+	AddResourceDef(&ResourceDef{
+		Type: "Microsoft.Storage/storageAccounts/fileServices/shares",
+		Defaults: map[string]string{
+			"APIVERSION": "2023-01-01",
+		},
+	})
+	ResourceAliases["storage-share"] =
+		"Microsoft.Storage/storageAccounts/fileServices/shares"
+}
+
+// Returns a reference to a storage account. If it's in the stage then its
+// sub/rg are used.
+func StorageRef(name string, sub string, rg string) *ResourceReference {
+	resRef := &ResourceReference{
+		Subscription:  sub,
+		ResourceGroup: rg,
+		Type:          "Microsoft.Storage/storageAccounts",
+		APIVersion:    GetResourceDef("Microsoft.Storage/storageAccounts").Defaults["APIVERSION"],
+		Name:          name,
+		Origin:        name,
+	}
+
+	stage := GetConfigProperty("currentStage")
+	if res, err := ResourceFromFile(stage, ResourceFileName("storage", name)); err == nil {
+		resRef.Subscription = res.Subscription
+		resRef.ResourceGroup = res.ResourceGroup
+	}
+
+	return resRef
+}
+
+// Points the Dapr component at the account's blob storage. The container
+// defaults to the component's name and Dapr creates it if it's missing.
+func DaprFromStorage(comp *DaprComponent, name string) {
+	stRef := StorageRef(name, comp.Subscription, comp.ResourceGroup)
+	props := comp.MustProperties()
+	if props.ComponentType == nil {
+		props.ComponentType = StringPtr("state.azure.blobstorage")
+	}
+
+	SetSecret(&props.Secrets, &AcaAppSecret{
+		Name:  StringPtr("account-key"),
+		Value: StringPtr(stRef.AsSubstitution("keys.keys[0].value")),
+	})
+	comp.SetMetadata("accountName", name, "")
+	comp.SetMetadata("accountKey", "", "account-key")
+	if comp.FindMetadata("containerName") == nil {
+		comp.SetMetadata("containerName", comp.ComponentName(), "")
+	}
+}
+
+type StorageSku struct {
+	Name *string `json:"name,omitempty"`
+}
+
+type Storage struct {
+	ResourceBase
+
+	Location *string     `json:"location,omitempty"`
+	Sku      *StorageSku `json:"sku,omitempty"`
+	Kind     *string     `json:"kind,omitempty"`
+}
+
+func (st *Storage) MarshalJSON() ([]byte, error) {
+	tmpSt := *st
+	if WhyMarshal == "ARM" {
+		if tmpSt.Location == nil {
+			tmpSt.Location = StringPtr(GetConfigProperty("defaults.Location"))
+		}
+		if tmpSt.Location == nil || *(tmpSt.Location) == "" {
+			ErrStop(`Missing "location" for "%s/%s"`, st.NiceType, st.Name)
+		}
+		if tmpSt.Sku == nil || tmpSt.Sku.Name == nil {
+			tmpSt.Sku = &StorageSku{Name: StringPtr("Standard_LRS")}
+		}
+		if tmpSt.Kind == nil {
+			tmpSt.Kind = StringPtr("StorageV2")
+		}
+	}
+	return json.Marshal(tmpSt)
+}
+
+func (st *Storage) DependsOn() []*ResourceReference {
+	return []*ResourceReference{}
+}
+
+func (st *Storage) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*Storage(" + st.Name + ")"
+	form.AddProp("Name", st.Name)
+	if NotNil(st.Location) != "" {
+		form.AddProp("Location", NotNil(st.Location))
+	}
+	form.AddProp("Subscription", st.Subscription)
+	form.AddProp("ResourceGroup", st.ResourceGroup)
+	if st.Sku != nil && st.Sku.Name != nil {
+		form.AddProp("SKU", *(st.Sku.Name))
+	}
+	if st.Kind != nil {
+		form.AddProp("Kind", *(st.Kind))
+	}
+
+	return form
+}
+
+func (st *Storage) FromForm(r *ResourceBase, f *Form) {
+	newSt := &Storage{
+		ResourceBase: st.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name":
+			// Skip
+		case "Location":
+			newSt.Location = StringPtr(item.Value)
+		case "Subscription":
+			newSt.Subscription = item.Value
+		case "ResourceGroup":
+			newSt.ResourceGroup = item.Value
+		case "SKU":
+			newSt.Sku = &StorageSku{Name: StringPtr(item.Value)}
+		case "Kind":
+			newSt.Kind = StringPtr(item.Value)
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newSt, "", "  ")
+
+	r.Object = newSt
+	r.RawData = data
+}
+
+func (st *Storage) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(st, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (st *Storage) ToJson() string {
+	data, _ := json.MarshalIndent(st, "", "  ")
+	return string(data)
+}
+
+func (st *Storage) HideServerFields() {
+}
+
+func AddStorageFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddStorageFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddStorageFunc")
+
+	st := &Storage{}
+	name, _ := cmd.Flags().GetString("name")
+	st.InitResource(st, "Microsoft.Storage/storageAccounts", "storage", name)
+	st.Location = StringPtr(GetConfigProperty("defaults.Location"))
+
+	st.ProcessFlags(cmd)
+	st.SaveAndUp(cmd)
+}
+
+func UpdateStorageFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdateStorageFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdateStorageFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	st := LoadStageResource("storage", name).Object.(*Storage)
+
+	st.ProcessFlags(cmd)
+	st.SaveAndUp(cmd)
+}
+
+func (st *Storage) ProcessFlags(cmd *cobra.Command) {
+	st.ProcessResourceFlags(cmd, &st.Location)
+
+	SetStringProp(st, cmd.Flags(), "sku", `{"sku":{"name":%s}}`)
+	SetStringProp(st, cmd.Flags(), "kind", `{"kind":%s}`)
+}
+
+// ---
+
+type StorageShareProperties struct {
+	ShareQuota *int `json:"shareQuota,omitempty"`
+}
+
+type StorageShare struct {
+	ResourceBase
+
+	Properties *StorageShareProperties `json:"properties,omitempty"`
+}
+
+// "acct/share" -> "acct/default/share"
+func StorageShareARMName(name string) string {
+	acct, share, _ := strings.Cut(name, "/")
+	return acct + "/default/" + share
+}
+
+func (ss *StorageShare) AccountName() string {
+	acct, _, _ := strings.Cut(ss.Name, "/")
+	return acct
+}
+
+func (ss *StorageShare) ShareName() string {
+	return ss.Name[strings.LastIndex(ss.Name, "/")+1:]
+}
+
+func (ss *StorageShare) MustProperties() *StorageShareProperties {
+	if ss.Properties == nil {
+		ss.Properties = &StorageShareProperties{}
+	}
+	return ss.Properties
+}
+
+func (ss *StorageShare) DependsOn() []*ResourceReference {
+	return []*ResourceReference{{
+		Subscription:  ss.Subscription,
+		ResourceGroup: ss.ResourceGroup,
+		Type:          "Microsoft.Storage/storageAccounts",
+		APIVersion:    GetResourceDef("Microsoft.Storage/storageAccounts").Defaults["APIVERSION"],
+		Name:          ss.AccountName(),
+	}}
+}
+
+func (ss *StorageShare) ToForm() *Form {
+	form := NewForm()
+	form.Title = "*Storage-Share(" + ss.Name + ")"
+	form.AddProp("Name", ss.ShareName())
+	form.AddProp("Account", ss.AccountName())
+	form.AddProp("Subscription", ss.Subscription)
+	form.AddProp("ResourceGroup", ss.ResourceGroup)
+	if ss.Properties != nil && ss.Properties.ShareQuota != nil {
+		form.AddProp("Quota", fmt.Sprintf("%d", *(ss.Properties.ShareQuota)))
+	}
+
+	return form
+}
+
+func (ss *StorageShare) FromForm(r *ResourceBase, f *Form) {
+	newSs := &StorageShare{
+		ResourceBase: ss.ResourceBase,
+	}
+
+	for _, item := range f.Items {
+		switch item.Title {
+		case "Name", "Account":
+			// Skip
+		case "Subscription":
+			newSs.Subscription = item.Value
+		case "ResourceGroup":
+			newSs.ResourceGroup = item.Value
+		case "Quota":
+			q, err := strconv.Atoi(item.Value)
+			NoErr(err, "Bad Quota value %q: %s", item.Value, err)
+			newSs.MustProperties().ShareQuota = &q
+		default:
+			panic("Unknown item: " + item.Title)
+		}
+	}
+
+	data, _ := json.MarshalIndent(newSs, "", "  ")
+
+	r.Object = newSs
+	r.RawData = data
+}
+
+func (ss *StorageShare) ToARMJson() string {
+	WhyMarshal = "ARM"
+	data, _ := json.MarshalIndent(ss, "", "  ")
+	WhyMarshal = ""
+
+	return string(data)
+}
+
+func (ss *StorageShare) ToJson() string {
+	data, _ := json.MarshalIndent(ss, "", "  ")
+	return string(data)
+}
+
+func (ss *StorageShare) HideServerFields() {
+}
+
+func StorageFromARMJson(data []byte) *ResourceBase {
+	st := &Storage{}
+	if res := ParseARMResource(data, "Microsoft.Storage/storageAccounts",
+		"storage", st, &st.ResourceBase); res != nil {
+		return res
+	}
+
+	ss := &StorageShare{}
+	if res := ParseARMResource(data,
+		"Microsoft.Storage/storageAccounts/fileServices/shares",
+		"storage-share", ss, &ss.ResourceBase); res != nil {
+		return res
+	}
+
+	return nil
+}
+
+func AddStorageShareFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: AddStorageShareFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: AddStorageShareFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	name = ChildName(name, "", "ACCOUNT")
+
+	ss := &StorageShare{}
+	ss.InitResource(ss, "Microsoft.Storage/storageAccounts/fileServices/shares",
+		"storage-share", StorageShareARMName(name))
+	ss.Filename = ResourceFileName("storage-share", name)
+
+	ss.ProcessFlags(cmd)
+	ss.SaveAndUp(cmd)
+}
+
+func UpdateStorageShareFunc(cmd *cobra.Command, args []string) {
+	log.VPrintf(2, ">Enter: UpdateStorageShareFunc (%q)", args)
+	defer log.VPrintf(2, "<Exit: UpdateStorageShareFunc")
+
+	name, _ := cmd.Flags().GetString("name")
+	name = ChildName(name, "", "ACCOUNT")
+	ss := LoadStageResource("storage-share", name).Object.(*StorageShare)
+
+	ss.ProcessFlags(cmd)
+	ss.SaveAndUp(cmd)
+}
+
+func (ss *StorageShare) ProcessFlags(cmd *cobra.Command) {
+	ss.ProcessResourceFlags(cmd, nil)
+
+	if cmd.Flags().Changed("quota") {
+		quota, _ := cmd.Flags().GetString("quota")
+		if quota == "" {
+			ss.MustProperties().ShareQuota = nil
+		} else {
+			q, err := strconv.Atoi(quota)
+			NoErr(err, "Bad --quota value %q: %s", quota, err)
+			ss.MustProperties().ShareQuota = &q
+		}
+	}
+}
@@ -5,9 +5,11 @@ import (
 
 	"bufio"
 	"bytes"
+	"crypto/sha1"
 	"fmt"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -54,6 +56,51 @@ func Prompt(str string) byte { // lowercase letter
 	return b[0]
 }
 
+// Parses "dotenv" data: NAME=VALUE lines, with optional quotes around the
+// value. Blank lines and lines starting with "#" are skipped.
+func ParseDotEnv(data []byte) map[string]string {
+	result := map[string]string{}
+	for _, env := range DotEnvVars(data) {
+		name, value, _ := strings.Cut(env, "=")
+		result[name] = value
+	}
+	return result
+}
+
+// Same as ParseDotEnv but returns the "NAME=VALUE"s in the order they
+// appear in "data"
+func DotEnvVars(data []byte) []string {
+	result := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line, _ = strings.CutPrefix(line, "export ")
+		name, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') &&
+			value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		result = append(result, strings.TrimSpace(name)+"="+value)
+	}
+	return result
+}
+
+// The reverse of DotEnvVars, for one var. The value is quoted if
+// DotEnvVars would otherwise change it.
+func DotEnvLine(name string, value string) string {
+	if value != strings.TrimSpace(value) ||
+		(value != "" && (value[0] == '"' || value[0] == '\'')) {
+		value = `"` + value + `"`
+	}
+	return name + "=" + value
+}
+
 func BoolPtr(val bool) *bool       { return &val }
 func IntPtr(val int) *int          { return &val }
 func StringPtr(str string) *string { return &str }
@@ -64,6 +111,23 @@ func NilStringPtr(str string) *string {
 	return &str
 }
 
+func IntPtrString(val *int) string {
+	if val == nil {
+		return ""
+	}
+	return strconv.Itoa(*val)
+}
+
+// Returns a UUID (v5 style) that's always the same for the same strings.
+// Used for resources, like role assignments, whose names must be UUIDs.
+func NameUUID(parts ...string) string {
+	sum := sha1.Sum([]byte(strings.ToLower(strings.Join(parts, "|"))))
+	sum[6] = (sum[6] & 0x0f) | 0x50
+	sum[8] = (sum[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8],
+		sum[8:10], sum[10:16])
+}
+
 func QuoteStrings(strs []string) string {
 	res := ""
 	for i, s := range strs {
@@ -72,8 +136,8 @@ func QuoteStrings(strs []string) string {
 		}
 		buf := bytes.Buffer{}
 		buf.WriteString("\"")
-		for ch := range s {
-			if ch == '"' {
+		for _, ch := range []byte(s) {
+			if ch == '"' || ch == '\\' {
 				buf.WriteString("\\")
 			}
 			buf.WriteByte(byte(ch))
@@ -84,38 +148,62 @@ func QuoteStrings(strs []string) string {
 	return res
 }
 
+// Splits "str" into words, the reverse of QuoteStrings. Like a shell, words
+// are separated by spaces and can be quoted with "s (where \" is a quote)
+// or 's.
 func ParseQuotedString(str string) []string {
+	return SplitQuoted(str, ' ')
+}
+
+// Same as ParseQuotedString but with "sep" between the words, e.g. a ','
+func SplitQuoted(str string, sep byte) []string {
 	words := []string{}
 
 	word := bytes.Buffer{}
-	inQuote := false
+	inWord := false
+	quote := byte(0)
 	esc := false
-	for ch := range str {
-		if !inQuote && ch != '"' && ch != ' ' {
-			panic(fmt.Sprintf("Bad char %q in %q", ch, str))
+	for _, ch := range []byte(str) {
+		if quote == 0 {
+			switch ch {
+			case sep:
+				if inWord {
+					words = append(words, word.String())
+					word.Reset()
+					inWord = false
+				}
+			case '"', '\'':
+				quote = ch
+				inWord = true
+			default:
+				word.WriteByte(ch)
+				inWord = true
+			}
+			continue
 		}
-		if ch == '\\' {
+
+		if quote == '"' && ch == '\\' {
 			if esc {
 				word.WriteByte('\\')
 			}
 			esc = !esc
 			continue
 		}
-		if ch == '"' {
-			if !inQuote {
-				inQuote = true
-				continue
-			}
-			inQuote = false
-			words = append(words, word.String())
-			word.Reset()
+		if ch == quote && !esc {
+			quote = 0
 			continue
 		}
-		if esc {
+		if esc && ch != '"' {
 			word.WriteByte('\\')
-			esc = false
 		}
-		word.WriteByte(byte(ch))
+		esc = false
+		word.WriteByte(ch)
+	}
+	if quote != 0 {
+		ErrStop("Missing closing quote (%c) in: %s", quote, str)
+	}
+	if inWord {
+		words = append(words, word.String())
 	}
 
 	if len(words) == 0 {
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// ErrStop exits, so to check that "fn" fails it's run again in a child
+// process that only runs this test, and only the case named "id"
+func expectErrStop(t *testing.T, id string, fn func()) {
+	t.Helper()
+	if env := os.Getenv("AZX_ERRSTOP"); env != "" {
+		if env == t.Name()+"/"+id {
+			fn()
+			os.Exit(0)
+		}
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$")
+	cmd.Env = append(os.Environ(), "AZX_ERRSTOP="+t.Name()+"/"+id)
+	if err := cmd.Run(); err == nil {
+		t.Errorf("%s: should have failed", id)
+	} else if _, ok := err.(*exec.ExitError); !ok {
+		t.Fatalf("%s: running the test: %s", id, err)
+	}
+}
+
+func TestSplitQuoted(t *testing.T) {
+	tests := []struct {
+		str   string
+		sep   byte
+		words []string
+	}{
+		{"", ',', nil},
+		{"a", ',', []string{"a"}},
+		{"a,b", ',', []string{"a", "b"}},
+		{"a,,b,", ',', []string{"a", "b"}},
+		{"a b", ',', []string{"a b"}},
+		{`k="1,2",x`, ',', []string{"k=1,2", "x"}},
+		{`k='1,2',x`, ',', []string{"k=1,2", "x"}},
+		{`"a\"b"`, ',', []string{`a"b`}},
+		{`"a\\b"`, ',', []string{`a\b`}},
+		{`"a\b"`, ',', []string{`a\b`}},
+		{`'a\"b'`, ',', []string{`a\"b`}},
+		{`""`, ',', []string{""}},
+		{`a "b c" d`, ' ', []string{"a", "b c", "d"}},
+		{`  a   b  `, ' ', []string{"a", "b"}},
+	}
+
+	for _, test := range tests {
+		words := SplitQuoted(test.str, test.sep)
+		if !reflect.DeepEqual(words, test.words) {
+			t.Errorf("SplitQuoted(%q, %q) = %q, should be %q", test.str,
+				test.sep, words, test.words)
+		}
+	}
+
+	expectErrStop(t, "unclosed", func() { SplitQuoted(`a,"b`, ',') })
+	expectErrStop(t, "unclosed-single", func() { SplitQuoted(`'a`, ',') })
+}
+
+func TestQuoteStrings(t *testing.T) {
+	tests := [][]string{
+		{"a"},
+		{"a", "b c"},
+		{`a"b`, `c\d`, ""},
+		{"tab\there", "x,y"},
+	}
+
+	for _, strs := range tests {
+		str := QuoteStrings(strs)
+		if words := ParseQuotedString(str); !reflect.DeepEqual(words, strs) {
+			t.Errorf("ParseQuotedString(QuoteStrings(%q)) = %q (via %s)",
+				strs, words, str)
+		}
+	}
+}
+
+func TestDotEnvVars(t *testing.T) {
+	data := []byte(strings.Join([]string{
+		"# comment",
+		"A=1",
+		"",
+		"  B = two words  ",
+		"export C=3",
+		`D="quoted # not a comment"`,
+		"E='single'",
+		`F="`,
+		"G=a=b",
+		"H=",
+		"no equals",
+		`I=" spaced "`,
+	}, "\n"))
+	vars := []string{
+		"A=1",
+		"B=two words",
+		"C=3",
+		"D=quoted # not a comment",
+		"E=single",
+		`F="`,
+		"G=a=b",
+		"H=",
+		"I= spaced ",
+	}
+
+	if got := DotEnvVars(data); !reflect.DeepEqual(got, vars) {
+		t.Errorf("DotEnvVars:\n got: %q\nwant: %q", got, vars)
+	}
+
+	env := ParseDotEnv(data)
+	if len(env) != len(vars) || env["G"] != "a=b" || env["H"] != "" {
+		t.Errorf("ParseDotEnv: %q", env)
+	}
+}
+
+func TestDotEnvLine(t *testing.T) {
+	tests := []struct{ value, line string }{
+		{"", "N="},
+		{"1", "N=1"},
+		{"a b", "N=a b"},
+		{" a", `N=" a"`},
+		{"a ", `N="a "`},
+		{`"q"`, `N=""q""`},
+		{"'q'", `N="'q'"`},
+		{"secretref:s", "N=secretref:s"},
+	}
+
+	for _, test := range tests {
+		line := DotEnvLine("N", test.value)
+		if line != test.line {
+			t.Errorf("DotEnvLine(%q) = %s, should be %s", test.value, line,
+				test.line)
+		}
+		// Exported env files have to read back in the same
+		if got := ParseDotEnv([]byte(line))["N"]; got != test.value {
+			t.Errorf("DotEnvLine(%q) read back as %q", test.value, got)
+		}
+	}
+}
+
+func TestNameUUID(t *testing.T) {
+	uuidRE := regexp.MustCompile(
+		`^[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	id := NameUUID("/subscriptions/s/x", "role", "principal")
+	if !uuidRE.MatchString(id) {
+		t.Errorf("NameUUID = %q, isn't a v5 UUID", id)
+	}
+	// Azure IDs aren't case sensitive, so neither are the names
+	if again := NameUUID("/SUBSCRIPTIONS/s/X", "Role", "principal"); again != id {
+		t.Errorf("NameUUID isn't case insensitive: %q vs %q", id, again)
+	}
+	for _, parts := range [][]string{
+		{"/subscriptions/s/x", "role", "other"},
+		{"/subscriptions/s/x", "roleprincipal"},
+		{"/subscriptions/s/x|role", "principal", ""},
+	} {
+		if other := NameUUID(parts...); other == id {
+			t.Errorf("NameUUID(%q) is the same as for different parts", parts)
+		}
+	}
+}